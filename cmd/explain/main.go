@@ -0,0 +1,103 @@
+// Command explain prints how MongoDB runs the ranked listing query for a few representative
+// filters, and for a page after a cursor: the winning plan and its execution statistics. It reads the MongoDB settings the same way as
+// the server, from the config file, the environment and the command line flags.
+//
+//	MONGO_HOST=localhost:27017 MONGO_DB=2024DcardBackend MONGO_COLLECTION=ads go run ./cmd/explain
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"os"
+	"time"
+
+	"ad-service-api/config"
+	"ad-service-api/database"
+	"ad-service-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// limit is the page size the listing is explained with, the API's default.
+const limit = 5
+
+// queries are the filters explained, from the broadest to the most targeted.
+var queries = []struct {
+	name  string
+	query models.AdQuery
+}{
+	{"no params", models.AdQuery{}},
+	{"age", models.AdQuery{Age: 25}},
+	{"gender", models.AdQuery{Gender: []string{"F"}}},
+	{"country", models.AdQuery{Country: []string{"TW"}}},
+	{"platform", models.AdQuery{Platform: []string{"ios"}}},
+	{"language", models.AdQuery{Language: []string{"zh-TW"}}},
+	{"region", models.AdQuery{Region: []string{"TW-TPE"}}},
+	{"all dimensions", models.AdQuery{Age: 25, Gender: []string{"F"}, Country: []string{"TW"}, Platform: []string{"ios"}}},
+	{"next page", models.AdQuery{Country: []string{"TW"}, After: &models.Cursor{Score: 1}}},
+}
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	// Only MongoDB is used, so the cache settings need not be given
+	cfg, err := config.Load(append([]string{"-cache-backend", config.BackendMemory}, os.Args[1:]...))
+	if err != nil {
+		slog.Error("failed to load configuration", "error", err)
+		return 1
+	}
+	if cfg.Storage.Backend != config.BackendMongo {
+		slog.Error("the storage backend must be mongo to explain queries")
+		return 1
+	}
+
+	ctx := context.Background()
+	mongo := cfg.Storage.Mongo
+	col, err := database.ConnectMongoDB(ctx, mongo.Username, mongo.Password, mongo.Host, mongo.Database, mongo.Collection)
+	if err != nil {
+		slog.Error("failed to connect", "error", err)
+		return 1
+	}
+	defer col.Database().Client().Disconnect(ctx)
+
+	now := time.Now()
+	for _, q := range queries {
+		q.query.Now = now
+		filter := database.CreateFilter(q.query)
+		explain, err := database.ExplainListing(ctx, col, filter, limit)
+		if err != nil {
+			slog.Error("failed to explain", "query", q.name, "error", err)
+			return 1
+		}
+
+		out, err := json.MarshalIndent(summarize(filter, explain), "", "  ")
+		if err != nil {
+			slog.Error("failed to encode explain output", "query", q.name, "error", err)
+			return 1
+		}
+		fmt.Printf("== %s\n%s\n\n", q.name, out)
+	}
+
+	return 0
+}
+
+// summarize keeps the parts of the explain output that tell whether the query is served by an
+// index: the winning plan and how many keys and documents were examined for the results.
+func summarize(filter bson.M, explain bson.M) bson.M {
+	planner, _ := explain["queryPlanner"].(bson.M)
+	stats, _ := explain["executionStats"].(bson.M)
+	return bson.M{
+		"filter":      filter,
+		"winningPlan": planner["winningPlan"],
+		"executionStats": bson.M{
+			"nReturned":           stats["nReturned"],
+			"executionTimeMillis": stats["executionTimeMillis"],
+			"totalKeysExamined":   stats["totalKeysExamined"],
+			"totalDocsExamined":   stats["totalDocsExamined"],
+		},
+	}
+}
@@ -0,0 +1,79 @@
+// Command rescore stores the score of every ad under the configured ranking weights, and a priority
+// of 0 on the ads stored without one, then drops the cached ad lists, which are ordered by the old
+// scores. Run it once after every replica has been rolled out with the new weights: a replica still
+// running the old ones keeps storing old scores on the ads it saves. It reads the settings the same
+// way as the server, from the config file, the environment and the command line flags.
+//
+//	MONGO_HOST=localhost:27017 REDIS_HOST=localhost:6379 RANKING_BUDGET_WEIGHT=0.1 go run ./cmd/rescore
+package main
+
+import (
+	"context"
+	"log/slog"
+	"os"
+
+	"ad-service-api/config"
+	"ad-service-api/database"
+	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/redis"
+)
+
+// pattern matches every cached ad list.
+const pattern = "ads:*"
+
+func main() {
+	os.Exit(run())
+}
+
+func run() int {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("failed to load configuration", "error", err)
+		return 1
+	}
+	if cfg.Storage.Backend != config.BackendMongo {
+		slog.Error("the storage backend must be mongo to rescore ads")
+		return 1
+	}
+
+	ctx := context.Background()
+	mongo := cfg.Storage.Mongo
+	col, err := database.ConnectMongoDB(ctx, mongo.Username, mongo.Password, mongo.Host, mongo.Database, mongo.Collection)
+	if err != nil {
+		slog.Error("failed to connect to mongodb", "error", err)
+		return 1
+	}
+	defer col.Database().Client().Disconnect(ctx)
+
+	rescored, err := database.Rescore(ctx, col, cfg.Ranking.Ranking())
+	if err != nil {
+		slog.Error("failed to rescore ads", "error", err)
+		return 1
+	}
+	slog.Info("rescored advertisements", "count", rescored)
+
+	// An in-memory cache lives in each replica, which only drops it when it restarts
+	if cfg.Cache.Backend != config.BackendRedis {
+		slog.Warn("the cache backend is not redis, so the cached ad lists keep their old order until they expire")
+		return 0
+	}
+	rdb, err := redis.ConnectRedis(ctx, cfg.Cache.Redis.Host, cfg.Cache.Redis.Password, cfg.Cache.Redis.DB)
+	if err != nil {
+		slog.Error("failed to connect to redis", "error", err)
+		return 1
+	}
+	defer rdb.Close()
+
+	// Bumping the cache generation also keeps a list fetched before the rescore from being cached
+	// after it, and the broadcast drops the copies the replicas hold in their local cache
+	if err := repository.NewAdRedisRepository(rdb).DeleteAdsByPattern(ctx, pattern, nil); err != nil {
+		slog.Error("failed to delete the cached ad lists", "error", err)
+		return 1
+	}
+	if err := repository.NewRedisInvalidationBus(rdb).Publish(ctx, pattern); err != nil {
+		slog.Error("failed to broadcast the invalidation of the cached ad lists", "error", err)
+		return 1
+	}
+	slog.Info("deleted the cached ad lists")
+	return 0
+}
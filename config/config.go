@@ -0,0 +1,442 @@
+package config
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log/slog"
+	"math"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"ad-service-api/internal/models"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// BackendMongo stores advertisements in MongoDB.
+	BackendMongo = "mongo"
+	// BackendRedis caches counters and ad lists in Redis.
+	BackendRedis = "redis"
+	// BackendMemory keeps data in process memory.
+	BackendMemory = "memory"
+
+	// ExporterOTLP sends spans to an OpenTelemetry collector over OTLP/HTTP.
+	ExporterOTLP = "otlp"
+	// ExporterStdout writes spans to stdout.
+	ExporterStdout = "stdout"
+	// ExporterNone drops spans.
+	ExporterNone = "none"
+)
+
+// Config is the typed configuration of the service.
+type Config struct {
+	Server  ServerConfig  `yaml:"server"`
+	Storage StorageConfig `yaml:"storage"`
+	Cache   CacheConfig   `yaml:"cache"`
+	Quota   QuotaConfig   `yaml:"quota"`
+	Ranking RankingConfig `yaml:"ranking"`
+	Log     LogConfig     `yaml:"log"`
+	Tracing TracingConfig `yaml:"tracing"`
+}
+
+type ServerConfig struct {
+	Addr string `yaml:"addr"`
+	// ConnectTimeout is how long startup keeps retrying to reach MongoDB and Redis.
+	ConnectTimeout time.Duration `yaml:"connectTimeout"`
+	// ShutdownTimeout is how long in-flight requests may drain after SIGTERM.
+	ShutdownTimeout time.Duration `yaml:"shutdownTimeout"`
+}
+
+type StorageConfig struct {
+	Backend string      `yaml:"backend"`
+	Mongo   MongoConfig `yaml:"mongo"`
+}
+
+type MongoConfig struct {
+	Username   string `yaml:"username"`
+	Password   string `yaml:"password"`
+	Host       string `yaml:"host"`
+	Database   string `yaml:"database"`
+	Collection string `yaml:"collection"`
+}
+
+type CacheConfig struct {
+	Backend string        `yaml:"backend"`
+	TTL     time.Duration `yaml:"ttl"`
+	// StaleTTL is how long an ad list is kept after it stops being fresh, to be served while
+	// MongoDB is unavailable. 0 turns stale serving off.
+	StaleTTL time.Duration `yaml:"staleTTL"`
+	// RefreshTimeout bounds the MongoDB queries refreshing an ad list, after which the stale list is
+	// served instead.
+	RefreshTimeout time.Duration `yaml:"refreshTimeout"`
+	// Local is the in-process cache in front of Redis.
+	Local LocalCacheConfig `yaml:"local"`
+	Redis RedisConfig      `yaml:"redis"`
+}
+
+type LocalCacheConfig struct {
+	// Size is the number of ad lists kept in process memory in front of Redis. 0 turns the local cache off.
+	Size int `yaml:"size"`
+	// TTL is how long a list is kept in process memory.
+	TTL time.Duration `yaml:"ttl"`
+}
+
+type RedisConfig struct {
+	Host     string `yaml:"host"`
+	Password string `yaml:"password"`
+	DB       int    `yaml:"db"`
+}
+
+// QuotaConfig holds the limits of the quota shared by the advertisers without an override, and the
+// overrides giving an advertiser a quota of its own.
+type QuotaConfig struct {
+	DailyLimit  int                    `yaml:"dailyLimit"`
+	ActiveLimit int                    `yaml:"activeLimit"`
+	Advertisers map[string]QuotaLimits `yaml:"advertisers"`
+}
+
+// RankingConfig weighs the terms of the score ranking the listed ads within their priority. The
+// ads saved before the weights changed keep their old scores until cmd/rescore is run.
+type RankingConfig struct {
+	BidWeight     float64 `yaml:"bidWeight"`
+	BudgetWeight  float64 `yaml:"budgetWeight"`
+	RecencyWeight float64 `yaml:"recencyWeight"`
+}
+
+// Ranking converts the configured weights into a models.Ranking.
+func (c RankingConfig) Ranking() models.Ranking {
+	return models.Ranking{
+		BidWeight:     c.BidWeight,
+		BudgetWeight:  c.BudgetWeight,
+		RecencyWeight: c.RecencyWeight,
+	}
+}
+
+type LogConfig struct {
+	// Level is the minimum level logged: debug, info, warn or error.
+	Level slog.Level `yaml:"level"`
+}
+
+type TracingConfig struct {
+	// Exporter is otlp, stdout or none. It defaults to otlp when Endpoint is set and to none otherwise.
+	Exporter string `yaml:"exporter"`
+	// Endpoint is the URL of the OTLP/HTTP collector, e.g. http://otel-collector:4318.
+	Endpoint string `yaml:"endpoint"`
+}
+
+// QuotaLimits overrides the quota limits of a single advertiser.
+type QuotaLimits struct {
+	DailyLimit  int `yaml:"dailyLimit"`
+	ActiveLimit int `yaml:"activeLimit"`
+}
+
+// Default returns the configuration used when nothing else is set.
+func Default() *Config {
+	return &Config{
+		Server:  ServerConfig{Addr: ":8080", ConnectTimeout: 30 * time.Second, ShutdownTimeout: 15 * time.Second},
+		Storage: StorageConfig{Backend: BackendMongo},
+		Cache:   CacheConfig{Backend: BackendRedis, TTL: time.Hour, StaleTTL: 15 * time.Minute, RefreshTimeout: 3 * time.Second, Local: LocalCacheConfig{TTL: time.Second}},
+		Quota:   QuotaConfig{DailyLimit: 3000, ActiveLimit: 1000},
+		Ranking: RankingConfig{BidWeight: 1},
+		Log:     LogConfig{Level: slog.LevelInfo},
+	}
+}
+
+// Load builds the configuration from the defaults, an optional YAML file, the environment
+// and the command line flags in args, each overriding the previous one, and validates it.
+// The YAML file is given by the -config flag or the CONFIG_FILE variable.
+func Load(args []string) (*Config, error) {
+	cfg := Default()
+
+	fs := flag.NewFlagSet("ad-service-api", flag.ContinueOnError)
+	configFile := fs.String("config", os.Getenv("CONFIG_FILE"), "path to a YAML config file")
+	addr := fs.String("addr", "", "address the HTTP server listens on")
+	connectTimeout := fs.Duration("connect-timeout", 0, "how long startup retries connecting to MongoDB and Redis")
+	shutdownTimeout := fs.Duration("shutdown-timeout", 0, "how long in-flight requests may drain on shutdown")
+	storageBackend := fs.String("storage-backend", "", "advertisement storage backend (mongo, memory)")
+	cacheBackend := fs.String("cache-backend", "", "cache backend (redis, memory)")
+	cacheTTL := fs.Duration("cache-ttl", 0, "how long ad lists are cached")
+	cacheStaleTTL := fs.Duration("cache-stale-ttl", 0, "how long stale ad lists are kept for when MongoDB is unavailable")
+	cacheRefreshTimeout := fs.Duration("cache-refresh-timeout", 0, "how long refreshing an ad list may query MongoDB before the stale list is served")
+	cacheLocalSize := fs.Int("cache-local-size", 0, "number of ad lists kept in process memory in front of Redis, 0 to turn off")
+	cacheLocalTTL := fs.Duration("cache-local-ttl", 0, "how long ad lists are kept in process memory")
+	dailyLimit := fs.Int("quota-daily-limit", 0, "number of ads the advertisers without an override can create per day, together")
+	activeLimit := fs.Int("quota-active-limit", 0, "number of active ads of the advertisers without an override, together")
+	logLevel := fs.String("log-level", "", "minimum log level (debug, info, warn, error)")
+	traceExporter := fs.String("trace-exporter", "", "trace exporter (otlp, stdout, none)")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if *configFile != "" {
+		if err := loadFile(cfg, *configFile); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := loadEnv(cfg); err != nil {
+		return nil, err
+	}
+
+	// Only flags given explicitly override the file and the environment
+	var flagErr error
+	fs.Visit(func(f *flag.Flag) {
+		switch f.Name {
+		case "addr":
+			cfg.Server.Addr = *addr
+		case "connect-timeout":
+			cfg.Server.ConnectTimeout = *connectTimeout
+		case "shutdown-timeout":
+			cfg.Server.ShutdownTimeout = *shutdownTimeout
+		case "storage-backend":
+			cfg.Storage.Backend = *storageBackend
+		case "cache-backend":
+			cfg.Cache.Backend = *cacheBackend
+		case "cache-ttl":
+			cfg.Cache.TTL = *cacheTTL
+		case "cache-stale-ttl":
+			cfg.Cache.StaleTTL = *cacheStaleTTL
+		case "cache-refresh-timeout":
+			cfg.Cache.RefreshTimeout = *cacheRefreshTimeout
+		case "cache-local-size":
+			cfg.Cache.Local.Size = *cacheLocalSize
+		case "cache-local-ttl":
+			cfg.Cache.Local.TTL = *cacheLocalTTL
+		case "quota-daily-limit":
+			cfg.Quota.DailyLimit = *dailyLimit
+		case "quota-active-limit":
+			cfg.Quota.ActiveLimit = *activeLimit
+		case "log-level":
+			flagErr = setLogLevel(&cfg.Log.Level, "-log-level", *logLevel)
+		case "trace-exporter":
+			cfg.Tracing.Exporter = *traceExporter
+		}
+	})
+	if flagErr != nil {
+		return nil, flagErr
+	}
+
+	// Spans are only written to stdout when asked for, since it holds the JSON log lines
+	if cfg.Tracing.Exporter == "" {
+		cfg.Tracing.Exporter = ExporterNone
+		if cfg.Tracing.Endpoint != "" {
+			cfg.Tracing.Exporter = ExporterOTLP
+		}
+	}
+
+	if err := cfg.Validate(); err != nil {
+		return nil, err
+	}
+
+	return cfg, nil
+}
+
+// loadFile overrides cfg with the values set in the YAML file.
+func loadFile(cfg *Config, path string) error {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config file %s: %w", path, err)
+	}
+	if err := yaml.Unmarshal(data, cfg); err != nil {
+		return fmt.Errorf("failed to parse config file %s: %w", path, err)
+	}
+	return nil
+}
+
+// loadEnv overrides cfg with the environment variables that are set.
+func loadEnv(cfg *Config) error {
+	var errs []error
+
+	setString := func(name string, dst *string) {
+		if v, ok := os.LookupEnv(name); ok {
+			*dst = v
+		}
+	}
+	setInt := func(name string, dst *int) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be an integer, got %q", name, v))
+				return
+			}
+			*dst = n
+		}
+	}
+	setFloat := func(name string, dst *float64) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			f, err := strconv.ParseFloat(v, 64)
+			if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+				errs = append(errs, fmt.Errorf("%s must be a number, got %q", name, v))
+				return
+			}
+			*dst = f
+		}
+	}
+	setDuration := func(name string, dst *time.Duration) {
+		if v, ok := os.LookupEnv(name); ok && v != "" {
+			d, err := time.ParseDuration(v)
+			if err != nil {
+				errs = append(errs, fmt.Errorf("%s must be a duration such as 1h, got %q", name, v))
+				return
+			}
+			*dst = d
+		}
+	}
+
+	setString("SERVER_ADDR", &cfg.Server.Addr)
+	setDuration("CONNECT_TIMEOUT", &cfg.Server.ConnectTimeout)
+	setDuration("SHUTDOWN_TIMEOUT", &cfg.Server.ShutdownTimeout)
+	setString("STORAGE_BACKEND", &cfg.Storage.Backend)
+	setString("MONGO_USERNAME", &cfg.Storage.Mongo.Username)
+	setString("MONGO_PASSWORD", &cfg.Storage.Mongo.Password)
+	setString("MONGO_HOST", &cfg.Storage.Mongo.Host)
+	setString("MONGO_DB", &cfg.Storage.Mongo.Database)
+	setString("MONGO_COLLECTION", &cfg.Storage.Mongo.Collection)
+	setString("CACHE_BACKEND", &cfg.Cache.Backend)
+	setString("REDIS_HOST", &cfg.Cache.Redis.Host)
+	setString("REDIS_PASSWORD", &cfg.Cache.Redis.Password)
+	setInt("REDIS_DB", &cfg.Cache.Redis.DB)
+	setDuration("CACHE_TTL", &cfg.Cache.TTL)
+	setDuration("CACHE_STALE_TTL", &cfg.Cache.StaleTTL)
+	setDuration("CACHE_REFRESH_TIMEOUT", &cfg.Cache.RefreshTimeout)
+	setInt("CACHE_LOCAL_SIZE", &cfg.Cache.Local.Size)
+	setDuration("CACHE_LOCAL_TTL", &cfg.Cache.Local.TTL)
+	setInt("QUOTA_DAILY_LIMIT", &cfg.Quota.DailyLimit)
+	setInt("QUOTA_ACTIVE_LIMIT", &cfg.Quota.ActiveLimit)
+	setFloat("RANKING_BID_WEIGHT", &cfg.Ranking.BidWeight)
+	setFloat("RANKING_BUDGET_WEIGHT", &cfg.Ranking.BudgetWeight)
+	setFloat("RANKING_RECENCY_WEIGHT", &cfg.Ranking.RecencyWeight)
+	setString("TRACE_EXPORTER", &cfg.Tracing.Exporter)
+	setString("OTEL_EXPORTER_OTLP_ENDPOINT", &cfg.Tracing.Endpoint)
+	if v, ok := os.LookupEnv("LOG_LEVEL"); ok && v != "" {
+		if err := setLogLevel(&cfg.Log.Level, "LOG_LEVEL", v); err != nil {
+			errs = append(errs, err)
+		}
+	}
+
+	if v, ok := os.LookupEnv("QUOTA_ADVERTISER_LIMITS"); ok && v != "" {
+		advertisers, err := parseAdvertiserLimits(v)
+		if err != nil {
+			errs = append(errs, err)
+		} else {
+			cfg.Quota.Advertisers = advertisers
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// setLogLevel parses a level name such as info into dst.
+func setLogLevel(dst *slog.Level, name, value string) error {
+	if err := dst.UnmarshalText([]byte(value)); err != nil {
+		return fmt.Errorf("%s must be debug, info, warn or error, got %q", name, value)
+	}
+	return nil
+}
+
+// parseAdvertiserLimits parses overrides such as "acme=100/50,beta=10/5" (advertiser=daily/active).
+func parseAdvertiserLimits(s string) (map[string]QuotaLimits, error) {
+	advertisers := make(map[string]QuotaLimits)
+	for _, entry := range strings.Split(s, ",") {
+		advertiserID, limits, ok := strings.Cut(strings.TrimSpace(entry), "=")
+		daily, active, ok2 := strings.Cut(limits, "/")
+		dailyLimit, err := strconv.Atoi(daily)
+		activeLimit, err2 := strconv.Atoi(active)
+		if !ok || !ok2 || advertiserID == "" || err != nil || err2 != nil {
+			return nil, fmt.Errorf("QUOTA_ADVERTISER_LIMITS entries must look like advertiser=daily/active, got %q", entry)
+		}
+		advertisers[advertiserID] = QuotaLimits{DailyLimit: dailyLimit, ActiveLimit: activeLimit}
+	}
+	return advertisers, nil
+}
+
+// Validate reports every missing or invalid value at once.
+func (c *Config) Validate() error {
+	var errs []error
+
+	if c.Server.Addr == "" {
+		errs = append(errs, errors.New("server address is required (SERVER_ADDR or -addr)"))
+	}
+	if c.Server.ConnectTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("connect timeout must be positive, got %s", c.Server.ConnectTimeout))
+	}
+	if c.Server.ShutdownTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("shutdown timeout must be positive, got %s", c.Server.ShutdownTimeout))
+	}
+
+	switch c.Storage.Backend {
+	case BackendMongo:
+		if c.Storage.Mongo.Host == "" {
+			errs = append(errs, errors.New("MONGO_HOST is required when the storage backend is mongo"))
+		}
+		if c.Storage.Mongo.Database == "" {
+			errs = append(errs, errors.New("MONGO_DB is required when the storage backend is mongo"))
+		}
+		if c.Storage.Mongo.Collection == "" {
+			errs = append(errs, errors.New("MONGO_COLLECTION is required when the storage backend is mongo"))
+		}
+	case BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("storage backend must be %s or %s, got %q", BackendMongo, BackendMemory, c.Storage.Backend))
+	}
+
+	switch c.Cache.Backend {
+	case BackendRedis:
+		if c.Cache.Redis.Host == "" {
+			errs = append(errs, errors.New("REDIS_HOST is required when the cache backend is redis"))
+		}
+		if c.Cache.Redis.DB < 0 {
+			errs = append(errs, fmt.Errorf("REDIS_DB must not be negative, got %d", c.Cache.Redis.DB))
+		}
+	case BackendMemory:
+	default:
+		errs = append(errs, fmt.Errorf("cache backend must be %s or %s, got %q", BackendRedis, BackendMemory, c.Cache.Backend))
+	}
+
+	if c.Cache.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("cache TTL must be positive, got %s", c.Cache.TTL))
+	}
+	if c.Cache.StaleTTL < 0 {
+		errs = append(errs, fmt.Errorf("cache stale TTL must not be negative, got %s", c.Cache.StaleTTL))
+	}
+	if c.Cache.RefreshTimeout <= 0 {
+		errs = append(errs, fmt.Errorf("cache refresh timeout must be positive, got %s", c.Cache.RefreshTimeout))
+	}
+	if c.Cache.Local.Size < 0 {
+		errs = append(errs, fmt.Errorf("local cache size must not be negative, got %d", c.Cache.Local.Size))
+	}
+	if c.Cache.Local.Size > 0 && c.Cache.Local.TTL <= 0 {
+		errs = append(errs, fmt.Errorf("local cache TTL must be positive, got %s", c.Cache.Local.TTL))
+	}
+
+	if c.Quota.DailyLimit < 0 {
+		errs = append(errs, fmt.Errorf("daily quota limit must not be negative, got %d", c.Quota.DailyLimit))
+	}
+	if c.Quota.ActiveLimit < 0 {
+		errs = append(errs, fmt.Errorf("active quota limit must not be negative, got %d", c.Quota.ActiveLimit))
+	}
+	for advertiserID, limits := range c.Quota.Advertisers {
+		if limits.DailyLimit < 0 || limits.ActiveLimit < 0 {
+			errs = append(errs, fmt.Errorf("quota limits of advertiser %s must not be negative", advertiserID))
+		}
+	}
+
+	switch c.Tracing.Exporter {
+	case ExporterOTLP:
+		if c.Tracing.Endpoint == "" {
+			errs = append(errs, errors.New("OTEL_EXPORTER_OTLP_ENDPOINT is required when the trace exporter is otlp"))
+		}
+	case ExporterStdout, ExporterNone:
+	default:
+		errs = append(errs, fmt.Errorf("trace exporter must be %s, %s or %s, got %q", ExporterOTLP, ExporterStdout, ExporterNone, c.Tracing.Exporter))
+	}
+
+	if len(errs) > 0 {
+		return fmt.Errorf("invalid configuration: %w", errors.Join(errs...))
+	}
+	return nil
+}
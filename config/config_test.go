@@ -0,0 +1,182 @@
+package config_test
+
+import (
+	"log/slog"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"ad-service-api/config"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func setMongoAndRedisEnv(t *testing.T) {
+	t.Setenv("MONGO_HOST", "db:27017")
+	t.Setenv("MONGO_DB", "ads")
+	t.Setenv("MONGO_COLLECTION", "ads")
+	t.Setenv("REDIS_HOST", "redis:6379")
+}
+
+func TestLoad_Env(t *testing.T) {
+	setMongoAndRedisEnv(t)
+	t.Setenv("REDIS_DB", "2")
+	t.Setenv("CACHE_TTL", "30m")
+	t.Setenv("CACHE_STALE_TTL", "5m")
+	t.Setenv("CACHE_REFRESH_TIMEOUT", "2s")
+	t.Setenv("CACHE_LOCAL_SIZE", "100")
+	t.Setenv("SHUTDOWN_TIMEOUT", "20s")
+	t.Setenv("QUOTA_DAILY_LIMIT", "100")
+	t.Setenv("QUOTA_ADVERTISER_LIMITS", "acme=10/5, beta=20/8")
+	t.Setenv("LOG_LEVEL", "debug")
+	t.Setenv("RANKING_BUDGET_WEIGHT", "0.01")
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, ":8080", cfg.Server.Addr)
+	assert.Equal(t, "db:27017", cfg.Storage.Mongo.Host)
+	assert.Equal(t, 2, cfg.Cache.Redis.DB)
+	assert.Equal(t, 30*time.Minute, cfg.Cache.TTL)
+	assert.Equal(t, 5*time.Minute, cfg.Cache.StaleTTL)
+	assert.Equal(t, 2*time.Second, cfg.Cache.RefreshTimeout)
+	assert.Equal(t, config.LocalCacheConfig{Size: 100, TTL: time.Second}, cfg.Cache.Local)
+	assert.Equal(t, 20*time.Second, cfg.Server.ShutdownTimeout)
+	assert.Equal(t, 100, cfg.Quota.DailyLimit)
+	assert.Equal(t, 1000, cfg.Quota.ActiveLimit)
+	assert.Equal(t, map[string]config.QuotaLimits{
+		"acme": {DailyLimit: 10, ActiveLimit: 5},
+		"beta": {DailyLimit: 20, ActiveLimit: 8},
+	}, cfg.Quota.Advertisers)
+	assert.Equal(t, slog.LevelDebug, cfg.Log.Level)
+	assert.Equal(t, config.RankingConfig{BidWeight: 1, BudgetWeight: 0.01}, cfg.Ranking)
+	assert.Equal(t, config.ExporterNone, cfg.Tracing.Exporter, "expected no spans on stdout without a collector")
+}
+
+func TestLoad_TracingCollector(t *testing.T) {
+	setMongoAndRedisEnv(t)
+	t.Setenv("OTEL_EXPORTER_OTLP_ENDPOINT", "http://otel-collector:4318")
+
+	cfg, err := config.Load(nil)
+	require.NoError(t, err)
+
+	assert.Equal(t, config.TracingConfig{Exporter: config.ExporterOTLP, Endpoint: "http://otel-collector:4318"}, cfg.Tracing)
+}
+
+func TestLoad_Precedence(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	err := os.WriteFile(path, []byte(`
+server:
+  addr: ":9000"
+storage:
+  backend: memory
+cache:
+  backend: memory
+  ttl: 10m
+quota:
+  dailyLimit: 50
+  activeLimit: 20
+  advertisers:
+    acme:
+      dailyLimit: 5
+      activeLimit: 2
+log:
+  level: warn
+`), 0o600)
+	require.NoError(t, err)
+
+	// The environment overrides the file, and flags override both
+	t.Setenv("QUOTA_DAILY_LIMIT", "60")
+	t.Setenv("SERVER_ADDR", ":9100")
+
+	cfg, err := config.Load([]string{"-config", path, "-addr", ":9200"})
+	require.NoError(t, err)
+
+	assert.Equal(t, ":9200", cfg.Server.Addr)
+	assert.Equal(t, config.BackendMemory, cfg.Storage.Backend)
+	assert.Equal(t, config.BackendMemory, cfg.Cache.Backend)
+	assert.Equal(t, 10*time.Minute, cfg.Cache.TTL)
+	assert.Equal(t, 60, cfg.Quota.DailyLimit)
+	assert.Equal(t, 20, cfg.Quota.ActiveLimit)
+	assert.Equal(t, config.QuotaLimits{DailyLimit: 5, ActiveLimit: 2}, cfg.Quota.Advertisers["acme"])
+	assert.Equal(t, slog.LevelWarn, cfg.Log.Level)
+}
+
+func TestLoad_Validation(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		args    []string
+		wantErr []string
+	}{
+		{
+			name:    "missing mongo and redis settings",
+			wantErr: []string{"MONGO_HOST is required", "MONGO_DB is required", "MONGO_COLLECTION is required", "REDIS_HOST is required"},
+		},
+		{
+			name:    "unknown backends",
+			args:    []string{"-storage-backend", "postgres", "-cache-backend", "memcached"},
+			wantErr: []string{`storage backend must be mongo or memory, got "postgres"`, `cache backend must be redis or memory, got "memcached"`},
+		},
+		{
+			name:    "malformed values",
+			env:     map[string]string{"REDIS_DB": "zero", "QUOTA_ADVERTISER_LIMITS": "acme=10", "LOG_LEVEL": "verbose"},
+			wantErr: []string{`REDIS_DB must be an integer, got "zero"`, "QUOTA_ADVERTISER_LIMITS entries must look like advertiser=daily/active", `LOG_LEVEL must be debug, info, warn or error, got "verbose"`},
+		},
+		{
+			name:    "tracing",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-trace-exporter", "otlp"},
+			wantErr: []string{"OTEL_EXPORTER_OTLP_ENDPOINT is required when the trace exporter is otlp"},
+		},
+		{
+			name:    "unknown trace exporter",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-trace-exporter", "jaeger"},
+			wantErr: []string{`trace exporter must be otlp, stdout or none, got "jaeger"`},
+		},
+		{
+			name:    "non-positive cache ttl",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-cache-ttl", "0s"},
+			wantErr: []string{"cache TTL must be positive"},
+		},
+		{
+			name:    "negative cache stale ttl",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-cache-stale-ttl", "-1m"},
+			wantErr: []string{"cache stale TTL must not be negative"},
+		},
+		{
+			name:    "non-positive cache refresh timeout",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-cache-refresh-timeout", "0s"},
+			wantErr: []string{"cache refresh timeout must be positive"},
+		},
+		{
+			name:    "local cache without ttl",
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory", "-cache-local-size", "10", "-cache-local-ttl", "0s"},
+			wantErr: []string{"local cache TTL must be positive"},
+		},
+		{
+			name:    "malformed ranking weight",
+			env:     map[string]string{"RANKING_BID_WEIGHT": "NaN"},
+			args:    []string{"-storage-backend", "memory", "-cache-backend", "memory"},
+			wantErr: []string{`RANKING_BID_WEIGHT must be a number, got "NaN"`},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			for _, name := range []string{"MONGO_HOST", "MONGO_DB", "MONGO_COLLECTION", "REDIS_HOST"} {
+				t.Setenv(name, "")
+			}
+			for name, value := range tt.env {
+				t.Setenv(name, value)
+			}
+
+			_, err := config.Load(tt.args)
+			require.Error(t, err)
+			for _, want := range tt.wantErr {
+				assert.Contains(t, err.Error(), want)
+			}
+		})
+	}
+}
@@ -1,37 +1,182 @@
 package database
 
 import (
-	"strconv"
 	"time"
 
+	"ad-service-api/internal/models"
+
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-func CreateFilter(validQueryParams map[string]string) bson.M {
-	now := time.Now()
+// CreateFilter translates an AdQuery into a MongoDB filter.
+// An ad without a value for a condition targets everyone on that dimension,
+// so every condition also matches documents where the field is unset or empty.
+// A scheduled ad only matches while one of its windows is open.
+func CreateFilter(query models.AdQuery) bson.M {
+	filter := conditionsFilter(query)
+	addAfter(filter, query.After)
+	filter["startAt"] = bson.M{"$lte": query.Now}
+	filter["endAt"] = bson.M{"$gte": query.Now}
+	conditions, _ := filter["$and"].(bson.A)
+	filter["$and"] = append(conditions, onSchedule(query.Now))
+	return filter
+}
+
+// CreateUpcomingFilter translates an AdQuery into a MongoDB filter matching the ads that CreateFilter
+// matches now or will match later, that is those that have not ended yet, including the ones that
+// have not started or are outside their schedule's windows.
+func CreateUpcomingFilter(query models.AdQuery) bson.M {
+	filter := conditionsFilter(query)
+	addAfter(filter, query.After)
+	filter["endAt"] = bson.M{"$gte": query.Now}
+	return filter
+}
+
+// conditionsFilter matches the targeting conditions of query, regardless of the schedule.
+func conditionsFilter(query models.AdQuery) bson.M {
+	filter := bson.M{}
+	conditions := bson.A{}
 
-	filter := bson.M{
-		"startAt": bson.M{"$lte": now},
-		"endAt":   bson.M{"$gte": now},
+	if query.Age != 0 {
+		conditions = append(conditions,
+			bson.M{"$or": bson.A{
+				bson.M{"conditions.ageStart": bson.M{"$lte": query.Age}},
+				bson.M{"conditions.ageStart": nil},
+			}},
+			bson.M{"$or": bson.A{
+				bson.M{"conditions.ageEnd": bson.M{"$gte": query.Age}},
+				bson.M{"conditions.ageEnd": bson.M{"$in": bson.A{nil, 0}}},
+			}},
+		)
 	}
 
-	if age, ok := validQueryParams["age"]; ok {
-		age, _ := strconv.Atoi(age)
-		filter["conditions.ageStart"] = bson.M{"$lte": age}
-		filter["conditions.ageEnd"] = bson.M{"$gte": age}
+	if query.OSVersion != nil {
+		conditions = append(conditions, versionInRange("conditions.osVersion", *query.OSVersion)...)
+	}
+	if query.AppVersion != nil {
+		conditions = append(conditions, versionInRange("conditions.appVersion", *query.AppVersion)...)
 	}
 
-	if gender, ok := validQueryParams["gender"]; ok {
-		filter["conditions.gender"] = bson.M{"$in": []string{gender}}
+	for _, dimension := range []struct {
+		field, excludeField string
+		values, excluded    []string
+	}{
+		{"conditions.gender", "", query.Gender, query.NotGender},
+		{"conditions.country", "conditions.excludeCountry", query.Country, query.NotCountry},
+		{"conditions.platform", "conditions.excludePlatform", query.Platform, query.NotPlatform},
+		{"conditions.language", "", models.LanguageMatches(query.Language), nil},
+		{"conditions.region", "", query.Region, query.NotRegion},
+	} {
+		if len(dimension.values) > 0 {
+			conditions = append(conditions, matchOrUnset(dimension.field, dimension.excludeField, dimension.values))
+		}
+		if len(dimension.excluded) > 0 {
+			conditions = append(conditions, matchOtherOrUnset(dimension.field, dimension.excluded))
+		}
 	}
 
-	if country, ok := validQueryParams["country"]; ok {
-		filter["conditions.country"] = bson.M{"$in": []string{country}}
+	if len(conditions) > 0 {
+		filter["$and"] = conditions
 	}
 
-	if platform, ok := validQueryParams["platform"]; ok {
-		filter["conditions.platform"] = bson.M{"$in": []string{platform}}
+	return filter
+}
+
+// onSchedule matches the ads without a schedule, or whose schedule has a window open at now. The
+// minute of the week of now in the schedule's timezone is compared with the ranges of minutes
+// stored with the windows by models.Schedule.MarshalBSON.
+func onSchedule(now time.Time) bson.M {
+	timezone := bson.M{"$ifNull": bson.A{"$schedule.timezone", "UTC"}}
+	local := func(part string) bson.M {
+		return bson.M{part: bson.M{"date": now, "timezone": timezone}}
 	}
+	minute := bson.M{"$add": bson.A{
+		bson.M{"$multiply": bson.A{bson.M{"$subtract": bson.A{local("$isoDayOfWeek"), 1}}, 24 * 60}},
+		bson.M{"$multiply": bson.A{local("$hour"), 60}},
+		local("$minute"),
+	}}
+
+	return bson.M{"$or": bson.A{
+		bson.M{"schedule": nil},
+		bson.M{"$expr": bson.M{"$let": bson.M{
+			"vars": bson.M{"minute": minute},
+			"in": bson.M{"$anyElementTrue": bson.A{bson.M{"$map": bson.M{
+				"input": bson.M{"$ifNull": bson.A{"$schedule.ranges", bson.A{}}},
+				"as":    "range",
+				"in": bson.M{"$and": bson.A{
+					bson.M{"$lte": bson.A{"$$range.start", "$$minute"}},
+					bson.M{"$gt": bson.A{"$$range.end", "$$minute"}},
+				}},
+			}}}},
+		}}},
+	}}
+}
 
+// addAfter restricts filter to the ads sorted after cursor in ListingSort, if it is set. The bound
+// on priority alone lets the index scan start at the cursor; the $or then skips the ads ranked
+// level with it on each field up to the cursor's id.
+func addAfter(filter bson.M, cursor *models.Cursor) {
+	if cursor == nil {
+		return
+	}
+	conditions, _ := filter["$and"].(bson.A)
+	filter["$and"] = append(conditions,
+		bson.M{"priority": bson.M{"$lte": cursor.Priority}},
+		bson.M{"$or": bson.A{
+			bson.M{"priority": bson.M{"$lt": cursor.Priority}},
+			bson.M{"priority": cursor.Priority, "score": bson.M{"$lt": cursor.Score}},
+			bson.M{"priority": cursor.Priority, "score": cursor.Score, "endAt": bson.M{"$gt": cursor.EndAt}},
+			bson.M{"priority": cursor.Priority, "score": cursor.Score, "endAt": cursor.EndAt, "_id": bson.M{"$gt": cursor.ID}},
+		}},
+	)
+}
+
+// versionInRange matches documents whose models.VersionRange at field contains version, comparing
+// the keys stored along with its bounds. A missing range or bound does not restrict the version.
+func versionInRange(field string, version models.Version) bson.A {
+	return bson.A{
+		bson.M{"$or": bson.A{
+			bson.M{field + ".minKey": bson.M{"$lte": version.Key()}},
+			bson.M{field + ".minKey": nil},
+		}},
+		bson.M{"$or": bson.A{
+			bson.M{field + ".maxKey": bson.M{"$gte": version.Key()}},
+			bson.M{field + ".maxKey": nil},
+		}},
+	}
+}
+
+// matchOrUnset matches documents whose list field contains any of values, or whose
+// list field is missing, null or empty, and whose excludeField, if any, does not hold
+// that value. A single $in, unlike an $or with $size, is answered from the field's
+// multikey index.
+func matchOrUnset(field, excludeField string, values []string) bson.M {
+	if excludeField != "" && len(values) > 1 {
+		// Some value must be both targeted and not excluded
+		anyOf := bson.A{}
+		for _, value := range values {
+			anyOf = append(anyOf, matchOrUnset(field, excludeField, []string{value}))
+		}
+		return bson.M{"$or": anyOf}
+	}
+
+	in := bson.A{nil, bson.A{}}
+	for _, value := range values {
+		in = append(in, value)
+	}
+	filter := bson.M{field: bson.M{"$in": in}}
+	if excludeField != "" {
+		filter[excludeField] = bson.M{"$ne": values[0]}
+	}
 	return filter
 }
+
+// matchOtherOrUnset matches documents whose list field contains a value other than the
+// excluded ones, or whose list field is missing, null or empty. Like
+// models.AdQuery.MatchesConditions, it does not check the exclusions of the ad.
+func matchOtherOrUnset(field string, excluded []string) bson.M {
+	return bson.M{"$or": bson.A{
+		bson.M{field: bson.M{"$in": bson.A{nil, bson.A{}}}},
+		bson.M{field: bson.M{"$elemMatch": bson.M{"$nin": excluded}}},
+	}}
+}
@@ -0,0 +1,338 @@
+package database_test
+
+import (
+	"context"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"ad-service-api/database"
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// connectTestCollection connects to the Mongo instance at MONGO_TEST_URI
+// (e.g. the docker-compose db service) and returns an empty collection.
+func connectTestCollection(t testing.TB) *mongo.Collection {
+	uri := os.Getenv("MONGO_TEST_URI")
+	if uri == "" {
+		t.Skip("MONGO_TEST_URI is not set, skipping Mongo integration test")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
+	require.NoError(t, err)
+	require.NoError(t, client.Ping(ctx, nil))
+
+	col := client.Database("ad_service_test").Collection(t.Name())
+	require.NoError(t, col.Drop(ctx))
+
+	t.Cleanup(func() {
+		col.Drop(context.Background())
+		client.Disconnect(context.Background())
+	})
+
+	return col
+}
+
+func TestCreateFilter(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	docs := []interface{}{
+		bson.M{"title": "untargeted", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour)},
+		bson.M{"title": "empty lists", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "conditions": bson.M{
+			"gender": bson.A{}, "country": bson.A{}, "platform": bson.A{},
+		}},
+		bson.M{"title": "targeted", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "conditions": bson.M{
+			"ageStart": 20, "ageEnd": 30, "gender": bson.A{"F"}, "country": bson.A{"TW", "JP"}, "platform": bson.A{"ios"},
+		}},
+		bson.M{"title": "age only", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "conditions": bson.M{
+			"ageStart": 40,
+		}},
+		bson.M{"title": "expired", "startAt": now.Add(-2 * time.Hour), "endAt": now.Add(-time.Hour)},
+	}
+	_, err := col.InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		query models.AdQuery
+		want  []string
+	}{
+		{"no params", models.AdQuery{}, []string{"age only", "empty lists", "targeted", "untargeted"}},
+		{"gender match", models.AdQuery{Gender: []string{"F"}}, []string{"age only", "empty lists", "targeted", "untargeted"}},
+		{"gender mismatch", models.AdQuery{Gender: []string{"M"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"country match", models.AdQuery{Country: []string{"JP"}}, []string{"age only", "empty lists", "targeted", "untargeted"}},
+		{"country mismatch", models.AdQuery{Country: []string{"US"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"platform mismatch", models.AdQuery{Platform: []string{"web"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"any of several countries", models.AdQuery{Country: []string{"US", "JP"}}, []string{"age only", "empty lists", "targeted", "untargeted"}},
+		{"any of several mismatching countries", models.AdQuery{Country: []string{"US", "KR"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"excluded platform", models.AdQuery{NotPlatform: []string{"ios"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"excluded country, other targeted", models.AdQuery{NotCountry: []string{"TW"}}, []string{"age only", "empty lists", "targeted", "untargeted"}},
+		{"all countries excluded", models.AdQuery{NotCountry: []string{"TW", "JP"}}, []string{"age only", "empty lists", "untargeted"}},
+		{"age inside range", models.AdQuery{Age: 25}, []string{"empty lists", "targeted", "untargeted"}},
+		{"age above open range", models.AdQuery{Age: 50}, []string{"age only", "empty lists", "untargeted"}},
+		{"all dimensions", models.AdQuery{Age: 25, Gender: []string{"F"}, Country: []string{"TW"}, Platform: []string{"ios"}}, []string{"empty lists", "targeted", "untargeted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			cursor, err := col.Find(ctx, database.CreateFilter(tt.query))
+			require.NoError(t, err)
+
+			var results []bson.M
+			require.NoError(t, cursor.All(ctx, &results))
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result["title"].(string))
+			}
+			sort.Strings(titles)
+
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestCreateFilter_Exclusions(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	docs := []interface{}{
+		bson.M{"title": "untargeted", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour)},
+		bson.M{"title": "worldwide but CN", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "conditions": bson.M{
+			"excludeCountry": bson.A{"CN", "RU"}, "excludePlatform": bson.A{"web"},
+		}},
+		bson.M{"title": "asia but CN", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "conditions": bson.M{
+			"country": bson.A{"TW", "JP"}, "excludeCountry": bson.A{"CN"},
+		}},
+	}
+	_, err := col.InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name  string
+		query models.AdQuery
+		want  []string
+	}{
+		{"no params", models.AdQuery{}, []string{"asia but CN", "untargeted", "worldwide but CN"}},
+		{"excluded country", models.AdQuery{Country: []string{"CN"}}, []string{"untargeted"}},
+		{"excluded platform", models.AdQuery{Platform: []string{"web"}}, []string{"asia but CN", "untargeted"}},
+		{"other country", models.AdQuery{Country: []string{"TW"}}, []string{"asia but CN", "untargeted", "worldwide but CN"}},
+		{"any country not excluded", models.AdQuery{Country: []string{"CN", "US"}}, []string{"untargeted", "worldwide but CN"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			cursor, err := col.Find(ctx, database.CreateFilter(tt.query))
+			require.NoError(t, err)
+
+			var results []bson.M
+			require.NoError(t, cursor.All(ctx, &results))
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result["title"].(string))
+			}
+			sort.Strings(titles)
+
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestCreateFilter_ExtendedTargeting(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Inserted as models, so that the version ranges are stored with their keys
+	ads := []interface{}{
+		models.Advertisement{Title: "untargeted", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)},
+		models.Advertisement{Title: "chinese", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			Language: []string{"zh"},
+		}},
+		models.Advertisement{Title: "taipei", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			Country: []string{"TW"}, Region: []string{"TW-TPE"},
+		}},
+		models.Advertisement{Title: "os 9.1 to 10", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			OSVersion: &models.VersionRange{Min: "9.1", Max: "10"},
+		}},
+	}
+	_, err := col.InsertMany(ctx, ads)
+	require.NoError(t, err)
+
+	v := func(s string) *models.Version {
+		version, err := models.ParseVersion(s)
+		require.NoError(t, err)
+		return &version
+	}
+
+	tests := []struct {
+		name  string
+		query models.AdQuery
+		want  []string
+	}{
+		{"more specific language", models.AdQuery{Language: []string{"zh-Hant-TW"}}, []string{"chinese", "os 9.1 to 10", "taipei", "untargeted"}},
+		{"other language", models.AdQuery{Language: []string{"en"}}, []string{"os 9.1 to 10", "taipei", "untargeted"}},
+		{"region mismatch", models.AdQuery{Region: []string{"TW-KHH"}}, []string{"chinese", "os 9.1 to 10", "untargeted"}},
+		{"region excluded", models.AdQuery{NotRegion: []string{"TW-TPE"}}, []string{"chinese", "os 9.1 to 10", "untargeted"}},
+		// 10.0 sorts before 9.0 as a string
+		{"os version in range", models.AdQuery{OSVersion: v("9.10")}, []string{"chinese", "os 9.1 to 10", "taipei", "untargeted"}},
+		{"os version above range", models.AdQuery{OSVersion: v("10.0.1")}, []string{"chinese", "taipei", "untargeted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			cursor, err := col.Find(ctx, database.CreateFilter(tt.query))
+			require.NoError(t, err)
+
+			var results []bson.M
+			require.NoError(t, cursor.All(ctx, &results))
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result["title"].(string))
+			}
+			sort.Strings(titles)
+
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestCreateFilter_Schedule(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	// Friday 10:00 in Taipei
+	now := time.Date(2026, time.October, 16, 2, 0, 0, 0, time.UTC)
+
+	// Inserted as models, so that the schedules are stored with their ranges
+	ads := []interface{}{
+		models.Advertisement{Title: "always", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour)},
+		models.Advertisement{Title: "office hours", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour), Schedule: &models.Schedule{
+			Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+		}},
+		models.Advertisement{Title: "sunday nights", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour), Schedule: &models.Schedule{
+			Timezone: "America/New_York", Windows: []models.Window{{Days: []string{"sun"}, Start: "22:00", End: "02:00"}},
+		}},
+	}
+	_, err := col.InsertMany(ctx, ads)
+	require.NoError(t, err)
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{"office hours", now, []string{"always", "office hours"}},
+		{"friday evening", now.Add(8 * time.Hour), []string{"always"}},
+		// Monday 01:00 in New York, past the end of the week in UTC
+		{"wraps past midnight", time.Date(2026, time.October, 19, 5, 0, 0, 0, time.UTC), []string{"always", "sunday nights"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cursor, err := col.Find(ctx, database.CreateFilter(models.AdQuery{Now: tt.now}))
+			require.NoError(t, err)
+
+			var results []bson.M
+			require.NoError(t, cursor.All(ctx, &results))
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result["title"].(string))
+			}
+			sort.Strings(titles)
+
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestCreateUpcomingFilter(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	docs := []interface{}{
+		bson.M{"title": "running", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour)},
+		bson.M{"title": "scheduled", "startAt": now.Add(time.Hour), "endAt": now.Add(2 * time.Hour)},
+		bson.M{"title": "scheduled elsewhere", "startAt": now.Add(time.Hour), "endAt": now.Add(2 * time.Hour), "conditions": bson.M{
+			"country": bson.A{"JP"},
+		}},
+		bson.M{"title": "expired", "startAt": now.Add(-2 * time.Hour), "endAt": now.Add(-time.Hour)},
+	}
+	_, err := col.InsertMany(ctx, docs)
+	require.NoError(t, err)
+
+	cursor, err := col.Find(ctx, database.CreateUpcomingFilter(models.AdQuery{Country: []string{"TW"}, Now: now}))
+	require.NoError(t, err)
+
+	var results []bson.M
+	require.NoError(t, cursor.All(ctx, &results))
+
+	titles := make([]string, 0, len(results))
+	for _, result := range results {
+		titles = append(titles, result["title"].(string))
+	}
+	sort.Strings(titles)
+
+	assert.Equal(t, []string{"running", "scheduled"}, titles)
+}
+
+func TestCreateFilter_Cursor(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now().Truncate(time.Millisecond)
+
+	// In the listing order; two ads tie on priority, score and end, so the id decides their order
+	ads := []*models.Advertisement{
+		{ID: primitive.NewObjectID(), Title: "house", Priority: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour)},
+		{ID: primitive.NewObjectID(), Title: "high score", Score: 5, StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour)},
+		{ID: primitive.NewObjectID(), Title: "first", Score: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)},
+		{ID: primitive.NewObjectID(), Title: "second", Score: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+		{ID: primitive.NewObjectID(), Title: "third", Score: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+		{ID: primitive.NewObjectID(), Title: "fourth", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)},
+	}
+	for i := len(ads) - 1; i >= 0; i-- {
+		_, err := col.InsertOne(ctx, ads[i])
+		require.NoError(t, err)
+	}
+
+	for i, ad := range ads {
+		t.Run("after "+ad.Title, func(t *testing.T) {
+			cursor := models.CursorAfter(ad)
+			found, err := col.Find(ctx, database.CreateFilter(models.AdQuery{Now: now, After: &cursor}), options.Find().SetSort(database.ListingSort))
+			require.NoError(t, err)
+
+			var results []models.Advertisement
+			require.NoError(t, found.All(ctx, &results))
+
+			titles := make([]string, 0, len(results))
+			for _, result := range results {
+				titles = append(titles, result.Title)
+			}
+			want := []string{}
+			for _, next := range ads[i+1:] {
+				want = append(want, next.Title)
+			}
+			assert.Equal(t, want, titles)
+		})
+	}
+}
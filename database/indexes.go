@@ -0,0 +1,87 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ListingSort is the order in which the listing returns ads, see models.CompareRank: by priority and
+// then stored score, highest first, then soonest ending first. The id breaks ties, so that every ad
+// has a unique position for a models.Cursor to point at.
+var ListingSort = bson.D{
+	{Key: "priority", Value: -1},
+	{Key: "score", Value: -1},
+	{Key: "endAt", Value: 1},
+	{Key: "_id", Value: 1},
+}
+
+// AdIndexes returns the indexes backing the queries on the ads collection.
+//
+// Every listing filter sorts on ListingSort, so its fields follow the equality fields and startAt
+// comes last, where it is checked on the index keys instead of the documents, like the bound on
+// endAt. The targeting lists are arrays, and a compound index may only hold one of them, so each
+// gets its own multikey index for the planner to pick the most selective from. The age bounds are
+// ranges that also match unset values, which would not narrow an index scan, so they have none.
+// The counts and the upcoming changes bound endAt without sorting, so they keep indexes led by it.
+func AdIndexes() []mongo.IndexModel {
+	ranked := func(keys ...string) bson.D {
+		d := bson.D{}
+		for _, key := range keys {
+			d = append(d, bson.E{Key: key, Value: 1})
+		}
+		return append(append(d, ListingSort...), bson.E{Key: "startAt", Value: 1})
+	}
+	schedule := func(keys ...string) bson.D {
+		d := bson.D{}
+		for _, key := range keys {
+			d = append(d, bson.E{Key: key, Value: 1})
+		}
+		return append(d, bson.E{Key: "endAt", Value: 1}, bson.E{Key: "_id", Value: 1}, bson.E{Key: "startAt", Value: 1})
+	}
+
+	return []mongo.IndexModel{
+		{Keys: schedule(), Options: options.Index().SetName("endAt_1__id_1_startAt_1")},
+		{Keys: ranked(), Options: options.Index().SetName("priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		{Keys: ranked("conditions.country"), Options: options.Index().SetName("conditions.country_1_priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		{Keys: ranked("conditions.platform"), Options: options.Index().SetName("conditions.platform_1_priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		{Keys: ranked("conditions.gender"), Options: options.Index().SetName("conditions.gender_1_priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		{Keys: ranked("conditions.language"), Options: options.Index().SetName("conditions.language_1_priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		{Keys: ranked("conditions.region"), Options: options.Index().SetName("conditions.region_1_priority_-1_score_-1_endAt_1__id_1_startAt_1")},
+		// Quota checks count an advertiser's active ads
+		{Keys: schedule("advertiserId"), Options: options.Index().SetName("advertiserId_1_endAt_1__id_1_startAt_1")},
+	}
+}
+
+// EnsureIndexes creates the AdIndexes on col. Indexes that already exist are left untouched, so
+// it is safe to call on every start; on a populated collection it blocks until the new indexes
+// are built.
+func EnsureIndexes(ctx context.Context, col *mongo.Collection) error {
+	if _, err := col.Indexes().CreateMany(ctx, AdIndexes()); err != nil {
+		return fmt.Errorf("failed to create indexes on %s: %w", col.Name(), err)
+	}
+	return nil
+}
+
+// ExplainListing returns the explain output, with execution statistics, of the find that lists the
+// ads matching filter, limit at a time.
+func ExplainListing(ctx context.Context, col *mongo.Collection, filter bson.M, limit int) (bson.M, error) {
+	cmd := bson.D{
+		{Key: "explain", Value: bson.D{
+			{Key: "find", Value: col.Name()},
+			{Key: "filter", Value: filter},
+			{Key: "sort", Value: ListingSort},
+			{Key: "limit", Value: limit},
+		}},
+		{Key: "verbosity", Value: "executionStats"},
+	}
+
+	var result bson.M
+	if err := col.Database().RunCommand(ctx, cmd).Decode(&result); err != nil {
+		return nil, fmt.Errorf("failed to explain listing on %s: %w", col.Name(), err)
+	}
+	return result, nil
+}
@@ -3,24 +3,34 @@ package database
 import (
 	"context"
 	"fmt"
-	"log"
+	"net/url"
 	"time"
 
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
 )
 
-func ConnectMongoDB(username string, password string, host string, dbname string, collectionName string) (*mongo.Collection, error) {
+// ConnectMongoDB connects to MongoDB and pings it, returning the requested collection.
+func ConnectMongoDB(ctx context.Context, username string, password string, host string, dbname string, collectionName string) (*mongo.Collection, error) {
 	var col *mongo.Collection
 
-	uri := fmt.Sprintf("mongodb://%s:%s@%s/%s", username, password, host, dbname)
-	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	uri := fmt.Sprintf("mongodb://%s/%s", host, dbname)
+	if username != "" {
+		uri = fmt.Sprintf("mongodb://%s@%s/%s", url.UserPassword(username, password).String(), host, dbname)
+	}
+	ctx, cancel := context.WithTimeout(ctx, 10*time.Second)
 	defer cancel()
 
 	// Create a connection string with the username and password
 	client, err := mongo.Connect(ctx, options.Client().ApplyURI(uri))
 	if err != nil {
-		log.Fatal(err)
+		return nil, fmt.Errorf("failed to connect to mongodb at %s: %w", host, err)
+	}
+
+	// Connect is lazy, so ping to make sure the server is reachable
+	if err := client.Ping(ctx, nil); err != nil {
+		client.Disconnect(context.Background())
+		return nil, fmt.Errorf("failed to ping mongodb at %s: %w", host, err)
 	}
 
 	db := client.Database(dbname)
@@ -0,0 +1,51 @@
+package database
+
+import (
+	"context"
+	"fmt"
+
+	"ad-service-api/internal/models"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// ScoreExpression returns the aggregation expression computing models.Ranking.Score from the fields
+// of an ad document, adding up the same terms in the same order so that both yield the same float.
+// A missing bid or budget counts as 0.
+func ScoreExpression(ranking models.Ranking) bson.M {
+	term := func(weight float64, value interface{}) bson.M {
+		return bson.M{"$multiply": bson.A{weight, value}}
+	}
+	recency := bson.M{"$divide": bson.A{bson.M{"$toLong": "$startAt"}, models.MillisPerDay}}
+	return bson.M{"$add": bson.A{
+		bson.M{"$add": bson.A{
+			term(ranking.BidWeight, bson.M{"$ifNull": bson.A{"$bid", 0}}),
+			term(ranking.BudgetWeight, bson.M{"$ifNull": bson.A{"$budget", 0}}),
+		}},
+		term(ranking.RecencyWeight, recency),
+	}}
+}
+
+// Rescore stores the score of every ad of col under ranking, and a priority of 0 on the ads stored
+// without one, so that all of them sort and page in ListingSort. Ads whose score is current are left
+// untouched, so only the ads scored under other weights are rewritten. It returns the number of ads
+// updated. It is run by cmd/rescore rather than on start, since a replica still running the old
+// weights would store old scores again.
+func Rescore(ctx context.Context, col *mongo.Collection, ranking models.Ranking) (int64, error) {
+	score := ScoreExpression(ranking)
+	filter := bson.M{"$or": bson.A{
+		bson.M{"priority": nil},
+		bson.M{"$expr": bson.M{"$ne": bson.A{"$score", score}}},
+	}}
+	update := mongo.Pipeline{{{Key: "$set", Value: bson.M{
+		"priority": bson.M{"$ifNull": bson.A{"$priority", 0}},
+		"score":    score,
+	}}}}
+
+	result, err := col.UpdateMany(ctx, filter, update)
+	if err != nil {
+		return 0, fmt.Errorf("failed to rescore ads in %s: %w", col.Name(), err)
+	}
+	return result.ModifiedCount, nil
+}
@@ -0,0 +1,76 @@
+package database_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ad-service-api/database"
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo/integration/mtest"
+)
+
+func TestRescore_Command(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("UpdateMany", func(mt *mtest.T) {
+		mt.AddMockResponses(bson.D{{Key: "ok", Value: 1}, {Key: "n", Value: 3}, {Key: "nModified", Value: 2}})
+
+		rescored, err := database.Rescore(context.Background(), mt.Coll, models.DefaultRanking())
+		assert.NoError(t, err)
+		assert.Equal(t, int64(2), rescored)
+
+		update := mt.GetStartedEvent().Command.Lookup("updates").Array().Index(0).Value().Document()
+		assert.True(t, update.Lookup("multi").Boolean())
+		stages, err := update.Lookup("u").Array().Values()
+		require.NoError(t, err)
+		require.Len(t, stages, 1, "expected an update pipeline")
+		assert.Contains(t, stages[0].Document().Lookup("$set").String(), `"score"`)
+	})
+
+	mt.Run("Error", func(mt *mtest.T) {
+		mt.AddMockResponses(mtest.CreateCommandErrorResponse(mtest.CommandError{Code: 2, Message: "bad value"}))
+
+		_, err := database.Rescore(context.Background(), mt.Coll, models.DefaultRanking())
+		assert.ErrorContains(t, err, "failed to rescore ads")
+	})
+}
+
+func TestRescore(t *testing.T) {
+	col := connectTestCollection(t)
+	ctx := context.Background()
+	now := time.Now()
+
+	// Stored before the listing was ranked, without a priority or a score
+	_, err := col.InsertMany(ctx, []interface{}{
+		bson.M{"title": "legacy", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour)},
+		bson.M{"title": "legacy bid", "startAt": now.Add(-time.Hour), "endAt": now.Add(time.Hour), "bid": 0.3, "budget": 1234.5},
+	})
+	require.NoError(t, err)
+	ranking := models.Ranking{BidWeight: 0.7, BudgetWeight: 0.001, RecencyWeight: 0.1}
+	ad := &models.Advertisement{Title: "current", Bid: 0.1, Budget: 99.9, StartAt: now, EndAt: now.Add(time.Hour)}
+	ad.Score = ranking.Score(ad)
+	_, err = col.InsertOne(ctx, ad)
+	require.NoError(t, err)
+
+	rescored, err := database.Rescore(ctx, col, ranking)
+	require.NoError(t, err)
+	assert.Equal(t, int64(2), rescored, "expected the ad scored by the service to be current")
+
+	cursor, err := col.Find(ctx, bson.M{})
+	require.NoError(t, err)
+	var ads []*models.Advertisement
+	require.NoError(t, cursor.All(ctx, &ads))
+	for _, ad := range ads {
+		assert.Equal(t, ranking.Score(ad), ad.Score, ad.Title)
+	}
+	assert.NoError(t, col.FindOne(ctx, bson.M{"title": "legacy", "priority": 0}).Err(), "expected the missing priority to be set")
+
+	rescored, err = database.Rescore(ctx, col, ranking)
+	require.NoError(t, err)
+	assert.Zero(t, rescored, "expected the scores to be current")
+}
@@ -23,13 +23,40 @@ const docTemplate = `{
                 ],
                 "summary": "List all advertisements with optional query parameters",
                 "operationId": "get-ads",
+                "parameters": [
+                    {
+                        "type": "integer",
+                        "description": "Page size, 5 by default",
+                        "name": "limit",
+                        "in": "query"
+                    },
+                    {
+                        "type": "integer",
+                        "description": "Number of ads skipped, cannot be used with cursor",
+                        "name": "offset",
+                        "in": "query"
+                    },
+                    {
+                        "type": "string",
+                        "description": "nextCursor of the previous page",
+                        "name": "cursor",
+                        "in": "query"
+                    }
+                ],
                 "responses": {
                     "200": {
                         "description": "OK",
                         "schema": {
-                            "type": "array",
-                            "items": {
-                                "$ref": "#/definitions/models.Advertisement"
+                            "$ref": "#/definitions/models.AdPage"
+                        },
+                        "headers": {
+                            "Warning": {
+                                "type": "string",
+                                "description": "RFC 7234 warning 110 (Response is Stale) when X-Cache is STALE"
+                            },
+                            "X-Cache": {
+                                "type": "string",
+                                "description": "HIT, MISS, or STALE when a stale list is served because the database is unavailable"
                             }
                         }
                     }
@@ -59,24 +86,315 @@ const docTemplate = `{
                 "responses": {
                     "201": {
                         "description": "Created",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/ad/{id}": {
+            "get": {
+                "description": "Get a single advertisement by its id",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get advertisement by id",
+                "operationId": "get-ad",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Advertisement id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
                         "schema": {
                             "$ref": "#/definitions/models.Advertisement"
                         }
                     }
                 }
+            },
+            "put": {
+                "description": "Replace an existing advertisement with the input payload",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Update advertisement",
+                "operationId": "update-ad",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Advertisement id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Update ad",
+                        "name": "ad",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.Advertisement"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Advertisement"
+                        }
+                    }
+                }
+            },
+            "delete": {
+                "description": "Delete a single advertisement by its id",
+                "summary": "Delete advertisement",
+                "operationId": "delete-ad",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Advertisement id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    }
+                ],
+                "responses": {
+                    "204": {
+                        "description": "No Content"
+                    }
+                }
+            },
+            "patch": {
+                "description": "Update only the fields present in the input payload, as a JSON merge patch: fields set to null, such as the schedule, are cleared",
+                "consumes": [
+                    "application/json"
+                ],
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Partially update advertisement",
+                "operationId": "patch-ad",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Advertisement id",
+                        "name": "id",
+                        "in": "path",
+                        "required": true
+                    },
+                    {
+                        "description": "Patch ad",
+                        "name": "ad",
+                        "in": "body",
+                        "required": true,
+                        "schema": {
+                            "$ref": "#/definitions/models.AdvertisementPatch"
+                        }
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.Advertisement"
+                        }
+                    }
+                }
+            }
+        },
+        "/api/v1/quota": {
+            "get": {
+                "description": "Get the daily and active ad limits, current usage and remaining headroom of an advertiser. Advertisers without an override share the default quota, and report its usage",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Get quota usage",
+                "operationId": "get-quota",
+                "parameters": [
+                    {
+                        "type": "string",
+                        "description": "Advertiser id, empty for the shared default quota",
+                        "name": "advertiserId",
+                        "in": "query"
+                    }
+                ],
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/models.QuotaUsage"
+                        }
+                    }
+                }
+            }
+        },
+        "/healthz": {
+            "get": {
+                "description": "Always returns 200 while the process can serve requests",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Liveness probe",
+                "operationId": "healthz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "type": "object",
+                            "additionalProperties": {
+                                "type": "string"
+                            }
+                        }
+                    }
+                }
+            }
+        },
+        "/readyz": {
+            "get": {
+                "description": "Pings MongoDB and Redis and reports the status of each dependency. An unreachable MongoDB only degrades the service, since the listing is served from the cache.",
+                "produces": [
+                    "application/json"
+                ],
+                "summary": "Readiness probe",
+                "operationId": "readyz",
+                "responses": {
+                    "200": {
+                        "description": "OK",
+                        "schema": {
+                            "$ref": "#/definitions/health.ReadinessResponse"
+                        }
+                    },
+                    "503": {
+                        "description": "Service Unavailable",
+                        "schema": {
+                            "$ref": "#/definitions/health.ReadinessResponse"
+                        }
+                    }
+                }
             }
         }
     },
     "definitions": {
+        "health.DependencyStatus": {
+            "type": "object",
+            "properties": {
+                "error": {
+                    "type": "string"
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "health.ReadinessResponse": {
+            "type": "object",
+            "properties": {
+                "dependencies": {
+                    "type": "object",
+                    "additionalProperties": {
+                        "$ref": "#/definitions/health.DependencyStatus"
+                    }
+                },
+                "status": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.AdPage": {
+            "type": "object",
+            "properties": {
+                "ads": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Advertisement"
+                    }
+                },
+                "nextCursor": {
+                    "type": "string"
+                }
+            }
+        },
         "models.Advertisement": {
             "type": "object",
             "properties": {
+                "advertiserId": {
+                    "type": "string"
+                },
+                "bid": {
+                    "description": "Bid is what the advertiser pays per thousand impressions, and Budget what remains of its spend",
+                    "type": "number"
+                },
+                "budget": {
+                    "type": "number"
+                },
                 "conditions": {
                     "$ref": "#/definitions/models.Conditions"
                 },
                 "endAt": {
                     "type": "string"
                 },
+                "id": {
+                    "type": "string"
+                },
+                "priority": {
+                    "description": "Priority ranks the ad above every ad of a lower priority, whatever their scores",
+                    "type": "integer"
+                },
+                "schedule": {
+                    "description": "Schedule, when set, only runs the ad in its weekly windows between StartAt and EndAt",
+                    "allOf": [
+                        {
+                            "$ref": "#/definitions/models.Schedule"
+                        }
+                    ]
+                },
+                "score": {
+                    "description": "Score ranks the ad within its priority; the service computes it from the bid, the budget and the start when the ad is saved",
+                    "type": "number"
+                },
+                "startAt": {
+                    "type": "string"
+                },
+                "title": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.AdvertisementPatch": {
+            "type": "object",
+            "properties": {
+                "bid": {
+                    "type": "number"
+                },
+                "budget": {
+                    "type": "number"
+                },
+                "conditions": {
+                    "$ref": "#/definitions/models.Conditions"
+                },
+                "endAt": {
+                    "type": "string"
+                },
+                "priority": {
+                    "type": "integer"
+                },
+                "schedule": {
+                    "$ref": "#/definitions/models.Schedule"
+                },
                 "startAt": {
                     "type": "string"
                 },
@@ -94,23 +412,128 @@ const docTemplate = `{
                 "ageStart": {
                     "type": "integer"
                 },
+                "appVersion": {
+                    "$ref": "#/definitions/models.VersionRange"
+                },
                 "country": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "excludeCountry": {
+                    "description": "ExcludeCountry and ExcludePlatform are never shown the ad, even when Country or Platform is empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "excludePlatform": {
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
                 "gender": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
                 },
+                "language": {
+                    "description": "Language holds BCP 47 tags, each also matching the more specific tags under it, e.g. zh matches zh-TW",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "osVersion": {
+                    "$ref": "#/definitions/models.VersionRange"
+                },
                 "platform": {
                     "type": "array",
                     "items": {
                         "type": "string"
                     }
+                },
+                "region": {
+                    "description": "Region holds ISO 3166-2 subdivision codes, such as TW-TPE",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                }
+            }
+        },
+        "models.QuotaStatus": {
+            "type": "object",
+            "properties": {
+                "limit": {
+                    "type": "integer"
+                },
+                "remaining": {
+                    "type": "integer"
+                },
+                "used": {
+                    "type": "integer"
+                }
+            }
+        },
+        "models.QuotaUsage": {
+            "type": "object",
+            "properties": {
+                "active": {
+                    "$ref": "#/definitions/models.QuotaStatus"
+                },
+                "advertiserId": {
+                    "type": "string"
+                },
+                "daily": {
+                    "$ref": "#/definitions/models.QuotaStatus"
+                }
+            }
+        },
+        "models.Schedule": {
+            "type": "object",
+            "properties": {
+                "timezone": {
+                    "description": "Timezone is the IANA name of the timezone the windows are in, such as Asia/Taipei",
+                    "type": "string"
+                },
+                "windows": {
+                    "type": "array",
+                    "items": {
+                        "$ref": "#/definitions/models.Window"
+                    }
+                }
+            }
+        },
+        "models.VersionRange": {
+            "type": "object",
+            "properties": {
+                "max": {
+                    "type": "string"
+                },
+                "min": {
+                    "type": "string"
+                }
+            }
+        },
+        "models.Window": {
+            "type": "object",
+            "properties": {
+                "days": {
+                    "description": "Days holds the days the window starts on, mon to sun, every day when empty",
+                    "type": "array",
+                    "items": {
+                        "type": "string"
+                    }
+                },
+                "end": {
+                    "type": "string"
+                },
+                "start": {
+                    "type": "string"
                 }
             }
         }
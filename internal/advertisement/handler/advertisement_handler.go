@@ -1,25 +1,48 @@
 package handler
 
 import (
-	"ad-service-api/database"
+	"ad-service-api/internal/advertisement/repository"
 	"ad-service-api/internal/advertisement/service"
+	"ad-service-api/internal/logging"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/models"
 	"ad-service-api/internal/validators"
 	"ad-service-api/redis"
+	"errors"
 	"net/http"
 	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
+// Values of the CacheHeader response header of ListAdHandler.
+const (
+	CacheHeader = "X-Cache"
+	CacheHit    = "HIT"
+	CacheMiss   = "MISS"
+	CacheStale  = "STALE"
+)
+
+// staleWarning is the Warning header sent with an ad list served stale, as defined in RFC 7234.
+const staleWarning = `110 - "Response is Stale"`
+
 type AdvertisementHandler struct {
 	AdvertisementService service.IAdvertisementService
+	CacheTTL             time.Duration
+	// StaleTTL is how long an ad list is kept after CacheTTL, to be served while MongoDB is unavailable
+	StaleTTL time.Duration
+	// RefreshTimeout bounds the MongoDB queries of a refresh, after which the stale list is served
+	RefreshTimeout time.Duration
 }
 
-func NewAdvertisementHandler(adService service.IAdvertisementService) *AdvertisementHandler {
+func NewAdvertisementHandler(adService service.IAdvertisementService, cacheTTL, staleTTL, refreshTimeout time.Duration) *AdvertisementHandler {
 	return &AdvertisementHandler{
 		AdvertisementService: adService,
+		CacheTTL:             cacheTTL,
+		StaleTTL:             staleTTL,
+		RefreshTimeout:       refreshTimeout,
 	}
 }
 
@@ -30,61 +53,236 @@ func NewAdvertisementHandler(adService service.IAdvertisementService) *Advertise
 // @Accept  json
 // @Produce  json
 // @Param ad body models.Advertisement true "Create ad"
-// @Success 201 {object} models.Advertisement
+// @Success 201 {object} map[string]string
 // @Router /api/v1/ad [post]
 func (h *AdvertisementHandler) CreateAdHandler(c *gin.Context) {
 	var ad models.Advertisement
 	now := time.Now()
-	today := now.Format("2006-01-02")
 	if err := c.ShouldBindJSON(&ad); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
 		return
 	}
+	// The id is always assigned by the storage layer
+	ad.ID = primitive.NilObjectID
 	// Validate the advertisement fields
 	if err := validators.CreateAdValueValidation(ad); err != nil {
 		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement data: " + err.Error()})
 		return
 	}
 
-	dailyAdCount, err := h.AdvertisementService.GetByDate(c, today)
+	// Check the daily and active ad limits and create the ad atomically
+	err := h.AdvertisementService.CreateWithQuota(c, &ad, now)
+	if errors.Is(err, service.ErrDailyLimitReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot create more ads today. Daily limit reached."})
+		return
+	}
+	if errors.Is(err, service.ErrActiveLimitReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot create more ads. Active ads limit reached."})
+		return
+	}
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get daily ad count: " + err.Error()})
+		internalError(c, "Failed to create advertisement", err)
 		return
 	}
-	// Ensure daily ad creation limit isn't exceeded
-	if dailyAdCount >= 3000 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot create more ads today. Daily limit reached."})
+
+	// Invalidate the cached lists the new ad can appear in
+	if err := h.AdvertisementService.InvalidateAds(c, &ad); err != nil {
+		internalError(c, "Failed to invalidate cache", err)
+		return
+	}
+
+	c.JSON(http.StatusCreated, gin.H{"message": "Advertisement created successfully", "id": ad.ID.Hex()})
+}
+
+// GetAdHandler retrieves a single advertisement
+// @Summary Get advertisement by id
+// @Description Get a single advertisement by its id
+// @ID get-ad
+// @Produce  json
+// @Param id path string true "Advertisement id"
+// @Success 200 {object} models.Advertisement
+// @Router /api/v1/ad/{id} [get]
+func (h *AdvertisementHandler) GetAdHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := validators.ValidateAdID(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement id: " + err.Error()})
+		return
+	}
+
+	ad, err := h.AdvertisementService.GetByID(c, id)
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
 		return
 	}
-	// Ensure total active ads limit isn't exceeded
-	activeAdCount, err := h.AdvertisementService.CountActive(c, now)
 	if err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to get active ad count: " + err.Error()})
+		internalError(c, "Failed to get advertisement", err)
 		return
 	}
-	// Ensure total active ads limit isn't exceeded
-	if activeAdCount >= 1000 {
-		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot create more ads. Active ads limit reached."})
+
+	c.JSON(http.StatusOK, ad)
+}
+
+// UpdateAdHandler replaces an existing advertisement
+// @Summary Update advertisement
+// @Description Replace an existing advertisement with the input payload
+// @ID update-ad
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Advertisement id"
+// @Param ad body models.Advertisement true "Update ad"
+// @Success 200 {object} models.Advertisement
+// @Router /api/v1/ad/{id} [put]
+func (h *AdvertisementHandler) UpdateAdHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := validators.ValidateAdID(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement id: " + err.Error()})
 		return
 	}
-	// Ad passes all checks; proceed to add
-	if err := h.AdvertisementService.Create(c, &ad); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to create advertisement: " + err.Error()})
+
+	var ad models.Advertisement
+	if err := c.ShouldBindJSON(&ad); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
+		return
+	}
+	// Validate the advertisement fields
+	if err := validators.CreateAdValueValidation(ad); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement data: " + err.Error()})
+		return
+	}
+
+	// The advertiser is fixed at creation, since the ad counts against its quota
+	existingAd, err := h.AdvertisementService.GetByID(c, id)
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to get advertisement", err)
+		return
+	}
+	ad.AdvertiserID = existingAd.AdvertiserID
+
+	h.saveAd(c, id, existingAd, &ad)
+}
+
+// PatchAdHandler partially updates an existing advertisement
+// @Summary Partially update advertisement
+// @Description Update only the fields present in the input payload, as a JSON merge patch: fields set to null, such as the schedule, are cleared
+// @ID patch-ad
+// @Accept  json
+// @Produce  json
+// @Param id path string true "Advertisement id"
+// @Param ad body models.AdvertisementPatch true "Patch ad"
+// @Success 200 {object} models.Advertisement
+// @Router /api/v1/ad/{id} [patch]
+func (h *AdvertisementHandler) PatchAdHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := validators.ValidateAdID(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement id: " + err.Error()})
+		return
+	}
+
+	var patch models.AdvertisementPatch
+	if err := c.ShouldBindJSON(&patch); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid JSON format: " + err.Error()})
 		return
 	}
-	// Increment the Redis counter for today's ads
-	if err := h.AdvertisementService.IncrByDate(c, today); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to increment ad count: " + err.Error()})
+
+	ad, err := h.AdvertisementService.GetByID(c, id)
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to get advertisement", err)
 		return
 	}
 
-	// Invalidate the cache for the list of ads
-	if err := h.AdvertisementService.DeleteAdsByPattern(c, "ads:*"); err != nil {
-		c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to invalidate cache: " + err.Error()})
+	// Validate the merged advertisement fields
+	previous := *ad
+	patch.Apply(ad)
+	if err := validators.CreateAdValueValidation(*ad); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement data: " + err.Error()})
 		return
 	}
 
-	c.JSON(http.StatusCreated, gin.H{"message": "Advertisement created successfully"})
+	h.saveAd(c, id, &previous, ad)
+}
+
+// saveAd persists an updated advertisement, within the active ad limit if it makes the ad active,
+// and invalidates the cached lists that previous, the stored version, or ad match.
+func (h *AdvertisementHandler) saveAd(c *gin.Context, id string, previous, ad *models.Advertisement) {
+	err := h.AdvertisementService.UpdateWithQuota(c, id, ad, time.Now())
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
+		return
+	}
+	if errors.Is(err, service.ErrActiveLimitReached) {
+		c.JSON(http.StatusForbidden, gin.H{"error": "Cannot activate more ads. Active ads limit reached."})
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to update advertisement", err)
+		return
+	}
+
+	// Invalidate the cached lists the ad appeared in before or can appear in now
+	if err := h.AdvertisementService.InvalidateAds(c, previous, ad); err != nil {
+		internalError(c, "Failed to invalidate cache", err)
+		return
+	}
+
+	c.JSON(http.StatusOK, ad)
+}
+
+// internalError logs err with the request id and responds with a 500.
+func internalError(c *gin.Context, msg string, err error) {
+	logging.FromContext(c).Error(msg, "error", err)
+	c.JSON(http.StatusInternalServerError, gin.H{"error": msg + ": " + err.Error()})
+}
+
+// DeleteAdHandler deletes an advertisement
+// @Summary Delete advertisement
+// @Description Delete a single advertisement by its id
+// @ID delete-ad
+// @Param id path string true "Advertisement id"
+// @Success 204
+// @Router /api/v1/ad/{id} [delete]
+func (h *AdvertisementHandler) DeleteAdHandler(c *gin.Context) {
+	id := c.Param("id")
+	if err := validators.ValidateAdID(id); err != nil {
+		c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid advertisement id: " + err.Error()})
+		return
+	}
+
+	// Keep the ad to know which cached lists it appeared in
+	ad, err := h.AdvertisementService.GetByID(c, id)
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to get advertisement", err)
+		return
+	}
+
+	err = h.AdvertisementService.Delete(c, id)
+	if errors.Is(err, repository.ErrAdNotFound) {
+		c.JSON(http.StatusNotFound, gin.H{"error": "Advertisement not found"})
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to delete advertisement", err)
+		return
+	}
+
+	// Invalidate the cached lists the ad appeared in
+	if err := h.AdvertisementService.InvalidateAds(c, ad); err != nil {
+		internalError(c, "Failed to invalidate cache", err)
+		return
+	}
+
+	c.Status(http.StatusNoContent)
 }
 
 // ListAdHandler lists all advertisements with optional query parameters
@@ -92,7 +290,12 @@ func (h *AdvertisementHandler) CreateAdHandler(c *gin.Context) {
 // @Description Get a list of all advertisements with optional query parameters
 // @ID get-ads
 // @Produce  json
-// @Success 200 {array} models.Advertisement
+// @Param limit query int false "Page size, 5 by default"
+// @Param offset query int false "Number of ads skipped, cannot be used with cursor"
+// @Param cursor query string false "nextCursor of the previous page"
+// @Success 200 {object} models.AdPage
+// @Header 200 {string} X-Cache "HIT, MISS, or STALE when a stale list is served because the database is unavailable"
+// @Header 200 {string} Warning "RFC 7234 warning 110 (Response is Stale) when X-Cache is STALE"
 // @Router /api/v1/ad [get]
 func (h *AdvertisementHandler) ListAdHandler(c *gin.Context) {
 	now := time.Now()
@@ -105,37 +308,78 @@ func (h *AdvertisementHandler) ListAdHandler(c *gin.Context) {
 
 	// Generate a unique key for this set of query parameters
 	key := redis.GenerateRedisKey(validQueryParams)
+	limit, _ := strconv.Atoi(validQueryParams["limit"])
 
 	// Try to get the result from Redis first
-	result, _ := h.AdvertisementService.GetAdsByKey(c, key)
+	cached, _ := h.AdvertisementService.GetAdsByKey(c, key)
 
-	// Check if the ad from redis is expired
-	isAdexpired := h.AdvertisementService.IsAdExpired(result, now)
+	// Check if the list from redis is still fresh and none of its ads has expired
+	fresh := cached != nil && cached.Fresh(now) && !h.AdvertisementService.IsAdExpired(cached.Ads, now)
 
-	if result == nil || isAdexpired {
-		// Create a filter, limit, and offset based on the query parameters
-		filter := database.CreateFilter(validQueryParams)
-		limit, _ := strconv.Atoi(validQueryParams["limit"])
-		offset, _ := strconv.Atoi(validQueryParams["offset"])
+	switch {
+	case cached == nil:
+		metrics.CacheLookupsTotal.WithLabelValues(metrics.CacheMiss).Inc()
+	case !fresh:
+		metrics.CacheLookupsTotal.WithLabelValues(metrics.CacheStale).Inc()
+	default:
+		metrics.CacheLookupsTotal.WithLabelValues(metrics.CacheHit).Inc()
+	}
 
-		// If the result is not in Redis, get it from the database
-		filteredAds, err := h.AdvertisementService.Fetch(c, filter, limit, offset)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to list advertisements: " + err.Error()})
-			return
-		}
+	if fresh {
+		// Return the result from Redis
+		c.Header(CacheHeader, CacheHit)
+		c.JSON(http.StatusOK, models.NewAdPage(cached.Ads, limit))
+		return
+	}
+
+	// Create a query and offset based on the query parameters, a cursor is part of the query
+	query := models.NewAdQuery(validQueryParams, now)
+	offset, _ := strconv.Atoi(validQueryParams["offset"])
 
-		// Store the result in Redis for future use
-		err = h.AdvertisementService.SetAdsByKey(c, key, filteredAds, time.Hour)
-		if err != nil {
-			c.JSON(http.StatusInternalServerError, gin.H{"error": "Failed to cache advertisements: " + err.Error()})
+	// If the result is not in Redis, get it from the database and cache it, once for all
+	// the concurrent requests for the same key
+	filteredAds, err := h.AdvertisementService.RefreshAds(c, key, query, limit, offset, h.CacheTTL, h.StaleTTL, h.RefreshTimeout)
+	if err != nil && cached != nil {
+		// Serve the stale list rather than failing while the database is unavailable
+		logging.FromContext(c).Warn("serving stale advertisements", "key", key, "error", err)
+		metrics.StaleResponsesTotal.Inc()
+		c.Header(CacheHeader, CacheStale)
+		c.Header("Warning", staleWarning)
+		c.JSON(http.StatusOK, models.NewAdPage(cached.Ads, limit))
+		return
+	}
+	if err != nil {
+		internalError(c, "Failed to list advertisements", err)
+		return
+	}
+
+	// Return the result
+	c.Header(CacheHeader, CacheMiss)
+	c.JSON(http.StatusOK, models.NewAdPage(filteredAds, limit))
+}
+
+// GetQuotaHandler reports the quota usage of an advertiser
+// @Summary Get quota usage
+// @Description Get the daily and active ad limits, current usage and remaining headroom of an advertiser. Advertisers without an override share the default quota, and report its usage
+// @ID get-quota
+// @Produce  json
+// @Param advertiserId query string false "Advertiser id, empty for the shared default quota"
+// @Success 200 {object} models.QuotaUsage
+// @Router /api/v1/quota [get]
+func (h *AdvertisementHandler) GetQuotaHandler(c *gin.Context) {
+	advertiserID := c.Query("advertiserId")
+	if advertiserID != "" {
+		if err := validators.ValidateAdvertiserID(advertiserID); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": "Invalid query parameters: " + err.Error()})
 			return
 		}
+	}
 
-		// Return the result
-		c.JSON(http.StatusOK, gin.H{"ads": filteredAds})
-	} else {
-		// Return the result from Redis
-		c.JSON(http.StatusOK, gin.H{"ads": result})
+	usage, err := h.AdvertisementService.GetQuota(c, advertiserID, time.Now())
+	if err != nil {
+		internalError(c, "Failed to get quota", err)
+		return
 	}
+
+	c.JSON(http.StatusOK, usage)
 }
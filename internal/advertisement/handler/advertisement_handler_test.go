@@ -2,19 +2,27 @@ package handler_test
 
 import (
 	"ad-service-api/internal/advertisement/handler"
+	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/internal/advertisement/service"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/models"
 	"ad-service-api/mocks"
 	"bytes"
+	"encoding/base64"
 	"encoding/json"
+	"errors"
 	"net/http"
 	"net/http/httptest"
+	"strconv"
 	"testing"
 	"time"
 
 	"github.com/gin-gonic/gin"
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
 	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type AdvertisementHandlerSuite struct {
@@ -25,7 +33,7 @@ type AdvertisementHandlerSuite struct {
 
 func (suite *AdvertisementHandlerSuite) SetupTest() {
 	suite.mockAdService = new(mocks.MockAdvertisementService)
-	suite.h = handler.NewAdvertisementHandler(suite.mockAdService)
+	suite.h = handler.NewAdvertisementHandler(suite.mockAdService, time.Hour, 15*time.Minute, 3*time.Second)
 }
 
 func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler() {
@@ -43,11 +51,10 @@ func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler
 		},
 	}
 
-	suite.mockAdService.On("GetByDate", mock.Anything, now.Format("2006-01-02")).Return(1, nil)
-	suite.mockAdService.On("CountActive", mock.AnythingOfType("*gin.Context"), mock.AnythingOfType("time.Time")).Return(1, nil)
-	suite.mockAdService.On("Create", mock.Anything, mock.AnythingOfType("*models.Advertisement")).Return(nil)
-	suite.mockAdService.On("IncrByDate", mock.Anything, now.Format("2006-01-02")).Return(nil)
-	suite.mockAdService.On("DeleteAdsByPattern", mock.Anything, mock.Anything).Return(nil)
+	suite.mockAdService.On("CreateWithQuota", mock.AnythingOfType("*gin.Context"), mock.AnythingOfType("*models.Advertisement"), mock.AnythingOfType("time.Time")).Return(nil)
+	suite.mockAdService.On("InvalidateAds", mock.Anything, mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Title == "Test Ad"
+	})).Return(nil)
 
 	// Create a response recorder
 	w := httptest.NewRecorder()
@@ -65,6 +72,103 @@ func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler
 	suite.mockAdService.AssertExpectations(suite.T())
 }
 
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler_DailyLimitReached() {
+	now := time.Now().Round(time.Second)
+	ad := &models.Advertisement{
+		Title:   "Test Ad",
+		StartAt: now,
+		EndAt:   now.Add(24 * time.Hour),
+		Conditions: models.Conditions{
+			AgeStart: 18,
+			AgeEnd:   24,
+		},
+	}
+
+	suite.mockAdService.On("CreateWithQuota", mock.Anything, mock.AnythingOfType("*models.Advertisement"), mock.AnythingOfType("time.Time")).Return(service.ErrDailyLimitReached)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	adJson, _ := json.Marshal(ad)
+	c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/ad", bytes.NewBuffer(adJson))
+	c.Request.Header.Set("Content-Type", "application/json")
+
+	suite.h.CreateAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler_InvalidConditions() {
+	now := time.Now().Round(time.Second)
+	for _, conditions := range []models.Conditions{
+		{AgeStart: 18, AgeEnd: 24, Country: []string{"TW", "JP"}, ExcludeCountry: []string{"JP"}},
+		{AgeStart: 18, AgeEnd: 24, ExcludePlatform: []string{"tv"}},
+		{AgeStart: 18, AgeEnd: 24, Language: []string{"klingon"}},
+		{AgeStart: 18, AgeEnd: 24, Country: []string{"JP"}, Region: []string{"TW-TPE"}},
+		{AgeStart: 18, AgeEnd: 24, OSVersion: &models.VersionRange{Min: "17", Max: "16.4"}},
+	} {
+		adJson, _ := json.Marshal(&models.Advertisement{Title: "Test Ad", StartAt: now, EndAt: now.Add(time.Hour), Conditions: conditions})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/ad", bytes.NewBuffer(adJson))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		suite.h.CreateAdHandler(c)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	}
+	suite.mockAdService.AssertNotCalled(suite.T(), "CreateWithQuota", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler_InvalidSchedule() {
+	now := time.Now().Round(time.Second)
+	for _, schedule := range []*models.Schedule{
+		{Timezone: "Asia/Taipei"},
+		{Timezone: "Asia/Tokio", Windows: []models.Window{{Start: "09:00", End: "17:00"}}},
+		{Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"friday"}, Start: "09:00", End: "17:00"}}},
+		{Timezone: "Asia/Taipei", Windows: []models.Window{{Start: "9:00pm", End: "23:00"}}},
+	} {
+		adJson, _ := json.Marshal(&models.Advertisement{Title: "Test Ad", StartAt: now, EndAt: now.Add(time.Hour), Conditions: models.Conditions{AgeStart: 18, AgeEnd: 24}, Schedule: schedule})
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/ad", bytes.NewBuffer(adJson))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		suite.h.CreateAdHandler(c)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+		assert.Contains(suite.T(), w.Body.String(), "invalid schedule")
+	}
+	suite.mockAdService.AssertNotCalled(suite.T(), "CreateWithQuota", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_CreateAdHandler_InvalidRanking() {
+	now := time.Now().Round(time.Second)
+	for _, ad := range []models.Advertisement{
+		{Priority: -1},
+		{Bid: -0.5},
+		{Budget: -100},
+	} {
+		ad.Title, ad.StartAt, ad.EndAt = "Test Ad", now, now.Add(time.Hour)
+		ad.Conditions = models.Conditions{AgeStart: 18, AgeEnd: 24}
+		adJson, _ := json.Marshal(&ad)
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodPost, "/api/v1/ad", bytes.NewBuffer(adJson))
+		c.Request.Header.Set("Content-Type", "application/json")
+
+		suite.h.CreateAdHandler(c)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+		assert.Contains(suite.T(), w.Body.String(), "must not be negative")
+	}
+	suite.mockAdService.AssertNotCalled(suite.T(), "CreateWithQuota", mock.Anything, mock.Anything, mock.Anything)
+}
+
 func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler() {
 	expectedLimit := 5
 	expectedOffset := 0
@@ -77,13 +181,9 @@ func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler()
 
 	// Mock GetAdsByKey to return nil, indicating cache miss
 	suite.mockAdService.On("GetAdsByKey", mock.Anything, mock.Anything).Return(nil, nil)
-	suite.mockAdService.On("IsAdExpired", mock.Anything, mock.AnythingOfType("time.Time")).Return(false)
 
 	// Set the call expectation for the mock method, return specific test data
-	suite.mockAdService.On("Fetch", mock.AnythingOfType("*gin.Context"), mock.AnythingOfType("primitive.M"), expectedLimit, expectedOffset).Return(expectedAds, nil)
-
-	// Mock SetAdsByKey to cache the result
-	suite.mockAdService.On("SetAdsByKey", mock.Anything, mock.Anything, mock.Anything, mock.Anything).Return(nil)
+	suite.mockAdService.On("RefreshAds", mock.AnythingOfType("*gin.Context"), "ads:limit:5:offset:0", mock.AnythingOfType("models.AdQuery"), expectedLimit, expectedOffset, time.Hour, 15*time.Minute, 3*time.Second).Return(expectedAds, nil)
 
 	// Create response recorder and gin context
 	w := httptest.NewRecorder()
@@ -95,6 +195,7 @@ func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler()
 
 	// Verify the response status code
 	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), handler.CacheMiss, w.Header().Get(handler.CacheHeader))
 
 	// Parse the response body, verify if the returned ad data meets expectations
 	var adsResponse gin.H
@@ -117,6 +218,477 @@ func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler()
 	suite.mockAdService.AssertExpectations(suite.T())
 }
 
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_CacheHit() {
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "Cached Ad"}}, FreshUntil: time.Now().Add(time.Minute)}
+	hits := testutil.ToFloat64(metrics.CacheLookupsTotal.WithLabelValues(metrics.CacheHit))
+
+	// Mock GetAdsByKey to return the cached ads, so the database is not queried
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, mock.Anything).Return(cached, nil)
+	suite.mockAdService.On("IsAdExpired", cached.Ads, mock.AnythingOfType("time.Time")).Return(false)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), handler.CacheHit, w.Header().Get(handler.CacheHeader))
+	assert.Equal(suite.T(), hits+1, testutil.ToFloat64(metrics.CacheLookupsTotal.WithLabelValues(metrics.CacheHit)))
+	suite.mockAdService.AssertNotCalled(suite.T(), "RefreshAds", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_RefreshFailed() {
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, mock.Anything).Return(nil, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, mock.Anything, mock.Anything, 5, 0, time.Hour, 15*time.Minute, 3*time.Second).Return(nil, errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusInternalServerError, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_ServeStale() {
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "Cached Ad"}}, FreshUntil: time.Now().Add(-time.Minute)}
+	staleResponses := testutil.ToFloat64(metrics.StaleResponsesTotal)
+
+	// The cached list is no longer fresh and the database is down
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, mock.Anything).Return(cached, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, mock.Anything, mock.Anything, 5, 0, time.Hour, 15*time.Minute, 3*time.Second).Return(nil, errors.New("connection refused"))
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), handler.CacheStale, w.Header().Get(handler.CacheHeader))
+	assert.Equal(suite.T(), `110 - "Response is Stale"`, w.Header().Get("Warning"))
+	assert.Contains(suite.T(), w.Body.String(), "Cached Ad")
+	assert.Equal(suite.T(), staleResponses+1, testutil.ToFloat64(metrics.StaleResponsesTotal))
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_RefreshStale() {
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "Cached Ad"}}, FreshUntil: time.Now().Add(-time.Minute)}
+	refreshed := []*models.Advertisement{{Title: "New Ad"}}
+
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, mock.Anything).Return(cached, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, mock.Anything, mock.Anything, 5, 0, time.Hour, 15*time.Minute, 3*time.Second).Return(refreshed, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.Equal(suite.T(), handler.CacheMiss, w.Header().Get(handler.CacheHeader))
+	assert.Empty(suite.T(), w.Header().Get("Warning"))
+	assert.Contains(suite.T(), w.Body.String(), "New Ad")
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_Cursor() {
+	last := &models.Advertisement{ID: primitive.NewObjectID(), Title: "Test Ad 2", EndAt: time.Now().Add(time.Hour).UTC()}
+	page := []*models.Advertisement{{ID: primitive.NewObjectID(), Title: "Test Ad 1"}, last}
+	cursor := models.CursorAfter(&models.Advertisement{ID: primitive.NewObjectID(), EndAt: time.Now().UTC()})
+
+	// The cursor replaces the offset in the cache key and is passed down in the query
+	key := "ads:cursor:" + cursor.Encode() + ":limit:2"
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, key).Return(nil, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, key, mock.MatchedBy(func(query models.AdQuery) bool {
+		return query.After != nil && *query.After == cursor
+	}), 2, 0, time.Hour, 15*time.Minute, 3*time.Second).Return(page, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad?limit=2&cursor="+cursor.Encode(), nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	var body models.AdPage
+	assert.NoError(suite.T(), json.NewDecoder(w.Body).Decode(&body))
+	assert.Len(suite.T(), body.Ads, 2)
+
+	// The page is full, so it points to the next one
+	next, err := models.DecodeCursor(body.NextCursor)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), models.CursorAfter(last), next)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_LastPage() {
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "Cached Ad"}}, FreshUntil: time.Now().Add(time.Minute)}
+
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, "ads:limit:5:offset:0").Return(cached, nil)
+	suite.mockAdService.On("IsAdExpired", cached.Ads, mock.AnythingOfType("time.Time")).Return(false)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.NotContains(suite.T(), w.Body.String(), "nextCursor")
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_InvalidCursor() {
+	id := primitive.NewObjectID()
+	cursor := models.CursorAfter(&models.Advertisement{ID: id, EndAt: time.Now()}).Encode()
+	// A cursor from before the listing was ranked holds the end and the id only
+	legacy := base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(time.Now().UnixNano(), 10) + "." + id.Hex()))
+
+	for _, rawQuery := range []string{"cursor=not-a-cursor", "cursor=" + cursor + "&offset=5", "cursor=" + legacy} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad?"+rawQuery, nil)
+
+		suite.h.ListAdHandler(c)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code, rawQuery)
+		assert.Contains(suite.T(), w.Body.String(), "cursor validation failed")
+	}
+	suite.mockAdService.AssertNotCalled(suite.T(), "GetAdsByKey", mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_ValueSets() {
+	// Repeated values are sorted and deduplicated, so the same set always has the same key
+	key := "ads:country:JP,TW:limit:5:offset:0:platform!:web"
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, key).Return(nil, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, key, mock.MatchedBy(func(query models.AdQuery) bool {
+		return assert.ObjectsAreEqual([]string{"JP", "TW"}, query.Country) && assert.ObjectsAreEqual([]string{"web"}, query.NotPlatform)
+	}), 5, 0, time.Hour, 15*time.Minute, 3*time.Second).Return([]*models.Advertisement{}, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad?country=TW&country=JP&country=TW&platform!=web", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_ExtendedTargeting() {
+	// Versions are keyed in their canonical form
+	key := "ads:appVersion:3.0.0:language:en,zh-TW:limit:5:offset:0:osVersion:17.2.0:region:TW-TPE"
+	suite.mockAdService.On("GetAdsByKey", mock.Anything, key).Return(nil, nil)
+	suite.mockAdService.On("RefreshAds", mock.Anything, key, mock.MatchedBy(func(query models.AdQuery) bool {
+		return assert.ObjectsAreEqual([]string{"en", "zh-TW"}, query.Language) && assert.ObjectsAreEqual([]string{"TW-TPE"}, query.Region) &&
+			*query.OSVersion == models.Version{Major: 17, Minor: 2} && *query.AppVersion == models.Version{Major: 3}
+	}), 5, 0, time.Hour, 15*time.Minute, 3*time.Second).Return([]*models.Advertisement{}, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad?language=zh-TW&language=en&region=TW-TPE&osVersion=17.2&appVersion=v3", nil)
+
+	suite.h.ListAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_ListAdHandler_InvalidValueSets() {
+	for _, rawQuery := range []string{
+		"country=TW&country!=JP", "country=TW&country=XX", "platform!=tv", "age=20&age=30",
+		"language=zh-tw", "language!=en", "region=TW-XXX", "region=TW", "osVersion=1.2.3.4", "appVersion=1&appVersion=2",
+	} {
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad?"+rawQuery, nil)
+
+		suite.h.ListAdHandler(c)
+
+		assert.Equal(suite.T(), http.StatusBadRequest, w.Code, rawQuery)
+	}
+	suite.mockAdService.AssertNotCalled(suite.T(), "GetAdsByKey", mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_GetAdHandler() {
+	id := primitive.NewObjectID()
+	expectedAd := &models.Advertisement{ID: id, Title: "Test Ad"}
+
+	suite.mockAdService.On("GetByID", mock.Anything, id.Hex()).Return(expectedAd, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad/"+id.Hex(), nil)
+	c.Params = gin.Params{{Key: "id", Value: id.Hex()}}
+
+	suite.h.GetAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var ad models.Advertisement
+	err := json.NewDecoder(w.Body).Decode(&ad)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), *expectedAd, ad)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_GetAdHandler_NotFound() {
+	id := primitive.NewObjectID().Hex()
+
+	suite.mockAdService.On("GetByID", mock.Anything, id).Return(nil, repository.ErrAdNotFound)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/ad/"+id, nil)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+
+	suite.h.GetAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusNotFound, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_UpdateAdHandler() {
+	id := primitive.NewObjectID().Hex()
+	now := time.Now().Round(time.Second)
+	ad := &models.Advertisement{
+		Title:   "Updated Ad",
+		StartAt: now,
+		EndAt:   now.Add(24 * time.Hour),
+		Conditions: models.Conditions{
+			AgeStart: 18,
+			AgeEnd:   24,
+		},
+	}
+
+	existingAd := &models.Advertisement{AdvertiserID: "acme"}
+	isUpdatedAd := mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Title == "Updated Ad" && ad.AdvertiserID == "acme"
+	})
+	suite.mockAdService.On("GetByID", mock.Anything, id).Return(existingAd, nil)
+	suite.mockAdService.On("UpdateWithQuota", mock.Anything, id, isUpdatedAd, mock.AnythingOfType("time.Time")).Return(nil)
+	// Both the lists the ad was in and the ones it enters are invalidated
+	suite.mockAdService.On("InvalidateAds", mock.Anything, existingAd, isUpdatedAd).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	adJson, _ := json.Marshal(ad)
+	c.Request, _ = http.NewRequest(http.MethodPut, "/api/v1/ad/"+id, bytes.NewBuffer(adJson))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id}}
+
+	suite.h.UpdateAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_PatchAdHandler() {
+	id := primitive.NewObjectID()
+	now := time.Now().Round(time.Second)
+	existingAd := &models.Advertisement{
+		ID:      id,
+		Title:   "Test Ad",
+		StartAt: now,
+		EndAt:   now.Add(24 * time.Hour),
+		Conditions: models.Conditions{
+			AgeStart: 18,
+			AgeEnd:   24,
+		},
+	}
+
+	isPatchedAd := mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Title == "Patched Ad" && ad.EndAt.Equal(now.Add(24*time.Hour))
+	})
+	suite.mockAdService.On("GetByID", mock.Anything, id.Hex()).Return(existingAd, nil)
+	suite.mockAdService.On("UpdateWithQuota", mock.Anything, id.Hex(), isPatchedAd, mock.AnythingOfType("time.Time")).Return(nil)
+	suite.mockAdService.On("InvalidateAds", mock.Anything, mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Title == "Test Ad"
+	}), isPatchedAd).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPatch, "/api/v1/ad/"+id.Hex(), bytes.NewBufferString(`{"title": "Patched Ad"}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id.Hex()}}
+
+	suite.h.PatchAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_PatchAdHandler_ClearSchedule() {
+	id := primitive.NewObjectID()
+	now := time.Now().Round(time.Second)
+	existingAd := &models.Advertisement{
+		ID:         id,
+		Title:      "Test Ad",
+		StartAt:    now,
+		EndAt:      now.Add(24 * time.Hour),
+		Conditions: models.Conditions{AgeStart: 18, AgeEnd: 24},
+		Schedule:   &models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{{Start: "18:00", End: "23:00"}}},
+	}
+
+	isUnscheduledAd := mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Title == "Test Ad" && ad.Schedule == nil
+	})
+	suite.mockAdService.On("GetByID", mock.Anything, id.Hex()).Return(existingAd, nil)
+	suite.mockAdService.On("UpdateWithQuota", mock.Anything, id.Hex(), isUnscheduledAd, mock.AnythingOfType("time.Time")).Return(nil)
+	suite.mockAdService.On("InvalidateAds", mock.Anything, mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.Schedule != nil
+	}), isUnscheduledAd).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPatch, "/api/v1/ad/"+id.Hex(), bytes.NewBufferString(`{"schedule": null}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id.Hex()}}
+
+	suite.h.PatchAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+	assert.NotContains(suite.T(), w.Body.String(), "schedule")
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_PatchAdHandler_ClearRequiredField() {
+	id := primitive.NewObjectID()
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodPatch, "/api/v1/ad/"+id.Hex(), bytes.NewBufferString(`{"title": null}`))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id.Hex()}}
+
+	suite.h.PatchAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	assert.Contains(suite.T(), w.Body.String(), "title cannot be null")
+	suite.mockAdService.AssertNotCalled(suite.T(), "UpdateWithQuota", mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_UpdateAdHandler_ActiveLimitReached() {
+	id := primitive.NewObjectID().Hex()
+	now := time.Now().Round(time.Second)
+	// Moving endAt forward reactivates an expired ad
+	existingAd := &models.Advertisement{Title: "Expired Ad", StartAt: now.Add(-48 * time.Hour), EndAt: now.Add(-24 * time.Hour)}
+	ad := &models.Advertisement{
+		Title:      "Expired Ad",
+		StartAt:    now.Add(-48 * time.Hour),
+		EndAt:      now.Add(24 * time.Hour),
+		Conditions: models.Conditions{AgeStart: 18, AgeEnd: 24},
+	}
+
+	suite.mockAdService.On("GetByID", mock.Anything, id).Return(existingAd, nil)
+	suite.mockAdService.On("UpdateWithQuota", mock.Anything, id, mock.Anything, mock.AnythingOfType("time.Time")).Return(service.ErrActiveLimitReached)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+
+	adJson, _ := json.Marshal(ad)
+	c.Request, _ = http.NewRequest(http.MethodPut, "/api/v1/ad/"+id, bytes.NewBuffer(adJson))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id}}
+
+	suite.h.UpdateAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+	suite.mockAdService.AssertNotCalled(suite.T(), "InvalidateAds", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_PatchAdHandler_ActiveLimitReached() {
+	id := primitive.NewObjectID()
+	now := time.Now().Round(time.Second)
+	// Moving startAt back starts a future ad now
+	existingAd := &models.Advertisement{
+		ID:         id,
+		Title:      "Future Ad",
+		StartAt:    now.Add(24 * time.Hour),
+		EndAt:      now.Add(48 * time.Hour),
+		Conditions: models.Conditions{AgeStart: 18, AgeEnd: 24},
+	}
+
+	suite.mockAdService.On("GetByID", mock.Anything, id.Hex()).Return(existingAd, nil)
+	suite.mockAdService.On("UpdateWithQuota", mock.Anything, id.Hex(), mock.MatchedBy(func(ad *models.Advertisement) bool {
+		return ad.StartAt.Before(now)
+	}), mock.AnythingOfType("time.Time")).Return(service.ErrActiveLimitReached)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	body := `{"startAt": "` + now.Add(-time.Hour).Format(time.RFC3339) + `"}`
+	c.Request, _ = http.NewRequest(http.MethodPatch, "/api/v1/ad/"+id.Hex(), bytes.NewBufferString(body))
+	c.Request.Header.Set("Content-Type", "application/json")
+	c.Params = gin.Params{{Key: "id", Value: id.Hex()}}
+
+	suite.h.PatchAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusForbidden, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+	suite.mockAdService.AssertNotCalled(suite.T(), "InvalidateAds", mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_DeleteAdHandler() {
+	id := primitive.NewObjectID().Hex()
+
+	ad := &models.Advertisement{Title: "Test Ad", Conditions: models.Conditions{Country: []string{"TW"}}}
+	suite.mockAdService.On("GetByID", mock.Anything, id).Return(ad, nil)
+	suite.mockAdService.On("Delete", mock.Anything, id).Return(nil)
+	suite.mockAdService.On("InvalidateAds", mock.Anything, ad).Return(nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodDelete, "/api/v1/ad/"+id, nil)
+	c.Params = gin.Params{{Key: "id", Value: id}}
+
+	suite.h.DeleteAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusNoContent, c.Writer.Status())
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_DeleteAdHandler_InvalidID() {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodDelete, "/api/v1/ad/not-an-id", nil)
+	c.Params = gin.Params{{Key: "id", Value: "not-an-id"}}
+
+	suite.h.DeleteAdHandler(c)
+
+	assert.Equal(suite.T(), http.StatusBadRequest, w.Code)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementHandlerSuite) TestAdvertisementHandler_GetQuotaHandler() {
+	expectedUsage := &models.QuotaUsage{
+		AdvertiserID: "acme",
+		Daily:        models.NewQuotaStatus(3000, 10),
+		Active:       models.NewQuotaStatus(1000, 5),
+	}
+
+	suite.mockAdService.On("GetQuota", mock.Anything, "acme", mock.AnythingOfType("time.Time")).Return(expectedUsage, nil)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/api/v1/quota?advertiserId=acme", nil)
+
+	suite.h.GetQuotaHandler(c)
+
+	assert.Equal(suite.T(), http.StatusOK, w.Code)
+
+	var usage models.QuotaUsage
+	err := json.NewDecoder(w.Body).Decode(&usage)
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), *expectedUsage, usage)
+	suite.mockAdService.AssertExpectations(suite.T())
+}
+
 func TestAdvertisementHandlerSuite(t *testing.T) {
 	suite.Run(t, new(AdvertisementHandlerSuite))
 }
@@ -0,0 +1,170 @@
+package repository
+
+import (
+	"ad-service-api/internal/metrics"
+	"ad-service-api/internal/models"
+	"container/list"
+	"context"
+	"path"
+	"sync"
+	"time"
+)
+
+// LRUAdRedisRepository keeps the most recently read ad lists in process memory, in front of another
+// IAdRedisRepository, for a short TTL. The other methods go straight to the wrapped repository.
+// Deleting lists from the shared cache is broadcast on an InvalidationBus, so that every replica
+// drops its local copies at once.
+type LRUAdRedisRepository struct {
+	IAdRedisRepository
+	bus  InvalidationBus
+	size int
+	ttl  time.Duration
+
+	mu sync.Mutex
+	// order holds the *lruEntry values, the most recently used first
+	order   *list.List
+	entries map[string]*list.Element
+}
+
+type lruEntry struct {
+	key       string
+	cached    *models.CachedAds
+	expiresAt time.Time
+}
+
+// NewLRUAdRedisRepository creates a new LRUAdRedisRepository in front of next, keeping up to size
+// lists for ttl each.
+func NewLRUAdRedisRepository(next IAdRedisRepository, bus InvalidationBus, size int, ttl time.Duration) *LRUAdRedisRepository {
+	return &LRUAdRedisRepository{
+		IAdRedisRepository: next,
+		bus:                bus,
+		size:               size,
+		ttl:                ttl,
+		order:              list.New(),
+		entries:            make(map[string]*list.Element),
+	}
+}
+
+// Listen drops the local lists matching the invalidations broadcast by any replica, until ctx is done.
+func (r *LRUAdRedisRepository) Listen(ctx context.Context) error {
+	return r.bus.Subscribe(ctx, r.evict)
+}
+
+// GetAdsByKey retrieves the ad list cached at the specified key from process memory, or from the
+// wrapped repository if it is not there or no longer fresh.
+func (r *LRUAdRedisRepository) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
+	now := time.Now()
+	if cached, ok := r.get(key, now); ok {
+		metrics.LocalCacheLookupsTotal.WithLabelValues(metrics.CacheHit).Inc()
+		return cached, nil
+	}
+	metrics.LocalCacheLookupsTotal.WithLabelValues(metrics.CacheMiss).Inc()
+
+	cached, err := r.IAdRedisRepository.GetAdsByKey(ctx, key)
+	if err != nil || cached == nil {
+		return cached, err
+	}
+	// Stale lists are left to the shared cache, which decides when they are refreshed
+	if cached.Fresh(now) {
+		r.add(key, cached, now)
+	}
+	return cached, nil
+}
+
+// SetAdsByKey caches the ad list at the specified key in the wrapped repository, and drops the
+// local copy so that the new list is read back from it.
+func (r *LRUAdRedisRepository) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	err := r.IAdRedisRepository.SetAdsByKey(ctx, key, cached, expiration)
+	r.mu.Lock()
+	r.remove(key)
+	r.mu.Unlock()
+	return err
+}
+
+// SetAdsByKeyIfGeneration caches the ad list at the specified key in the wrapped repository unless
+// the cached lists were invalidated since generation, and drops the local copy like SetAdsByKey.
+func (r *LRUAdRedisRepository) SetAdsByKeyIfGeneration(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration, generation int64) (bool, error) {
+	set, err := r.IAdRedisRepository.SetAdsByKeyIfGeneration(ctx, key, cached, expiration, generation)
+	r.mu.Lock()
+	r.remove(key)
+	r.mu.Unlock()
+	return set, err
+}
+
+// DeleteAdsByPattern deletes the keys matching the pattern from the wrapped repository, then drops
+// the local copies of all the keys matching the pattern on every replica. match is not broadcast,
+// so the replicas drop every list matching the pattern, which they read back on their next request.
+func (r *LRUAdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string, match func(key string) bool) error {
+	if err := r.IAdRedisRepository.DeleteAdsByPattern(ctx, pattern, match); err != nil {
+		return err
+	}
+
+	// Do not wait for the broadcast to reach this replica
+	r.evict(pattern)
+	return r.bus.Publish(ctx, pattern)
+}
+
+// get returns a copy of the live local list at key, moving it to the front.
+func (r *LRUAdRedisRepository) get(key string, now time.Time) (*models.CachedAds, bool) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	element, ok := r.entries[key]
+	if !ok {
+		return nil, false
+	}
+	entry := element.Value.(*lruEntry)
+	if !now.Before(entry.expiresAt) || !entry.cached.Fresh(now) {
+		r.remove(key)
+		return nil, false
+	}
+	r.order.MoveToFront(element)
+	return copyCachedAds(entry.cached), true
+}
+
+// add stores a copy of the list at key, dropping the least recently used list if there are too many.
+func (r *LRUAdRedisRepository) add(key string, cached *models.CachedAds, now time.Time) {
+	entry := &lruEntry{key: key, cached: copyCachedAds(cached), expiresAt: now.Add(r.ttl)}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if element, ok := r.entries[key]; ok {
+		element.Value = entry
+		r.order.MoveToFront(element)
+		return
+	}
+	r.entries[key] = r.order.PushFront(entry)
+	if r.order.Len() > r.size {
+		r.remove(r.order.Back().Value.(*lruEntry).key)
+	}
+}
+
+// evict drops the local lists whose key matches the glob-style pattern.
+func (r *LRUAdRedisRepository) evict(pattern string) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for key := range r.entries {
+		// An invalid pattern drops everything, which is always safe
+		if matched, err := path.Match(pattern, key); matched || err != nil {
+			r.remove(key)
+		}
+	}
+}
+
+// remove drops the local list at key. The caller must hold mu.
+func (r *LRUAdRedisRepository) remove(key string) {
+	if element, ok := r.entries[key]; ok {
+		r.order.Remove(element)
+		delete(r.entries, key)
+	}
+}
+
+// copyCachedAds returns a copy of the list that shares no slice with the original, so callers
+// cannot reorder the local copy.
+func copyCachedAds(cached *models.CachedAds) *models.CachedAds {
+	c := *cached
+	c.Ads = append([]*models.Advertisement(nil), cached.Ads...)
+	return &c
+}
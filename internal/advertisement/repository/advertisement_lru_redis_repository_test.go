@@ -0,0 +1,152 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/go-redis/redismock/v8"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+
+	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/internal/models"
+)
+
+func cachedList(title string) *models.CachedAds {
+	return &models.CachedAds{Ads: []*models.Advertisement{{Title: title}}, FreshUntil: time.Now().Add(time.Hour)}
+}
+
+func TestLRUAdRedisRepository_GetAdsByKey(t *testing.T) {
+	shared := repository.NewMemoryAdRedisRepository()
+	repo := repository.NewLRUAdRedisRepository(shared, repository.NewMemoryInvalidationBus(), 10, 50*time.Millisecond)
+	ctx := context.Background()
+
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:limit:5", cachedList("first"), 0))
+	cached, err := repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "first", cached.Ads[0].Title)
+
+	// The local copy is served until it expires, without reading the shared cache
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:limit:5", cachedList("second"), 0))
+	cached, err = repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "first", cached.Ads[0].Title)
+
+	time.Sleep(100 * time.Millisecond)
+
+	cached, err = repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "second", cached.Ads[0].Title)
+
+	cached, err = repo.GetAdsByKey(ctx, "ads:missing")
+	require.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+func TestLRUAdRedisRepository_SetAdsByKey(t *testing.T) {
+	shared := repository.NewMemoryAdRedisRepository()
+	repo := repository.NewLRUAdRedisRepository(shared, repository.NewMemoryInvalidationBus(), 10, time.Minute)
+	ctx := context.Background()
+
+	require.NoError(t, repo.SetAdsByKey(ctx, "ads:limit:5", cachedList("first"), 0))
+	_, err := repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+
+	require.NoError(t, repo.SetAdsByKey(ctx, "ads:limit:5", cachedList("second"), 0))
+	cached, err := repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "second", cached.Ads[0].Title, "expected the local copy to be replaced")
+}
+
+func TestLRUAdRedisRepository_Size(t *testing.T) {
+	shared := repository.NewMemoryAdRedisRepository()
+	repo := repository.NewLRUAdRedisRepository(shared, repository.NewMemoryInvalidationBus(), 2, time.Minute)
+	ctx := context.Background()
+
+	for _, key := range []string{"ads:a", "ads:b", "ads:a", "ads:c"} {
+		require.NoError(t, shared.SetAdsByKey(ctx, key, cachedList(key), 0))
+		_, err := repo.GetAdsByKey(ctx, key)
+		require.NoError(t, err)
+	}
+	for _, key := range []string{"ads:a", "ads:b", "ads:c"} {
+		require.NoError(t, shared.SetAdsByKey(ctx, key, cachedList("changed"), 0))
+	}
+
+	// ads:b was the least recently used when ads:c was added
+	for _, want := range []struct{ key, title string }{
+		{"ads:a", "ads:a"},
+		{"ads:c", "ads:c"},
+		{"ads:b", "changed"},
+	} {
+		cached, err := repo.GetAdsByKey(ctx, want.key)
+		require.NoError(t, err)
+		assert.Equal(t, want.title, cached.Ads[0].Title, want.key)
+	}
+}
+
+func TestLRUAdRedisRepository_StaleNotKept(t *testing.T) {
+	shared := repository.NewMemoryAdRedisRepository()
+	repo := repository.NewLRUAdRedisRepository(shared, repository.NewMemoryInvalidationBus(), 10, time.Minute)
+	ctx := context.Background()
+
+	stale := &models.CachedAds{Ads: []*models.Advertisement{{Title: "stale"}}, FreshUntil: time.Now().Add(-time.Minute)}
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:limit:5", stale, 0))
+	cached, err := repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "stale", cached.Ads[0].Title)
+
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:limit:5", cachedList("fresh"), 0))
+	cached, err = repo.GetAdsByKey(ctx, "ads:limit:5")
+	require.NoError(t, err)
+	assert.Equal(t, "fresh", cached.Ads[0].Title, "expected stale lists to be read from the shared cache")
+}
+
+func TestLRUAdRedisRepository_InvalidatesAllReplicas(t *testing.T) {
+	shared := repository.NewMemoryAdRedisRepository()
+	bus := repository.NewMemoryInvalidationBus()
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	replicas := []*repository.LRUAdRedisRepository{
+		repository.NewLRUAdRedisRepository(shared, bus, 10, time.Minute),
+		repository.NewLRUAdRedisRepository(shared, bus, 10, time.Minute),
+	}
+	for _, replica := range replicas {
+		require.NoError(t, replica.Listen(ctx))
+	}
+
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:country:TW", cachedList("TW"), 0))
+	require.NoError(t, shared.SetAdsByKey(ctx, "ads:country:JP", cachedList("JP"), 0))
+	for _, replica := range replicas {
+		for _, key := range []string{"ads:country:TW", "ads:country:JP"} {
+			_, err := replica.GetAdsByKey(ctx, key)
+			require.NoError(t, err)
+		}
+	}
+
+	// A mutation on the first replica clears the TW list
+	err := replicas[0].DeleteAdsByPattern(ctx, "ads:*", func(key string) bool { return key == "ads:country:TW" })
+	require.NoError(t, err)
+
+	for i, replica := range replicas {
+		cached, err := replica.GetAdsByKey(ctx, "ads:country:TW")
+		require.NoError(t, err)
+		assert.Nil(t, cached, "expected replica %d to drop the deleted list", i)
+
+		cached, err = replica.GetAdsByKey(ctx, "ads:country:JP")
+		require.NoError(t, err)
+		assert.Equal(t, "JP", cached.Ads[0].Title, "expected replica %d to read the kept list back", i)
+	}
+}
+
+func TestRedisInvalidationBus_Publish(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	bus := repository.NewRedisInvalidationBus(db)
+
+	mock.ExpectPublish(repository.InvalidationChannel, "ads:*").SetVal(2)
+
+	err := bus.Publish(context.Background(), "ads:*")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
@@ -0,0 +1,219 @@
+package repository
+
+import (
+	"ad-service-api/internal/models"
+	"context"
+	"encoding/json"
+	"fmt"
+	"path"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// memoryEntry is a cached value with an optional expiry; a zero expiresAt never expires.
+type memoryEntry struct {
+	value     []byte
+	expiresAt time.Time
+}
+
+func (e memoryEntry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// MemoryAdRedisRepository implements the IAdRedisRepository interface in process memory.
+// Values are stored the same way as in Redis, so both implementations behave alike.
+type MemoryAdRedisRepository struct {
+	mu      sync.Mutex
+	entries map[string]memoryEntry
+	// generation counts the invalidations, like CacheGenerationKey in Redis
+	generation int64
+}
+
+// NewMemoryAdRedisRepository creates a new, empty MemoryAdRedisRepository.
+func NewMemoryAdRedisRepository() *MemoryAdRedisRepository {
+	return &MemoryAdRedisRepository{
+		entries: make(map[string]memoryEntry),
+	}
+}
+
+// get returns the live entry for key, dropping it if it has expired. The caller must hold mu.
+func (r *MemoryAdRedisRepository) get(key string, now time.Time) (memoryEntry, bool) {
+	entry, ok := r.entries[key]
+	if !ok {
+		return memoryEntry{}, false
+	}
+	if entry.expired(now) {
+		delete(r.entries, key)
+		return memoryEntry{}, false
+	}
+	return entry, true
+}
+
+// IncrByDate increments the count associated with the specified date key.
+func (r *MemoryAdRedisRepository) IncrByDate(ctx context.Context, key string) error {
+	if err := r.incrBy(key, 1); err != nil {
+		return fmt.Errorf("failed to increment count for key %s: %w", key, err)
+	}
+	return nil
+}
+
+// DecrByDate decrements the count associated with the specified date key.
+func (r *MemoryAdRedisRepository) DecrByDate(ctx context.Context, key string) error {
+	if err := r.incrBy(key, -1); err != nil {
+		return fmt.Errorf("failed to decrement count for key %s: %w", key, err)
+	}
+	return nil
+}
+
+// incrBy adds delta to the integer stored at key, treating a missing key as 0.
+func (r *MemoryAdRedisRepository) incrBy(key string, delta int) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	count := 0
+	entry, ok := r.get(key, time.Now())
+	if ok {
+		var err error
+		count, err = strconv.Atoi(string(entry.value))
+		if err != nil {
+			return err
+		}
+	}
+
+	entry.value = []byte(strconv.Itoa(count + delta))
+	r.entries[key] = entry
+	return nil
+}
+
+// GetByDate retrieves the count associated with the specified date key.
+func (r *MemoryAdRedisRepository) GetByDate(ctx context.Context, key string) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	entry, ok := r.get(key, time.Now())
+	if !ok {
+		// Key does not exist, return count as 0
+		return 0, nil
+	}
+
+	count, err := strconv.Atoi(string(entry.value))
+	if err != nil {
+		return 0, fmt.Errorf("failed to convert count to integer for key %s: %w", key, err)
+	}
+
+	return count, nil
+}
+
+// GetAdsByKey retrieves the ad list cached at the specified key.
+func (r *MemoryAdRedisRepository) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
+	r.mu.Lock()
+	entry, ok := r.get(key, time.Now())
+	r.mu.Unlock()
+	if !ok {
+		// Key does not exist, return nil
+		return nil, nil
+	}
+
+	var cached models.CachedAds
+	if err := json.Unmarshal(entry.value, &cached); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal ads data: %w", err)
+	}
+
+	return &cached, nil
+}
+
+// SetAdsByKey caches the ad list at the specified key; an expiration of 0 never expires.
+func (r *MemoryAdRedisRepository) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	_, err := r.setAds(key, cached, expiration, nil)
+	return err
+}
+
+// SetAdsByKeyIfGeneration caches the ad list at the specified key, unless the cached lists were
+// invalidated since CacheGeneration returned generation.
+func (r *MemoryAdRedisRepository) SetAdsByKeyIfGeneration(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration, generation int64) (bool, error) {
+	return r.setAds(key, cached, expiration, &generation)
+}
+
+// setAds caches the ad list at key if generation is nil or still the current generation.
+func (r *MemoryAdRedisRepository) setAds(key string, cached *models.CachedAds, expiration time.Duration, generation *int64) (bool, error) {
+	adsData, err := json.Marshal(cached)
+	if err != nil {
+		return false, fmt.Errorf("failed to marshal ads data: %w", err)
+	}
+
+	now := time.Now()
+	entry := memoryEntry{value: adsData}
+	if expiration > 0 {
+		entry.expiresAt = now.Add(expiration)
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if generation != nil && *generation != r.generation {
+		return false, nil
+	}
+	// Drop expired entries so the map does not grow without bound
+	for k, e := range r.entries {
+		if e.expired(now) {
+			delete(r.entries, k)
+		}
+	}
+	r.entries[key] = entry
+
+	return true, nil
+}
+
+// CacheGeneration returns the number of times the cached ad lists were invalidated.
+func (r *MemoryAdRedisRepository) CacheGeneration(ctx context.Context) (int64, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	return r.generation, nil
+}
+
+// DeleteAdsByPattern increments the cache generation, then deletes the keys matching the glob-style
+// pattern for which match returns true, or all of them if match is nil.
+func (r *MemoryAdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string, match func(key string) bool) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	r.generation++
+
+	for key := range r.entries {
+		matched, err := path.Match(pattern, key)
+		if err != nil {
+			return fmt.Errorf("failed to get keys for pattern %s: %w", pattern, err)
+		}
+		if matched && (match == nil || match(key)) {
+			delete(r.entries, key)
+		}
+	}
+
+	return nil
+}
+
+// AcquireLock sets the lock key to token if it is not already held, expiring after ttl.
+func (r *MemoryAdRedisRepository) AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	now := time.Now()
+	if _, ok := r.get(key, now); ok {
+		return false, nil
+	}
+	r.entries[key] = memoryEntry{value: []byte(token), expiresAt: now.Add(ttl)}
+	return true, nil
+}
+
+// ReleaseLock deletes the lock key if it is still held by token.
+func (r *MemoryAdRedisRepository) ReleaseLock(ctx context.Context, key string, token string) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if entry, ok := r.get(key, time.Now()); ok && string(entry.value) == token {
+		delete(r.entries, key)
+	}
+	return nil
+}
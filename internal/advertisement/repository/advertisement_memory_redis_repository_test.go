@@ -0,0 +1,173 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/internal/models"
+)
+
+func TestMemoryAdRedisRepository_IncrByDate(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	count, err := repo.GetByDate(ctx, "testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, 0, count)
+
+	assert.NoError(t, repo.IncrByDate(ctx, "testKey"))
+	assert.NoError(t, repo.IncrByDate(ctx, "testKey"))
+
+	count, err = repo.GetByDate(ctx, "testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, 2, count)
+
+	assert.NoError(t, repo.DecrByDate(ctx, "testKey"))
+
+	count, err = repo.GetByDate(ctx, "testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count)
+}
+
+func TestMemoryAdRedisRepository_SetAdsByKey(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	cached := &models.CachedAds{
+		Ads:        []*models.Advertisement{{Title: "test1"}},
+		FreshUntil: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+
+	returnedAds, err := repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds)
+
+	err = repo.SetAdsByKey(ctx, "ads:testKey", cached, 0)
+	assert.NoError(t, err)
+
+	returnedAds, err = repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, cached, returnedAds)
+}
+
+func TestMemoryAdRedisRepository_SetAdsByKeyIfGeneration(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "test1"}}}
+
+	generation, err := repo.CacheGeneration(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	// Deleting lists invalidates the lists fetched before, even when no key matches
+	assert.NoError(t, repo.DeleteAdsByPattern(ctx, "ads:country:*", nil))
+
+	set, err := repo.SetAdsByKeyIfGeneration(ctx, "ads:testKey", cached, time.Minute, generation)
+	assert.NoError(t, err)
+	assert.False(t, set)
+	returnedAds, err := repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds)
+
+	generation, err = repo.CacheGeneration(ctx)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(1), generation)
+
+	set, err = repo.SetAdsByKeyIfGeneration(ctx, "ads:testKey", cached, time.Minute, generation)
+	assert.NoError(t, err)
+	assert.True(t, set)
+	returnedAds, err = repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Equal(t, cached, returnedAds)
+}
+
+func TestMemoryAdRedisRepository_SetAdsByKeyExpiration(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	err := repo.SetAdsByKey(ctx, "ads:testKey", &models.CachedAds{Ads: []*models.Advertisement{{Title: "test1"}}}, 50*time.Millisecond)
+	assert.NoError(t, err)
+
+	returnedAds, err := repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Len(t, returnedAds.Ads, 1)
+
+	time.Sleep(100 * time.Millisecond)
+
+	returnedAds, err = repo.GetAdsByKey(ctx, "ads:testKey")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds, "expected the key to expire")
+}
+
+func TestMemoryAdRedisRepository_DeleteAdsByPattern(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "test1"}}}
+	assert.NoError(t, repo.SetAdsByKey(ctx, "ads:country:TW", cached, 0))
+	assert.NoError(t, repo.SetAdsByKey(ctx, "ads:country:JP", cached, 0))
+	assert.NoError(t, repo.IncrByDate(ctx, "2024-01-01"))
+
+	err := repo.DeleteAdsByPattern(ctx, "ads:*", nil)
+	assert.NoError(t, err)
+
+	returnedAds, err := repo.GetAdsByKey(ctx, "ads:country:TW")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds)
+
+	returnedAds, err = repo.GetAdsByKey(ctx, "ads:country:JP")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds)
+
+	count, err := repo.GetByDate(ctx, "2024-01-01")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "expected keys outside the pattern to be kept")
+}
+
+func TestMemoryAdRedisRepository_DeleteAdsByPattern_Match(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	cached := &models.CachedAds{Ads: []*models.Advertisement{{Title: "test1"}}}
+	assert.NoError(t, repo.SetAdsByKey(ctx, "ads:country:TW", cached, 0))
+	assert.NoError(t, repo.SetAdsByKey(ctx, "ads:country:JP", cached, 0))
+
+	err := repo.DeleteAdsByPattern(ctx, "ads:*", func(key string) bool { return key == "ads:country:TW" })
+	assert.NoError(t, err)
+
+	returnedAds, err := repo.GetAdsByKey(ctx, "ads:country:TW")
+	assert.NoError(t, err)
+	assert.Nil(t, returnedAds)
+
+	returnedAds, err = repo.GetAdsByKey(ctx, "ads:country:JP")
+	assert.NoError(t, err)
+	assert.NotNil(t, returnedAds, "expected keys rejected by match to be kept")
+}
+
+func TestMemoryAdRedisRepository_Lock(t *testing.T) {
+	repo := repository.NewMemoryAdRedisRepository()
+	ctx := context.Background()
+
+	acquired, err := repo.AcquireLock(ctx, "lockKey", "token", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = repo.AcquireLock(ctx, "lockKey", "other", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired, "expected the lock to be held")
+
+	// Releasing with the wrong token must not free the lock
+	assert.NoError(t, repo.ReleaseLock(ctx, "lockKey", "other"))
+	acquired, err = repo.AcquireLock(ctx, "lockKey", "other", time.Minute)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+
+	assert.NoError(t, repo.ReleaseLock(ctx, "lockKey", "token"))
+	acquired, err = repo.AcquireLock(ctx, "lockKey", "other", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+}
@@ -0,0 +1,248 @@
+package repository
+
+import (
+	"ad-service-api/internal/models"
+	"context"
+	"slices"
+	"sync"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// MemoryAdvertisementRepository implements the IAdvertisementRepository interface in process memory.
+type MemoryAdvertisementRepository struct {
+	mu  sync.RWMutex
+	ads map[primitive.ObjectID]*models.Advertisement
+}
+
+// NewMemoryAdvertisementRepository creates a new, empty MemoryAdvertisementRepository.
+func NewMemoryAdvertisementRepository() IAdvertisementRepository {
+	return &MemoryAdvertisementRepository{
+		ads: make(map[primitive.ObjectID]*models.Advertisement),
+	}
+}
+
+// Create stores a copy of the advertisement and assigns it a new id.
+func (r *MemoryAdvertisementRepository) Create(ctx context.Context, ad *models.Advertisement) error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	ad.ID = primitive.NewObjectID()
+	r.ads[ad.ID] = copyAd(ad)
+	return nil
+}
+
+// CountActive returns the count of active advertisements based on the provided timestamp; a
+// scheduled ad is only active while one of its windows is open.
+func (r *MemoryAdvertisementRepository) CountActive(ctx context.Context, now time.Time) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, ad := range r.ads {
+		if isActive(ad, now) && onSchedule(ad, now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountActiveByAdvertiser returns the count of the advertiser's active advertisements; an empty
+// advertiserID counts the advertisements that belong to no advertiser. Like CountActive, a scheduled
+// ad is only active while one of its windows is open.
+func (r *MemoryAdvertisementRepository) CountActiveByAdvertiser(ctx context.Context, now time.Time, advertiserID string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, ad := range r.ads {
+		if ad.AdvertiserID == advertiserID && isActive(ad, now) && onSchedule(ad, now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountActiveExcludingAdvertisers returns the count of the active advertisements that belong to no
+// advertiser or to one not in advertiserIDs, honouring schedules like CountActiveByAdvertiser.
+func (r *MemoryAdvertisementRepository) CountActiveExcludingAdvertisers(ctx context.Context, now time.Time, advertiserIDs []string) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	count := 0
+	for _, ad := range r.ads {
+		if !slices.Contains(advertiserIDs, ad.AdvertiserID) && isActive(ad, now) && onSchedule(ad, now) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// CountCreatedSince returns the count of advertisements created at or after since.
+func (r *MemoryAdvertisementRepository) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	// ObjectIDs only keep whole seconds, like in MongoDB
+	since = since.Truncate(time.Second)
+	count := 0
+	for id := range r.ads {
+		if !id.Timestamp().Before(since) {
+			count++
+		}
+	}
+	return count, nil
+}
+
+// Fetch retrieves the advertisements matching the query, ranked by priority and score like the
+// MongoDB repository, applying limit and offset.
+func (r *MemoryAdvertisementRepository) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	r.mu.RLock()
+	var ads []*models.Advertisement
+	for _, ad := range r.ads {
+		if matchesQuery(ad, query) {
+			ads = append(ads, copyAd(ad))
+		}
+	}
+	r.mu.RUnlock()
+
+	slices.SortFunc(ads, models.CompareRank)
+
+	if offset >= len(ads) {
+		return nil, nil
+	}
+	ads = ads[offset:]
+	if limit > 0 && limit < len(ads) {
+		ads = ads[:limit]
+	}
+
+	return ads, nil
+}
+
+// NextChange returns the earliest instant after query.Now at which the result of Fetch for the
+// query changes, or the zero time if none will. Like in MongoDB, an ad drops out one millisecond
+// after its endAt, and its schedule's windows open and close in between.
+func (r *MemoryAdvertisementRepository) NextChange(ctx context.Context, query models.AdQuery) (time.Time, error) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	var next time.Time
+	for _, ad := range r.ads {
+		if ad.EndAt.Before(query.Now) || !query.MatchesConditions(ad.Conditions) || !isAfter(ad, query.After) {
+			continue
+		}
+		change := ad.EndAt.Add(time.Millisecond)
+		if ad.StartAt.After(query.Now) {
+			change = ad.StartAt
+		}
+		if ad.Schedule != nil {
+			if window := ad.Schedule.NextChange(query.Now); !window.IsZero() && window.Before(change) {
+				change = window
+			}
+		}
+		if next.IsZero() || change.Before(next) {
+			next = change
+		}
+	}
+	return next, nil
+}
+
+// GetByID retrieves a single advertisement by its id.
+func (r *MemoryAdvertisementRepository) GetByID(ctx context.Context, id string) (*models.Advertisement, error) {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrAdNotFound
+	}
+
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	ad, ok := r.ads[objectID]
+	if !ok {
+		return nil, ErrAdNotFound
+	}
+	return copyAd(ad), nil
+}
+
+// Update replaces the advertisement with the given id.
+func (r *MemoryAdvertisementRepository) Update(ctx context.Context, id string, ad *models.Advertisement) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAdNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ads[objectID]; !ok {
+		return ErrAdNotFound
+	}
+	ad.ID = objectID
+	r.ads[objectID] = copyAd(ad)
+	return nil
+}
+
+// Delete removes the advertisement with the given id.
+func (r *MemoryAdvertisementRepository) Delete(ctx context.Context, id string) error {
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAdNotFound
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.ads[objectID]; !ok {
+		return ErrAdNotFound
+	}
+	delete(r.ads, objectID)
+	return nil
+}
+
+// isActive reports whether the advertisement runs at the given time.
+func isActive(ad *models.Advertisement, now time.Time) bool {
+	return !ad.StartAt.After(now) && !ad.EndAt.Before(now)
+}
+
+// onSchedule reports whether the advertisement has no schedule or one of its windows is open at now.
+func onSchedule(ad *models.Advertisement, now time.Time) bool {
+	return ad.Schedule == nil || ad.Schedule.ActiveAt(now)
+}
+
+// matchesQuery mirrors database.CreateFilter: the ad must be active and on schedule, match the
+// query's conditions and be sorted after its cursor.
+func matchesQuery(ad *models.Advertisement, query models.AdQuery) bool {
+	return isActive(ad, query.Now) && onSchedule(ad, query.Now) && query.MatchesConditions(ad.Conditions) && isAfter(ad, query.After)
+}
+
+// isAfter reports whether ad is sorted after cursor, which is always the case without a cursor.
+func isAfter(ad *models.Advertisement, cursor *models.Cursor) bool {
+	return cursor == nil || cursor.Precedes(ad)
+}
+
+// copyAd returns a copy of the advertisement that shares no slices with the original.
+func copyAd(ad *models.Advertisement) *models.Advertisement {
+	c := *ad
+	c.Conditions.Gender = append([]string(nil), ad.Conditions.Gender...)
+	c.Conditions.Country = append([]string(nil), ad.Conditions.Country...)
+	c.Conditions.Platform = append([]string(nil), ad.Conditions.Platform...)
+	c.Conditions.ExcludeCountry = append([]string(nil), ad.Conditions.ExcludeCountry...)
+	c.Conditions.ExcludePlatform = append([]string(nil), ad.Conditions.ExcludePlatform...)
+	c.Conditions.Language = append([]string(nil), ad.Conditions.Language...)
+	c.Conditions.Region = append([]string(nil), ad.Conditions.Region...)
+	if ad.Conditions.OSVersion != nil {
+		osVersion := *ad.Conditions.OSVersion
+		c.Conditions.OSVersion = &osVersion
+	}
+	if ad.Conditions.AppVersion != nil {
+		appVersion := *ad.Conditions.AppVersion
+		c.Conditions.AppVersion = &appVersion
+	}
+	if ad.Schedule != nil {
+		schedule := *ad.Schedule
+		schedule.Windows = append([]models.Window(nil), ad.Schedule.Windows...)
+		c.Schedule = &schedule
+	}
+	return &c
+}
@@ -0,0 +1,348 @@
+package repository_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestMemoryAdvertisementRepository_CRUD(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	ad := &models.Advertisement{Title: "Test Ad"}
+
+	err := repo.Create(ctx, ad)
+	assert.Nil(t, err)
+	assert.False(t, ad.ID.IsZero(), "expected Create to assign an id")
+
+	found, err := repo.GetByID(ctx, ad.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, ad, found)
+
+	err = repo.Update(ctx, ad.ID.Hex(), &models.Advertisement{Title: "Updated Ad"})
+	assert.Nil(t, err)
+
+	found, err = repo.GetByID(ctx, ad.ID.Hex())
+	assert.Nil(t, err)
+	assert.Equal(t, "Updated Ad", found.Title)
+
+	err = repo.Delete(ctx, ad.ID.Hex())
+	assert.Nil(t, err)
+
+	_, err = repo.GetByID(ctx, ad.ID.Hex())
+	assert.ErrorIs(t, err, repository.ErrAdNotFound)
+	assert.ErrorIs(t, repo.Update(ctx, ad.ID.Hex(), &models.Advertisement{}), repository.ErrAdNotFound)
+	assert.ErrorIs(t, repo.Delete(ctx, primitive.NewObjectID().Hex()), repository.ErrAdNotFound)
+}
+
+func TestMemoryAdvertisementRepository_CountActive(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	repo.Create(ctx, &models.Advertisement{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)})
+	repo.Create(ctx, &models.Advertisement{StartAt: now.Add(time.Hour), EndAt: now.Add(2 * time.Hour)})
+	repo.Create(ctx, &models.Advertisement{StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)})
+	repo.Create(ctx, &models.Advertisement{AdvertiserID: "acme", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)})
+
+	count, err := repo.CountActive(ctx, now)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count, "expected count of active advertisements to be correct")
+
+	count, err = repo.CountActiveByAdvertiser(ctx, now, "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count, "expected count of the advertiser's active advertisements to be correct")
+
+	count, err = repo.CountActiveByAdvertiser(ctx, now, "")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count, "expected count of active advertisements without advertiser to be correct")
+
+	count, err = repo.CountActiveExcludingAdvertisers(ctx, now, []string{"acme"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count, "expected count of active advertisements of the shared quota to be correct")
+
+	count, err = repo.CountActiveExcludingAdvertisers(ctx, now, nil)
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count, "expected count of active advertisements of the shared quota to be correct")
+}
+
+func TestMemoryAdvertisementRepository_CountCreatedSince(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+
+	repo.Create(ctx, &models.Advertisement{Title: "first"})
+	repo.Create(ctx, &models.Advertisement{Title: "second"})
+
+	count, err := repo.CountCreatedSince(ctx, time.Now().Add(-time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 2, count, "expected count of advertisements created since the given time to be correct")
+
+	count, err = repo.CountCreatedSince(ctx, time.Now().Add(time.Minute))
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count, "expected no advertisements created in the future")
+}
+
+func TestMemoryAdvertisementRepository_Fetch(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	ads := []*models.Advertisement{
+		{Title: "untargeted", StartAt: now.Add(-time.Hour), EndAt: now.Add(4 * time.Hour)},
+		{Title: "targeted", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			AgeStart: 20, AgeEnd: 30, Gender: []string{"F"}, Country: []string{"TW", "JP"}, Platform: []string{"ios"},
+		}},
+		{Title: "age only", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour), Conditions: models.Conditions{
+			AgeStart: 40,
+		}},
+		{Title: "expired", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)},
+		{Title: "worldwide but CN", StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour), Conditions: models.Conditions{
+			ExcludeCountry: []string{"CN", "RU"}, ExcludePlatform: []string{"web"},
+		}},
+	}
+	for _, ad := range ads {
+		assert.Nil(t, repo.Create(ctx, ad))
+	}
+
+	tests := []struct {
+		name   string
+		query  models.AdQuery
+		limit  int
+		offset int
+		want   []string
+	}{
+		{"no conditions", models.AdQuery{}, 10, 0, []string{"targeted", "age only", "worldwide but CN", "untargeted"}},
+		{"gender mismatch", models.AdQuery{Gender: []string{"M"}}, 10, 0, []string{"age only", "worldwide but CN", "untargeted"}},
+		{"country match", models.AdQuery{Country: []string{"JP"}}, 10, 0, []string{"targeted", "age only", "worldwide but CN", "untargeted"}},
+		{"platform mismatch or excluded", models.AdQuery{Platform: []string{"web"}}, 10, 0, []string{"age only", "untargeted"}},
+		{"age inside range", models.AdQuery{Age: 25}, 10, 0, []string{"targeted", "worldwide but CN", "untargeted"}},
+		{"age above open range", models.AdQuery{Age: 50}, 10, 0, []string{"age only", "worldwide but CN", "untargeted"}},
+		{"any of several countries", models.AdQuery{Country: []string{"US", "JP"}}, 10, 0, []string{"targeted", "age only", "worldwide but CN", "untargeted"}},
+		{"any of several mismatching countries", models.AdQuery{Country: []string{"US", "KR"}}, 10, 0, []string{"age only", "worldwide but CN", "untargeted"}},
+		{"excluded platform", models.AdQuery{NotPlatform: []string{"ios"}}, 10, 0, []string{"age only", "worldwide but CN", "untargeted"}},
+		{"excluded country, other targeted", models.AdQuery{NotCountry: []string{"TW"}}, 10, 0, []string{"targeted", "age only", "worldwide but CN", "untargeted"}},
+		{"limit and offset", models.AdQuery{}, 1, 1, []string{"age only"}},
+		{"excluded country", models.AdQuery{Country: []string{"CN"}}, 10, 0, []string{"age only", "untargeted"}},
+		{"any country not excluded", models.AdQuery{Country: []string{"CN", "US"}}, 10, 0, []string{"age only", "worldwide but CN", "untargeted"}},
+		{"offset past end", models.AdQuery{}, 10, 5, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			result, err := repo.Fetch(ctx, tt.query, tt.limit, tt.offset)
+			assert.Nil(t, err)
+
+			titles := make([]string, 0, len(result))
+			for _, ad := range result {
+				titles = append(titles, ad.Title)
+			}
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestMemoryAdvertisementRepository_FetchExtendedTargeting(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	ads := []*models.Advertisement{
+		{Title: "untargeted", StartAt: now.Add(-time.Hour), EndAt: now.Add(5 * time.Hour)},
+		{Title: "chinese", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			Language: []string{"zh"},
+		}},
+		{Title: "taipei", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour), Conditions: models.Conditions{
+			Country: []string{"TW"}, Region: []string{"TW-TPE"},
+		}},
+		{Title: "ios 17", StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour), Conditions: models.Conditions{
+			OSVersion: &models.VersionRange{Min: "17"},
+		}},
+		{Title: "app 2.x", StartAt: now.Add(-time.Hour), EndAt: now.Add(4 * time.Hour), Conditions: models.Conditions{
+			AppVersion: &models.VersionRange{Min: "2", Max: "2.99999.99999"},
+		}},
+	}
+	for _, ad := range ads {
+		assert.Nil(t, repo.Create(ctx, ad))
+	}
+
+	v := func(s string) *models.Version {
+		version, err := models.ParseVersion(s)
+		assert.Nil(t, err)
+		return &version
+	}
+
+	tests := []struct {
+		name  string
+		query models.AdQuery
+		want  []string
+	}{
+		{"more specific language", models.AdQuery{Language: []string{"zh-Hant-TW"}}, []string{"chinese", "taipei", "ios 17", "app 2.x", "untargeted"}},
+		{"other language", models.AdQuery{Language: []string{"en"}}, []string{"taipei", "ios 17", "app 2.x", "untargeted"}},
+		{"region match", models.AdQuery{Region: []string{"TW-TPE"}}, []string{"chinese", "taipei", "ios 17", "app 2.x", "untargeted"}},
+		{"region mismatch", models.AdQuery{Region: []string{"TW-KHH"}}, []string{"chinese", "ios 17", "app 2.x", "untargeted"}},
+		{"region excluded", models.AdQuery{NotRegion: []string{"TW-TPE"}}, []string{"chinese", "ios 17", "app 2.x", "untargeted"}},
+		{"os version below range", models.AdQuery{OSVersion: v("16.7.2")}, []string{"chinese", "taipei", "app 2.x", "untargeted"}},
+		{"os version in range", models.AdQuery{OSVersion: v("17.0.1")}, []string{"chinese", "taipei", "ios 17", "app 2.x", "untargeted"}},
+		{"app version above range", models.AdQuery{AppVersion: v("10.0")}, []string{"chinese", "taipei", "ios 17", "untargeted"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			result, err := repo.Fetch(ctx, tt.query, 10, 0)
+			assert.Nil(t, err)
+
+			titles := make([]string, 0, len(result))
+			for _, ad := range result {
+				titles = append(titles, ad.Title)
+			}
+			assert.Equal(t, tt.want, titles)
+		})
+	}
+}
+
+func TestMemoryAdvertisementRepository_FetchCursor(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	// Two ads end at the same time, so the id decides their order
+	for _, ad := range []*models.Advertisement{
+		{Title: "first", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)},
+		{Title: "second", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+		{Title: "third", StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+		{Title: "fourth", StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour)},
+	} {
+		assert.Nil(t, repo.Create(ctx, ad))
+	}
+
+	page, err := repo.Fetch(ctx, models.AdQuery{Now: now}, 2, 0)
+	assert.Nil(t, err)
+	assert.Equal(t, "second", page[1].Title)
+
+	// An ad created before the cursor does not shift the next page
+	assert.Nil(t, repo.Create(ctx, &models.Advertisement{Title: "new", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * time.Minute)}))
+
+	cursor := models.CursorAfter(page[1])
+	page, err = repo.Fetch(ctx, models.AdQuery{Now: now, After: &cursor}, 2, 0)
+	assert.Nil(t, err)
+	titles := make([]string, 0, len(page))
+	for _, ad := range page {
+		titles = append(titles, ad.Title)
+	}
+	assert.Equal(t, []string{"third", "fourth"}, titles)
+
+	cursor = models.CursorAfter(page[1])
+	page, err = repo.Fetch(ctx, models.AdQuery{Now: now, After: &cursor}, 2, 0)
+	assert.Nil(t, err)
+	assert.Empty(t, page)
+}
+
+func TestMemoryAdvertisementRepository_NextChange(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	ads := []*models.Advertisement{
+		{Title: "running", StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour)},
+		{Title: "scheduled", StartAt: now.Add(2 * time.Hour), EndAt: now.Add(4 * time.Hour)},
+		{Title: "ending soon in JP", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{
+			Country: []string{"JP"},
+		}},
+		{Title: "expired", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)},
+	}
+	for _, ad := range ads {
+		assert.Nil(t, repo.Create(ctx, ad))
+	}
+
+	tests := []struct {
+		name  string
+		query models.AdQuery
+		want  time.Time
+	}{
+		{"end of a running ad", models.AdQuery{Country: []string{"JP"}}, now.Add(time.Hour + time.Millisecond)},
+		{"start of a scheduled ad", models.AdQuery{Country: []string{"TW"}}, now.Add(2 * time.Hour)},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tt.query.Now = now
+			next, err := repo.NextChange(ctx, tt.query)
+			assert.Nil(t, err)
+			assert.True(t, tt.want.Equal(next), "expected %s, got %s", tt.want, next)
+		})
+	}
+
+	next, err := repo.NextChange(ctx, models.AdQuery{Now: now.Add(5 * time.Hour)})
+	assert.Nil(t, err)
+	assert.True(t, next.IsZero(), "expected no change after every ad has ended")
+}
+
+func TestMemoryAdvertisementRepository_Schedule(t *testing.T) {
+	repo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	// Friday 10:00 in Taipei
+	now := time.Date(2026, time.October, 16, 2, 0, 0, 0, time.UTC)
+
+	ads := []*models.Advertisement{
+		{Title: "always", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour)},
+		{Title: "office hours", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour), Schedule: &models.Schedule{
+			Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"}},
+		}},
+		{Title: "weekends", AdvertiserID: "acme", StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour), Schedule: &models.Schedule{
+			Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"sat", "sun"}, Start: "00:00", End: "24:00"}},
+		}},
+	}
+	for _, ad := range ads {
+		assert.Nil(t, repo.Create(ctx, ad))
+	}
+
+	tests := []struct {
+		name string
+		now  time.Time
+		want []string
+	}{
+		{"weekday", now, []string{"always", "office hours"}},
+		{"weekday evening", now.Add(8 * time.Hour), []string{"always"}},
+		{"weekend", now.Add(24 * time.Hour), []string{"always", "weekends"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ads, err := repo.Fetch(ctx, models.AdQuery{Now: tt.now}, 10, 0)
+			assert.Nil(t, err)
+			titles := make([]string, 0, len(ads))
+			for _, ad := range ads {
+				titles = append(titles, ad.Title)
+			}
+			assert.ElementsMatch(t, tt.want, titles)
+
+			count, err := repo.CountActive(ctx, tt.now)
+			assert.Nil(t, err)
+			assert.Equal(t, len(tt.want), count, "expected only the ads on schedule to be active")
+		})
+	}
+
+	// Quotas only count the ads while their windows are open, like the listing
+	count, err := repo.CountActiveByAdvertiser(ctx, now, "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, 0, count)
+	count, err = repo.CountActiveByAdvertiser(ctx, now.Add(24*time.Hour), "acme")
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+	count, err = repo.CountActiveExcludingAdvertisers(ctx, now.Add(8*time.Hour), []string{"acme"})
+	assert.Nil(t, err)
+	assert.Equal(t, 1, count)
+
+	// The office hours close at 17:00 in Taipei, before any ad ends
+	next, err := repo.NextChange(ctx, models.AdQuery{Now: now})
+	assert.Nil(t, err)
+	assert.True(t, now.Add(7*time.Hour).Equal(next), "expected the next window boundary, got %s", next)
+}
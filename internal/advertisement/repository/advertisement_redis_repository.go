@@ -1,7 +1,9 @@
 package repository
 
 import (
+	"ad-service-api/internal/logging"
 	"ad-service-api/internal/models"
+	"ad-service-api/internal/tracing"
 	"context"
 	"encoding/json"
 	"fmt"
@@ -9,16 +11,57 @@ import (
 	"time"
 
 	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 type IAdRedisRepository interface {
 	IncrByDate(ctx context.Context, key string) error
+	DecrByDate(ctx context.Context, key string) error
 	GetByDate(ctx context.Context, key string) (int, error)
-	GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error)
-	SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error
-	DeleteAdsByPattern(ctx context.Context, pattern string) error
+	GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error)
+	SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error
+	// SetAdsByKeyIfGeneration caches the ad list like SetAdsByKey, unless the cached lists were
+	// invalidated since CacheGeneration returned generation, and reports whether it did
+	SetAdsByKeyIfGeneration(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration, generation int64) (bool, error)
+	// CacheGeneration returns the number of times the cached ad lists were invalidated
+	CacheGeneration(ctx context.Context) (int64, error)
+	// DeleteAdsByPattern increments the cache generation, then deletes the matching keys
+	DeleteAdsByPattern(ctx context.Context, pattern string, match func(key string) bool) error
+	AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error)
+	ReleaseLock(ctx context.Context, key string, token string) error
 }
 
+// releaseLockScript deletes the lock key only if it still holds the caller's token.
+const releaseLockScript = `
+if redis.call("GET", KEYS[1]) == ARGV[1] then
+	return redis.call("DEL", KEYS[1])
+end
+return 0
+`
+
+// CacheGenerationKey holds the number of invalidations of the cached ad lists. A list fetched before
+// an invalidation is not cached after it, since it may hold the ads the invalidation was for. The
+// key is outside the ads: prefix, so that deleting the lists keeps it.
+const CacheGenerationKey = "cache:generation"
+
+// setIfGenerationScript sets KEYS[1] to ARGV[1], expiring after ARGV[3] milliseconds unless 0, only
+// if the generation at KEYS[2] is still ARGV[2].
+const setIfGenerationScript = `
+if (redis.call("GET", KEYS[2]) or "0") ~= ARGV[2] then
+	return 0
+end
+if tonumber(ARGV[3]) > 0 then
+	redis.call("SET", KEYS[1], ARGV[1], "PX", ARGV[3])
+else
+	redis.call("SET", KEYS[1], ARGV[1])
+end
+return 1
+`
+
+// scanBatchSize is the SCAN count hint and the number of keys unlinked at once.
+const scanBatchSize = 100
+
 // AdRedisRepository is a struct that implements the IAdRedisRepository interface.
 type AdRedisRepository struct {
 	rdb *redis.Client
@@ -33,36 +76,57 @@ func NewAdRedisRepository(rdb *redis.Client) *AdRedisRepository {
 
 // IncrByDate increments the count associated with the specified date key in Redis.
 func (r *AdRedisRepository) IncrByDate(ctx context.Context, key string) error {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.IncrByDate", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
 	_, err := r.rdb.Incr(ctx, key).Result()
 	if err != nil {
-		return fmt.Errorf("failed to increment count for key %s: %w", key, err)
+		return tracing.Fail(span, fmt.Errorf("failed to increment count for key %s: %w", key, err))
+	}
+	return nil
+}
+
+// DecrByDate decrements the count associated with the specified date key in Redis.
+func (r *AdRedisRepository) DecrByDate(ctx context.Context, key string) error {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.DecrByDate", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	_, err := r.rdb.Decr(ctx, key).Result()
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to decrement count for key %s: %w", key, err))
 	}
 	return nil
 }
 
 // GetByDate retrieves the count associated with the specified date key from Redis.
 func (r *AdRedisRepository) GetByDate(ctx context.Context, key string) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.GetByDate", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
 	countStr, err := r.rdb.Get(ctx, key).Result()
 	if err == redis.Nil {
 		// Key does not exist, return count as 0
 		return 0, nil
 	} else if err != nil {
 		// Some other error occurred
-		return 0, fmt.Errorf("failed to get count for key %s: %w", key, err)
+		return 0, tracing.Fail(span, fmt.Errorf("failed to get count for key %s: %w", key, err))
 	}
 
 	// Convert the count from string to int
 	count, err := strconv.Atoi(countStr)
 	if err != nil {
 		// Handle the case where the count is not a valid integer
-		return 0, fmt.Errorf("failed to convert count to integer for key %s: %w", key, err)
+		return 0, tracing.Fail(span, fmt.Errorf("failed to convert count to integer for key %s: %w", key, err))
 	}
 
 	return count, nil
 }
 
-// GetAdsByKey retrieves the advertisements associated with the specified key from Redis.
-func (r *AdRedisRepository) GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error) {
+// GetAdsByKey retrieves the ad list cached at the specified key from Redis.
+func (r *AdRedisRepository) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.GetAdsByKey", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
 	adsData, err := r.rdb.Get(ctx, key).Result()
 	if err == redis.Nil {
 		// Key does not exist, return nil
@@ -70,49 +134,147 @@ func (r *AdRedisRepository) GetAdsByKey(ctx context.Context, key string) ([]*mod
 	}
 	if err != nil {
 		// Some other error occurred
-		return nil, fmt.Errorf("failed to get ads for key %s: %w", key, err)
+		return nil, tracing.Fail(span, fmt.Errorf("failed to get ads for key %s: %w", key, err))
 	}
 
-	var ads []*models.Advertisement
-	err = json.Unmarshal([]byte(adsData), &ads)
+	var cached models.CachedAds
+	err = json.Unmarshal([]byte(adsData), &cached)
 	if err != nil {
 		// Error occurred during unmarshalling
-		return nil, fmt.Errorf("failed to unmarshal ads data: %w", err)
+		return nil, tracing.Fail(span, fmt.Errorf("failed to unmarshal ads data: %w", err))
 	}
+	span.SetAttributes(attribute.Int("cache.ads", len(cached.Ads)))
 
-	return ads, nil
+	return &cached, nil
 }
 
-// SetAdsByKey sets the advertisements associated with the specified key in Redis.
-func (r *AdRedisRepository) SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error {
-	adsData, err := json.Marshal(ads)
+// SetAdsByKey caches the ad list at the specified key in Redis.
+func (r *AdRedisRepository) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.SetAdsByKey", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	adsData, err := json.Marshal(cached)
 	if err != nil {
 		// Error occurred during marshalling
-		return fmt.Errorf("failed to marshal ads data: %w", err)
+		return tracing.Fail(span, fmt.Errorf("failed to marshal ads data: %w", err))
 	}
 
 	err = r.rdb.Set(ctx, key, adsData, expiration).Err()
 	if err != nil {
 		// Some other error occurred
-		return fmt.Errorf("failed to set ads for key %s: %w", key, err)
+		return tracing.Fail(span, fmt.Errorf("failed to set ads for key %s: %w", key, err))
 	}
+	span.SetAttributes(attribute.Int("cache.ads", len(cached.Ads)), attribute.String("cache.ttl", expiration.String()))
 
 	return nil
 }
 
-// DeleteAdsByPattern deletes all keys that start with the specified pattern from Redis.
-func (r *AdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string) error {
-	keys, err := r.rdb.Keys(ctx, pattern).Result()
+// SetAdsByKeyIfGeneration caches the ad list at the specified key in Redis, unless the generation
+// at CacheGenerationKey is no longer generation. The check and the write run in one script.
+func (r *AdRedisRepository) SetAdsByKeyIfGeneration(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration, generation int64) (bool, error) {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.SetAdsByKeyIfGeneration", trace.WithAttributes(attribute.String("cache.key", key), attribute.Int64("cache.generation", generation)))
+	defer span.End()
+
+	adsData, err := json.Marshal(cached)
+	if err != nil {
+		return false, tracing.Fail(span, fmt.Errorf("failed to marshal ads data: %w", err))
+	}
+
+	set, err := r.rdb.Eval(ctx, setIfGenerationScript, []string{key, CacheGenerationKey}, adsData, generation, expiration.Milliseconds()).Int()
+	if err != nil {
+		return false, tracing.Fail(span, fmt.Errorf("failed to set ads for key %s: %w", key, err))
+	}
+	span.SetAttributes(attribute.Int("cache.ads", len(cached.Ads)), attribute.String("cache.ttl", expiration.String()), attribute.Bool("cache.set", set == 1))
+
+	return set == 1, nil
+}
+
+// CacheGeneration returns the generation at CacheGenerationKey, 0 if it was never incremented.
+func (r *AdRedisRepository) CacheGeneration(ctx context.Context) (int64, error) {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.CacheGeneration")
+	defer span.End()
+
+	generation, err := r.rdb.Get(ctx, CacheGenerationKey).Int64()
+	if err == redis.Nil {
+		return 0, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to get keys for pattern %s: %w", pattern, err)
+		return 0, tracing.Fail(span, fmt.Errorf("failed to get cache generation: %w", err))
 	}
+	return generation, nil
+}
+
+// DeleteAdsByPattern increments the generation at CacheGenerationKey, so that the lists fetched
+// before are not cached, then deletes the keys matching the glob-style pattern for which match
+// returns true, or all of them if match is nil. Keys are found with SCAN and removed with UNLINK in
+// batches, so Redis is never blocked, even with a large keyspace.
+func (r *AdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string, match func(key string) bool) error {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.DeleteAdsByPattern", trace.WithAttributes(attribute.String("cache.pattern", pattern)))
+	defer span.End()
 
-	if len(keys) > 0 {
-		_, err = r.rdb.Del(ctx, keys...).Result()
-		if err != nil {
+	if err := r.rdb.Incr(ctx, CacheGenerationKey).Err(); err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to increment cache generation: %w", err))
+	}
+
+	deleted := 0
+	batch := make([]string, 0, scanBatchSize)
+	unlink := func() error {
+		if len(batch) == 0 {
+			return nil
+		}
+		if err := r.rdb.Unlink(ctx, batch...).Err(); err != nil {
 			return fmt.Errorf("failed to delete keys for pattern %s: %w", pattern, err)
 		}
+		deleted += len(batch)
+		batch = batch[:0]
+		return nil
+	}
+
+	iter := r.rdb.Scan(ctx, 0, pattern, scanBatchSize).Iterator()
+	for iter.Next(ctx) {
+		if match != nil && !match(iter.Val()) {
+			continue
+		}
+		batch = append(batch, iter.Val())
+		if len(batch) == scanBatchSize {
+			if err := unlink(); err != nil {
+				return tracing.Fail(span, err)
+			}
+		}
+	}
+	if err := iter.Err(); err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to scan keys for pattern %s: %w", pattern, err))
+	}
+	if err := unlink(); err != nil {
+		return tracing.Fail(span, err)
 	}
 
+	span.SetAttributes(attribute.Int("cache.deleted_keys", deleted))
+	logging.FromContext(ctx).Debug("invalidated cached ads", "pattern", pattern, "keys", deleted)
+
+	return nil
+}
+
+// AcquireLock sets the lock key to token if it is not already held, expiring after ttl.
+func (r *AdRedisRepository) AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.AcquireLock", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	acquired, err := r.rdb.SetNX(ctx, key, token, ttl).Result()
+	if err != nil {
+		return false, tracing.Fail(span, fmt.Errorf("failed to acquire lock %s: %w", key, err))
+	}
+	return acquired, nil
+}
+
+// ReleaseLock deletes the lock key if it is still held by token.
+func (r *AdRedisRepository) ReleaseLock(ctx context.Context, key string, token string) error {
+	ctx, span := tracer.Start(ctx, "AdRedisRepository.ReleaseLock", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	err := r.rdb.Eval(ctx, releaseLockScript, []string{key}, token).Err()
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to release lock %s: %w", key, err))
+	}
 	return nil
 }
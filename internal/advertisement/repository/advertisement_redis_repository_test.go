@@ -4,6 +4,7 @@ import (
 	"context"
 	"encoding/json"
 	"testing"
+	"time"
 
 	"github.com/go-redis/redismock/v8"
 	"github.com/stretchr/testify/assert"
@@ -23,6 +24,17 @@ func TestAdRedisRepository_IncrByDate(t *testing.T) {
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
+func TestAdRedisRepository_DecrByDate(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	mock.ExpectDecr("testKey").SetVal(0)
+
+	err := repo.DecrByDate(context.Background(), "testKey")
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
 func TestAdRedisRepository_GetByDate(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	repo := repository.NewAdRedisRepository(db)
@@ -39,16 +51,17 @@ func TestAdRedisRepository_GetAdByKey(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	repo := repository.NewAdRedisRepository(db)
 
-	ads := []*models.Advertisement{
-		{Title: "test1"},
+	cached := &models.CachedAds{
+		Ads:        []*models.Advertisement{{Title: "test1"}},
+		FreshUntil: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
-	adsJson, _ := json.Marshal(ads)
+	adsJson, _ := json.Marshal(cached)
 
 	mock.ExpectGet("ads:testKey").SetVal(string(adsJson))
 
 	returnedAds, err := repo.GetAdsByKey(context.Background(), "ads:testKey")
 	assert.NoError(t, err)
-	assert.Equal(t, ads, returnedAds)
+	assert.Equal(t, cached, returnedAds)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -56,15 +69,57 @@ func TestAdRedisRepository_SetAdsByKey(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	repo := repository.NewAdRedisRepository(db)
 
-	ads := []*models.Advertisement{
-		{Title: "test1"},
+	cached := &models.CachedAds{
+		Ads:        []*models.Advertisement{{Title: "test1"}},
+		FreshUntil: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
 	}
-	adsJson, _ := json.Marshal(ads)
+	adsJson, _ := json.Marshal(cached)
 
 	mock.ExpectSet("testKey", adsJson, 0).SetVal("OK")
 
-	err := repo.SetAdsByKey(context.Background(), "testKey", ads, 0)
+	err := repo.SetAdsByKey(context.Background(), "testKey", cached, 0)
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdRedisRepository_SetAdsByKeyIfGeneration(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	cached := &models.CachedAds{
+		Ads:        []*models.Advertisement{{Title: "test1"}},
+		FreshUntil: time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC),
+	}
+	adsJson, _ := json.Marshal(cached)
+
+	mock.Regexp().ExpectEval(`PX`, []string{"testKey", repository.CacheGenerationKey}, adsJson, int64(3), int64(60000)).SetVal(int64(1))
+	mock.Regexp().ExpectEval(`PX`, []string{"testKey", repository.CacheGenerationKey}, adsJson, int64(2), int64(60000)).SetVal(int64(0))
+
+	set, err := repo.SetAdsByKeyIfGeneration(context.Background(), "testKey", cached, time.Minute, 3)
+	assert.NoError(t, err)
+	assert.True(t, set)
+
+	// The cached lists were invalidated since generation 2
+	set, err = repo.SetAdsByKeyIfGeneration(context.Background(), "testKey", cached, time.Minute, 2)
+	assert.NoError(t, err)
+	assert.False(t, set)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdRedisRepository_CacheGeneration(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	mock.ExpectGet(repository.CacheGenerationKey).RedisNil()
+	mock.ExpectGet(repository.CacheGenerationKey).SetVal("3")
+
+	generation, err := repo.CacheGeneration(context.Background())
 	assert.NoError(t, err)
+	assert.Equal(t, int64(0), generation)
+
+	generation, err = repo.CacheGeneration(context.Background())
+	assert.NoError(t, err)
+	assert.Equal(t, int64(3), generation)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
 
@@ -72,10 +127,53 @@ func TestAdRedisRepository_DeleteAdsByPattern(t *testing.T) {
 	db, mock := redismock.NewClientMock()
 	repo := repository.NewAdRedisRepository(db)
 
-	mock.ExpectKeys("testKey").SetVal([]string{"ads:testKey"})
-	mock.ExpectDel("ads:testKey").SetVal(1)
+	// The generation is incremented before the keys are deleted
+	mock.ExpectIncr(repository.CacheGenerationKey).SetVal(1)
+	mock.ExpectScan(0, "ads:*", 100).SetVal([]string{"ads:country:TW", "ads:country:JP", "ads:age:20"}, 0)
+	mock.ExpectUnlink("ads:country:TW", "ads:age:20").SetVal(2)
+
+	err := repo.DeleteAdsByPattern(context.Background(), "ads:*", func(key string) bool { return key != "ads:country:JP" })
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdRedisRepository_DeleteAdsByPattern_NoMatch(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	// Nothing is unlinked when no key matches
+	mock.ExpectIncr(repository.CacheGenerationKey).SetVal(1)
+	mock.ExpectScan(0, "ads:*", 100).SetVal([]string{"ads:country:JP"}, 0)
+
+	err := repo.DeleteAdsByPattern(context.Background(), "ads:*", func(key string) bool { return false })
+	assert.NoError(t, err)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdRedisRepository_AcquireLock(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	mock.ExpectSetNX("lockKey", "token", time.Second).SetVal(true)
+	mock.ExpectSetNX("lockKey", "other", time.Second).SetVal(false)
+
+	acquired, err := repo.AcquireLock(context.Background(), "lockKey", "token", time.Second)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	acquired, err = repo.AcquireLock(context.Background(), "lockKey", "other", time.Second)
+	assert.NoError(t, err)
+	assert.False(t, acquired)
+	assert.NoError(t, mock.ExpectationsWereMet())
+}
+
+func TestAdRedisRepository_ReleaseLock(t *testing.T) {
+	db, mock := redismock.NewClientMock()
+	repo := repository.NewAdRedisRepository(db)
+
+	mock.Regexp().ExpectEval(`GET`, []string{"lockKey"}, "token").SetVal(int64(1))
 
-	err := repo.DeleteAdsByPattern(context.Background(), "testKey")
+	err := repo.ReleaseLock(context.Background(), "lockKey", "token")
 	assert.NoError(t, err)
 	assert.NoError(t, mock.ExpectationsWereMet())
 }
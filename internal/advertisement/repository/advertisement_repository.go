@@ -1,23 +1,45 @@
 package repository
 
 import (
+	"ad-service-api/database"
+	"ad-service-api/internal/logging"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/models"
+	"ad-service-api/internal/tracing"
 	"context"
+	"errors"
 	"fmt"
 	"time"
 
 	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
 	"go.mongodb.org/mongo-driver/mongo"
 	"go.mongodb.org/mongo-driver/mongo/options"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
 )
 
 //go:generate mockery --name=IAdvertisementRepository --structname=MockAdvertisementRepository --output=mocks --dir=./internal/advertisement/repository --inpackage --with-expecter --testonly
 type IAdvertisementRepository interface {
 	Create(ctx context.Context, ad *models.Advertisement) error
 	CountActive(ctx context.Context, now time.Time) (int, error)
-	Fetch(ctx context.Context, filter bson.M, limit, offset int) ([]*models.Advertisement, error)
+	CountActiveByAdvertiser(ctx context.Context, now time.Time, advertiserID string) (int, error)
+	// CountActiveExcludingAdvertisers counts the active ads of the shared quota of every advertiser but the given ones
+	CountActiveExcludingAdvertisers(ctx context.Context, now time.Time, advertiserIDs []string) (int, error)
+	CountCreatedSince(ctx context.Context, since time.Time) (int, error)
+	Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error)
+	NextChange(ctx context.Context, query models.AdQuery) (time.Time, error)
+	GetByID(ctx context.Context, id string) (*models.Advertisement, error)
+	Update(ctx context.Context, id string, ad *models.Advertisement) error
+	Delete(ctx context.Context, id string) error
 }
 
+var tracer = otel.Tracer("ad-service-api/internal/advertisement/repository")
+
+// ErrAdNotFound is returned when no advertisement matches the given id.
+var ErrAdNotFound = errors.New("advertisement not found")
+
 // AdvertisementRepositoryImpl implements the AdvertisementRepository interface.
 type AdvertisementRepository struct {
 	collection *mongo.Collection
@@ -32,41 +54,249 @@ func NewAdvertisementRepository(collection *mongo.Collection) IAdvertisementRepo
 
 // Create inserts a new advertisement document into the MongoDB collection.
 func (r *AdvertisementRepository) Create(ctx context.Context, ad *models.Advertisement) error {
-	_, err := r.collection.InsertOne(ctx, ad)
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.Create")
+	defer span.End()
+
+	result, err := r.collection.InsertOne(ctx, ad)
 	if err != nil {
-		return fmt.Errorf("failed to insert advertisement: %w", err)
+		return tracing.Fail(span, fmt.Errorf("failed to insert advertisement: %w", err))
+	}
+	if id, ok := result.InsertedID.(primitive.ObjectID); ok {
+		ad.ID = id
 	}
 	return nil
 }
 
-// CountActive returns the count of active advertisements based on the provided timestamp.
+// CountActive returns the count of active advertisements based on the provided timestamp; a
+// scheduled ad is only active while one of its windows is open.
 func (r *AdvertisementRepository) CountActive(ctx context.Context, now time.Time) (int, error) {
-	filter := bson.M{
-		"startAt": bson.M{"$lte": now},
-		"endAt":   bson.M{"$gte": now},
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.CountActive")
+	defer span.End()
+
+	filter := database.CreateFilter(models.AdQuery{Now: now})
+
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, tracing.Fail(span, fmt.Errorf("failed to count active advertisements: %w", err))
+	}
+
+	return int(count), nil
+}
+
+// CountActiveByAdvertiser returns the count of the advertiser's active advertisements; an empty
+// advertiserID counts the advertisements that belong to no advertiser. Like CountActive, a scheduled
+// ad is only active while one of its windows is open.
+func (r *AdvertisementRepository) CountActiveByAdvertiser(ctx context.Context, now time.Time, advertiserID string) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.CountActiveByAdvertiser", trace.WithAttributes(attribute.String("ad.advertiser_id", advertiserID)))
+	defer span.End()
+
+	filter := database.CreateFilter(models.AdQuery{Now: now})
+	filter["advertiserId"] = advertiserID
+	if advertiserID == "" {
+		filter["advertiserId"] = bson.M{"$in": bson.A{nil, ""}}
 	}
 
 	count, err := r.collection.CountDocuments(ctx, filter)
 	if err != nil {
-		return 0, fmt.Errorf("failed to count active advertisements: %w", err)
+		return 0, tracing.Fail(span, fmt.Errorf("failed to count active advertisements for advertiser %s: %w", advertiserID, err))
 	}
 
 	return int(count), nil
 }
 
-// Fetch retrieves advertisements from the MongoDB collection based on the provided filter, limit, and offset.
-func (r *AdvertisementRepository) Fetch(ctx context.Context, filter bson.M, limit, offset int) ([]*models.Advertisement, error) {
-	findOptions := options.Find().SetLimit(int64(limit)).SetSkip(int64(offset)).SetSort(bson.D{{Key: "endAt", Value: 1}})
+// CountActiveExcludingAdvertisers returns the count of the active advertisements that belong to no
+// advertiser or to one not in advertiserIDs, honouring schedules like CountActiveByAdvertiser.
+func (r *AdvertisementRepository) CountActiveExcludingAdvertisers(ctx context.Context, now time.Time, advertiserIDs []string) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.CountActiveExcludingAdvertisers", trace.WithAttributes(attribute.StringSlice("ad.excluded_advertiser_ids", advertiserIDs)))
+	defer span.End()
+
+	// $nin matches the ads without an advertiserId as well; a nil slice would be encoded as null
+	if advertiserIDs == nil {
+		advertiserIDs = []string{}
+	}
+	filter := database.CreateFilter(models.AdQuery{Now: now})
+	filter["advertiserId"] = bson.M{"$nin": advertiserIDs}
+
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, tracing.Fail(span, fmt.Errorf("failed to count active advertisements of the shared quota: %w", err))
+	}
+
+	return int(count), nil
+}
+
+// CountCreatedSince returns the count of advertisements created at or after since, using the
+// creation time embedded in their ObjectID.
+func (r *AdvertisementRepository) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.CountCreatedSince")
+	defer span.End()
+
+	filter := bson.M{"_id": bson.M{"$gte": primitive.NewObjectIDFromTimestamp(since)}}
+
+	count, err := r.collection.CountDocuments(ctx, filter)
+	if err != nil {
+		return 0, tracing.Fail(span, fmt.Errorf("failed to count advertisements created since %s: %w", since, err))
+	}
+
+	return int(count), nil
+}
+
+// Fetch retrieves advertisements from the MongoDB collection based on the provided query, limit, and offset.
+func (r *AdvertisementRepository) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	filter := database.CreateFilter(query)
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.Fetch", trace.WithAttributes(
+		attribute.String("db.filter", filterString(filter)),
+		attribute.Int("db.limit", limit),
+		attribute.Int("db.offset", offset),
+	))
+	defer span.End()
+
+	defer func(t time.Time) {
+		metrics.MongoQueryDuration.WithLabelValues("fetch").Observe(time.Since(t).Seconds())
+	}(time.Now())
+
+	findOptions := options.Find().SetLimit(int64(limit)).SetSkip(int64(offset)).SetSort(database.ListingSort)
 	cursor, err := r.collection.Find(ctx, filter, findOptions)
 	if err != nil {
-		return nil, fmt.Errorf("failed to find advertisements: %w", err)
+		return nil, tracing.Fail(span, fmt.Errorf("failed to find advertisements: %w", err))
 	}
 	defer cursor.Close(ctx)
 
 	var ads []*models.Advertisement
 	if err := cursor.All(ctx, &ads); err != nil {
-		return nil, fmt.Errorf("failed to decode advertisements: %w", err)
+		return nil, tracing.Fail(span, fmt.Errorf("failed to decode advertisements: %w", err))
 	}
+	span.SetAttributes(attribute.Int("db.results", len(ads)))
+	logging.FromContext(ctx).Debug("fetched advertisements", "filter", filter, "count", len(ads))
 
 	return ads, nil
 }
+
+// NextChange returns the earliest instant after query.Now at which the result of Fetch for the
+// query changes, because a matching ad starts or ends or one of its schedule's windows opens or
+// closes, or the zero time if none will.
+// An ad is still listed at its endAt, so it drops out one millisecond later, the resolution of
+// BSON dates. The windows are computed from the distinct schedules of the matching ads.
+func (r *AdvertisementRepository) NextChange(ctx context.Context, query models.AdQuery) (time.Time, error) {
+	filter := database.CreateUpcomingFilter(query)
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.NextChange", trace.WithAttributes(attribute.String("db.filter", filterString(filter))))
+	defer span.End()
+
+	defer func(t time.Time) {
+		metrics.MongoQueryDuration.WithLabelValues("next_change").Observe(time.Since(t).Seconds())
+	}(time.Now())
+
+	pipeline := mongo.Pipeline{
+		{{Key: "$match", Value: filter}},
+		{{Key: "$group", Value: bson.D{
+			{Key: "_id", Value: nil},
+			{Key: "next", Value: bson.D{{Key: "$min", Value: bson.D{{Key: "$cond", Value: bson.A{
+				bson.D{{Key: "$gt", Value: bson.A{"$startAt", query.Now}}},
+				"$startAt",
+				bson.D{{Key: "$add", Value: bson.A{"$endAt", 1}}},
+			}}}}}},
+			{Key: "schedules", Value: bson.D{{Key: "$addToSet", Value: "$schedule"}}},
+		}}},
+	}
+	cursor, err := r.collection.Aggregate(ctx, pipeline)
+	if err != nil {
+		return time.Time{}, tracing.Fail(span, fmt.Errorf("failed to aggregate next schedule change: %w", err))
+	}
+	defer cursor.Close(ctx)
+
+	var results []struct {
+		Next      time.Time          `bson:"next"`
+		Schedules []*models.Schedule `bson:"schedules"`
+	}
+	if err := cursor.All(ctx, &results); err != nil {
+		return time.Time{}, tracing.Fail(span, fmt.Errorf("failed to decode next schedule change: %w", err))
+	}
+	if len(results) == 0 {
+		return time.Time{}, nil
+	}
+
+	next := results[0].Next
+	for _, schedule := range results[0].Schedules {
+		if schedule == nil {
+			continue
+		}
+		if change := schedule.NextChange(query.Now); !change.IsZero() && change.Before(next) {
+			next = change
+		}
+	}
+	return next, nil
+}
+
+// GetByID retrieves a single advertisement by its id.
+func (r *AdvertisementRepository) GetByID(ctx context.Context, id string) (*models.Advertisement, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.GetByID", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return nil, ErrAdNotFound
+	}
+
+	var ad models.Advertisement
+	err = r.collection.FindOne(ctx, bson.M{"_id": objectID}).Decode(&ad)
+	if errors.Is(err, mongo.ErrNoDocuments) {
+		return nil, ErrAdNotFound
+	}
+	if err != nil {
+		return nil, tracing.Fail(span, fmt.Errorf("failed to find advertisement %s: %w", id, err))
+	}
+
+	return &ad, nil
+}
+
+// Update replaces the advertisement with the given id.
+func (r *AdvertisementRepository) Update(ctx context.Context, id string, ad *models.Advertisement) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.Update", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAdNotFound
+	}
+
+	ad.ID = objectID
+	result, err := r.collection.ReplaceOne(ctx, bson.M{"_id": objectID}, ad)
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to update advertisement %s: %w", id, err))
+	}
+	if result.MatchedCount == 0 {
+		return ErrAdNotFound
+	}
+
+	return nil
+}
+
+// Delete removes the advertisement with the given id.
+func (r *AdvertisementRepository) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementRepository.Delete", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	objectID, err := primitive.ObjectIDFromHex(id)
+	if err != nil {
+		return ErrAdNotFound
+	}
+
+	result, err := r.collection.DeleteOne(ctx, bson.M{"_id": objectID})
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to delete advertisement %s: %w", id, err))
+	}
+	if result.DeletedCount == 0 {
+		return ErrAdNotFound
+	}
+
+	return nil
+}
+
+// filterString renders a filter as extended JSON for span attributes.
+func filterString(filter bson.M) string {
+	data, err := bson.MarshalExtJSON(filter, false, false)
+	if err != nil {
+		return fmt.Sprint(filter)
+	}
+	return string(data)
+}
@@ -65,6 +65,67 @@ func TestAdvertisementRepository_CountActive(t *testing.T) {
 	})
 }
 
+func TestAdvertisementRepository_CountActiveByAdvertiser(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("CountActiveByAdvertiser", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock response for the CountDocuments operation
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(2)}}))
+
+		count, err := repo.CountActiveByAdvertiser(ctx, time.Now(), "acme")
+		assert.Nil(t, err)
+		assert.Equal(t, 2, count, "expected count of the advertiser's active advertisements to be correct")
+
+		// Scheduled ads only count while one of their windows is open, as in the listing filter
+		started := mt.GetStartedEvent()
+		assert.NotNil(t, started)
+		match := started.Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match").Document()
+		assert.Contains(t, match.Lookup("$and").String(), "$schedule.timezone")
+		assert.Equal(t, "acme", match.Lookup("advertiserId").StringValue())
+	})
+}
+
+func TestAdvertisementRepository_CountActiveExcludingAdvertisers(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("CountActiveExcludingAdvertisers", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock response for the CountDocuments operation
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(3)}}))
+
+		count, err := repo.CountActiveExcludingAdvertisers(ctx, time.Now(), nil)
+		assert.Nil(t, err)
+		assert.Equal(t, 3, count, "expected count of active advertisements of the shared quota to be correct")
+
+		// A nil list must not be sent as a null $nin
+		started := mt.GetStartedEvent()
+		assert.NotNil(t, started)
+		nin := started.Command.Lookup("pipeline").Array().Index(0).Value().Document().Lookup("$match", "advertiserId", "$nin")
+		assert.Equal(t, bson.TypeArray, nin.Type)
+	})
+}
+
+func TestAdvertisementRepository_CountCreatedSince(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("CountCreatedSince", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock response for the CountDocuments operation
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "n", Value: int32(5)}}))
+
+		count, err := repo.CountCreatedSince(ctx, time.Now().Add(-time.Hour))
+		assert.Nil(t, err)
+		assert.Equal(t, 5, count, "expected count of advertisements created since the given time to be correct")
+	})
+}
+
 func TestAdvertisementRepository_Fetch(t *testing.T) {
 	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
 
@@ -81,9 +142,121 @@ func TestAdvertisementRepository_Fetch(t *testing.T) {
 		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.NextBatch, bson.D{{Key: "_id", Value: primitive.NewObjectID()}, {Key: "title", Value: "Ad 2"}}))
 		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.NextBatch))
 
-		ads, err := repo.Fetch(ctx, bson.M{}, 10, 0)
+		ads, err := repo.Fetch(ctx, models.AdQuery{Now: time.Now()}, 10, 0)
 		fmt.Println(ads)
 		assert.Nil(t, err)
 		assert.Len(t, ads, 2, "expected number of advertisements to match")
 	})
 }
+
+func TestAdvertisementRepository_NextChange(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("NextChange", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+		next := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+
+		// Set up the mock response for the aggregation
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: nil}, {Key: "next", Value: next}}))
+
+		result, err := repo.NextChange(ctx, models.AdQuery{Now: time.Now()})
+		assert.Nil(t, err)
+		assert.True(t, next.Equal(result), "expected the next change to be decoded")
+	})
+
+	mt.Run("NoUpcomingAds", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		result, err := repo.NextChange(ctx, models.AdQuery{Now: time.Now()})
+		assert.Nil(t, err)
+		assert.True(t, result.IsZero(), "expected no change without upcoming ads")
+	})
+}
+
+func TestAdvertisementRepository_GetByID(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("GetByID", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+		id := primitive.NewObjectID()
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateCursorResponse(1, "foo.bar", mtest.FirstBatch, bson.D{{Key: "_id", Value: id}, {Key: "title", Value: "Ad 1"}}))
+
+		ad, err := repo.GetByID(ctx, id.Hex())
+		assert.Nil(t, err)
+		assert.Equal(t, id, ad.ID)
+		assert.Equal(t, "Ad 1", ad.Title)
+	})
+
+	mt.Run("GetByID not found", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateCursorResponse(0, "foo.bar", mtest.FirstBatch))
+
+		_, err := repo.GetByID(ctx, primitive.NewObjectID().Hex())
+		assert.ErrorIs(t, err, repository.ErrAdNotFound)
+	})
+}
+
+func TestAdvertisementRepository_Update(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Update", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+		id := primitive.NewObjectID()
+		ad := &models.Advertisement{Title: "Updated Ad"}
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}, bson.E{Key: "nModified", Value: 1}))
+
+		err := repo.Update(ctx, id.Hex(), ad)
+		assert.Nil(t, err)
+		assert.Equal(t, id, ad.ID)
+	})
+
+	mt.Run("Update not found", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}, bson.E{Key: "nModified", Value: 0}))
+
+		err := repo.Update(ctx, primitive.NewObjectID().Hex(), &models.Advertisement{})
+		assert.ErrorIs(t, err, repository.ErrAdNotFound)
+	})
+}
+
+func TestAdvertisementRepository_Delete(t *testing.T) {
+	mt := mtest.New(t, mtest.NewOptions().ClientType(mtest.Mock))
+
+	mt.Run("Delete", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 1}))
+
+		err := repo.Delete(ctx, primitive.NewObjectID().Hex())
+		assert.Nil(t, err)
+	})
+
+	mt.Run("Delete not found", func(mt *mtest.T) {
+		repo := repository.NewAdvertisementRepository(mt.Coll)
+		ctx := context.Background()
+
+		// Set up the mock responses
+		mt.AddMockResponses(mtest.CreateSuccessResponse(bson.E{Key: "n", Value: 0}))
+
+		err := repo.Delete(ctx, primitive.NewObjectID().Hex())
+		assert.ErrorIs(t, err, repository.ErrAdNotFound)
+	})
+}
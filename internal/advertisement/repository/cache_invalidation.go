@@ -0,0 +1,122 @@
+package repository
+
+import (
+	"ad-service-api/internal/tracing"
+	"context"
+	"fmt"
+	"sync"
+
+	"github.com/go-redis/redis/v8"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// InvalidationChannel is the Redis pub/sub channel the invalidations of the shared cache are broadcast on.
+const InvalidationChannel = "cache:invalidations"
+
+// InvalidationBus broadcasts the invalidations of the shared cache to every replica.
+type InvalidationBus interface {
+	// Publish broadcasts the glob-style pattern of keys deleted from the shared cache.
+	Publish(ctx context.Context, pattern string) error
+	// Subscribe calls fn with every pattern published after it returns, until ctx is done.
+	Subscribe(ctx context.Context, fn func(pattern string)) error
+}
+
+// RedisInvalidationBus implements the InvalidationBus interface with Redis pub/sub.
+type RedisInvalidationBus struct {
+	rdb *redis.Client
+}
+
+// NewRedisInvalidationBus creates a new RedisInvalidationBus with the specified Redis client.
+func NewRedisInvalidationBus(rdb *redis.Client) *RedisInvalidationBus {
+	return &RedisInvalidationBus{
+		rdb: rdb,
+	}
+}
+
+// Publish broadcasts the pattern on InvalidationChannel.
+func (b *RedisInvalidationBus) Publish(ctx context.Context, pattern string) error {
+	ctx, span := tracer.Start(ctx, "RedisInvalidationBus.Publish", trace.WithAttributes(attribute.String("cache.pattern", pattern)))
+	defer span.End()
+
+	if err := b.rdb.Publish(ctx, InvalidationChannel, pattern).Err(); err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to publish invalidation of %s: %w", pattern, err))
+	}
+	return nil
+}
+
+// Subscribe subscribes to InvalidationChannel and delivers its messages to fn from a background
+// goroutine. The client resubscribes after losing its connection, but messages published in the
+// meantime are lost, so whatever fn invalidates must also expire on its own.
+func (b *RedisInvalidationBus) Subscribe(ctx context.Context, fn func(pattern string)) error {
+	pubsub := b.rdb.Subscribe(ctx, InvalidationChannel)
+	// Wait for the subscription to be confirmed, so that no later message is missed
+	if _, err := pubsub.Receive(ctx); err != nil {
+		pubsub.Close()
+		return fmt.Errorf("failed to subscribe to %s: %w", InvalidationChannel, err)
+	}
+
+	go func() {
+		defer pubsub.Close()
+		messages := pubsub.Channel()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case msg, ok := <-messages:
+				if !ok {
+					return
+				}
+				fn(msg.Payload)
+			}
+		}
+	}()
+	return nil
+}
+
+// MemoryInvalidationBus implements the InvalidationBus interface in process memory, delivering
+// every pattern synchronously to the subscribers of the same bus.
+type MemoryInvalidationBus struct {
+	mu          sync.Mutex
+	nextID      int
+	subscribers map[int]func(pattern string)
+}
+
+// NewMemoryInvalidationBus creates a new MemoryInvalidationBus without subscribers.
+func NewMemoryInvalidationBus() *MemoryInvalidationBus {
+	return &MemoryInvalidationBus{
+		subscribers: make(map[int]func(pattern string)),
+	}
+}
+
+// Publish calls every subscriber with the pattern.
+func (b *MemoryInvalidationBus) Publish(ctx context.Context, pattern string) error {
+	b.mu.Lock()
+	subscribers := make([]func(pattern string), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	b.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(pattern)
+	}
+	return nil
+}
+
+// Subscribe registers fn until ctx is done.
+func (b *MemoryInvalidationBus) Subscribe(ctx context.Context, fn func(pattern string)) error {
+	b.mu.Lock()
+	id := b.nextID
+	b.nextID++
+	b.subscribers[id] = fn
+	b.mu.Unlock()
+
+	go func() {
+		<-ctx.Done()
+		b.mu.Lock()
+		delete(b.subscribers, id)
+		b.mu.Unlock()
+	}()
+	return nil
+}
@@ -2,99 +2,675 @@ package service
 
 import (
 	"ad-service-api/internal/advertisement/repository"
+	"ad-service-api/internal/logging"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/models"
+	"ad-service-api/internal/tracing"
+	"ad-service-api/redis"
 	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"slices"
 	"time"
 
-	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/trace"
+	"golang.org/x/sync/singleflight"
 )
 
+const (
+	// DefaultDailyAdLimit is the default maximum number of ads the advertisers without an override can
+	// create per day, together.
+	DefaultDailyAdLimit = 3000
+	// DefaultActiveAdLimit is the default maximum number of ads the advertisers without an override can
+	// have active at the same time, together.
+	DefaultActiveAdLimit = 1000
+
+	// quotaLockPrefix starts the key of the lock serializing an advertiser's quota checks and
+	// inserts across all replicas.
+	quotaLockPrefix = "quota:lock"
+	// quotaLockTTL bounds how long a crashed replica can hold the quota lock.
+	quotaLockTTL = 10 * time.Second
+	// quotaCheckTimeout bounds the checks and the insert run under the quota lock. It is shorter than
+	// quotaLockTTL, so that a slow insert is cancelled before the lock expires and lets another
+	// replica in.
+	quotaCheckTimeout = quotaLockTTL - 2*time.Second
+	// quotaLockWait is how long CreateWithQuota waits for the quota lock.
+	quotaLockWait = 5 * time.Second
+	// quotaLockRetry is the delay between attempts to take the quota lock.
+	quotaLockRetry = 10 * time.Millisecond
+
+	// refreshLockPrefix starts the key of the lock held while a cached ad list is refreshed.
+	refreshLockPrefix = "refresh:"
+	// refreshLockTTL bounds how long a crashed replica can hold the refresh lock of a list.
+	refreshLockTTL = 5 * time.Second
+	// refreshLockWait is how long RefreshAds waits for another replica to refresh a list before
+	// querying MongoDB itself.
+	refreshLockWait = 2 * time.Second
+	// refreshLockRetry is the delay between two looks at the cache while another replica refreshes it.
+	refreshLockRetry = 20 * time.Millisecond
+)
+
+var tracer = otel.Tracer("ad-service-api/internal/advertisement/service")
+
+var (
+	// ErrDailyLimitReached is returned when no more ads can be created today.
+	ErrDailyLimitReached = errors.New("daily ad limit reached")
+	// ErrActiveLimitReached is returned when the number of active ads is at its limit.
+	ErrActiveLimitReached = errors.New("active ad limit reached")
+	// ErrQuotaLockTimeout is returned when the quota lock could not be taken in time.
+	ErrQuotaLockTimeout = errors.New("timed out waiting for quota lock")
+)
+
+// QuotaLimits are the ad creation limits of a single advertiser.
+type QuotaLimits struct {
+	Daily  int
+	Active int
+}
+
+// QuotaConfig holds the default quota limits and the per-advertiser overrides.
+type QuotaConfig struct {
+	Default     QuotaLimits
+	Advertisers map[string]QuotaLimits
+}
+
+// DefaultQuotaConfig returns a QuotaConfig with the default limits and no overrides.
+func DefaultQuotaConfig() QuotaConfig {
+	return QuotaConfig{
+		Default: QuotaLimits{Daily: DefaultDailyAdLimit, Active: DefaultActiveAdLimit},
+	}
+}
+
+// LimitsFor returns the quota limits that apply to the advertiser.
+func (c QuotaConfig) LimitsFor(advertiserID string) QuotaLimits {
+	if limits, ok := c.Advertisers[advertiserID]; ok {
+		return limits
+	}
+	return c.Default
+}
+
+// bucket returns the advertiser whose quota an ad of advertiserID counts against. Only the
+// advertisers with an override have a quota of their own: every other ad, with or without an
+// advertiser, shares the default quota under the empty advertiser, so that a client cannot get a
+// fresh quota by sending a new advertiserId.
+func (c QuotaConfig) bucket(advertiserID string) string {
+	if _, ok := c.Advertisers[advertiserID]; ok {
+		return advertiserID
+	}
+	return ""
+}
+
+// overridden returns the advertisers with a quota of their own, sorted.
+func (c QuotaConfig) overridden() []string {
+	ids := make([]string, 0, len(c.Advertisers))
+	for id := range c.Advertisers {
+		if id != "" {
+			ids = append(ids, id)
+		}
+	}
+	slices.Sort(ids)
+	return ids
+}
+
+// quotaLockKey is the Redis key of the lock guarding the quota bucket of an advertiser; the shared
+// default quota uses the plain prefix.
+func quotaLockKey(advertiserID string) string {
+	if advertiserID == "" {
+		return quotaLockPrefix
+	}
+	return quotaLockPrefix + ":" + advertiserID
+}
+
+// dailyQuotaKey is the Redis key of the ad count of an advertiser's quota bucket for the day; the
+// shared default quota keeps using the plain date key.
+func dailyQuotaKey(advertiserID string, today string) string {
+	if advertiserID == "" {
+		return today
+	}
+	return today + ":" + advertiserID
+}
+
 type IAdvertisementService interface {
 	Create(ctx context.Context, ad *models.Advertisement) error
+	CreateWithQuota(ctx context.Context, ad *models.Advertisement, now time.Time) error
+	GetQuota(ctx context.Context, advertiserID string, now time.Time) (*models.QuotaUsage, error)
 	CountActive(ctx context.Context, now time.Time) (int, error)
-	Fetch(ctx context.Context, filter primitive.M, limit, offset int) ([]*models.Advertisement, error)
+	CountCreatedSince(ctx context.Context, since time.Time) (int, error)
+	Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error)
+	GetByID(ctx context.Context, id string) (*models.Advertisement, error)
+	Update(ctx context.Context, id string, ad *models.Advertisement) error
+	UpdateWithQuota(ctx context.Context, id string, ad *models.Advertisement, now time.Time) error
+	Delete(ctx context.Context, id string) error
 	GetByDate(ctx context.Context, today string) (int, error)
 	IncrByDate(ctx context.Context, key string) error
-	GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error)
-	SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error
+	GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error)
+	SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error
+	RefreshAds(ctx context.Context, key string, query models.AdQuery, limit, offset int, maxTTL, staleTTL, fetchTimeout time.Duration) ([]*models.Advertisement, error)
+	ListingTTL(ctx context.Context, query models.AdQuery, maxTTL time.Duration) (time.Duration, error)
 	DeleteAdsByPattern(ctx context.Context, pattern string) error
+	InvalidateAds(ctx context.Context, ads ...*models.Advertisement) error
 	IsAdExpired(ad []*models.Advertisement, now time.Time) bool
 }
 
 type AdvertisementService struct {
 	adRepo      repository.IAdvertisementRepository
 	adRedisRepo repository.IAdRedisRepository
+	quota       QuotaConfig
+	ranking     models.Ranking
+	// refreshes coalesces the concurrent refreshes of a cached list, by key
+	refreshes singleflight.Group
 }
 
-func NewAdvertisementService(adRepo repository.IAdvertisementRepository, adRedisRepo repository.IAdRedisRepository) IAdvertisementService {
+func NewAdvertisementService(adRepo repository.IAdvertisementRepository, adRedisRepo repository.IAdRedisRepository, quota QuotaConfig, ranking models.Ranking) IAdvertisementService {
 	return &AdvertisementService{
 		adRepo:      adRepo,
 		adRedisRepo: adRedisRepo,
+		quota:       quota,
+		ranking:     ranking,
 	}
 }
 
+// Create stores the advertisement with its ranking score.
 func (as *AdvertisementService) Create(ctx context.Context, ad *models.Advertisement) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.Create")
+	defer span.End()
+
+	ad.Score = as.ranking.Score(ad)
 	err := as.adRepo.Create(ctx, ad)
 	if err != nil {
-		return err
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
+// CreateWithQuota creates the advertisement if neither the daily nor the active ad limit of its
+// advertiser's quota is reached; advertisers without an override share the default quota. The
+// checks, the daily counter reservation and the insert run under the quota's lock, shared by all
+// replicas, within quotaCheckTimeout, and the reservation is rolled back if the insert fails.
+func (as *AdvertisementService) CreateWithQuota(ctx context.Context, ad *models.Advertisement, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.CreateWithQuota", trace.WithAttributes(attribute.String("ad.advertiser_id", ad.AdvertiserID)))
+	defer span.End()
+
+	bucket := as.quota.bucket(ad.AdvertiserID)
+	lockKey := quotaLockKey(bucket)
+	token, err := as.acquireQuotaLock(ctx, lockKey)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	defer as.adRedisRepo.ReleaseLock(context.WithoutCancel(ctx), lockKey, token)
+
+	ctx, cancel := context.WithTimeout(ctx, quotaCheckTimeout)
+	defer cancel()
+
+	limits := as.quota.LimitsFor(bucket)
+	dailyKey := dailyQuotaKey(bucket, now.Format("2006-01-02"))
+	dailyAdCount, err := as.adRedisRepo.GetByDate(ctx, dailyKey)
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to get daily ad count: %w", err))
+	}
+	span.SetAttributes(attribute.Int("quota.daily_used", dailyAdCount), attribute.Int("quota.daily_limit", limits.Daily))
+	if dailyAdCount >= limits.Daily {
+		logging.FromContext(ctx).Info("daily ad limit reached", "advertiser_id", ad.AdvertiserID, "limit", limits.Daily)
+		return ErrDailyLimitReached
+	}
+
+	activeAdCount, err := as.countActiveInBucket(ctx, now, bucket)
+	if err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to get active ad count: %w", err))
+	}
+	if activeAdCount >= limits.Active {
+		logging.FromContext(ctx).Info("active ad limit reached", "advertiser_id", ad.AdvertiserID, "limit", limits.Active)
+		return ErrActiveLimitReached
 	}
+
+	// Reserve today's slot before inserting, and give it back if the insert fails
+	if err := as.adRedisRepo.IncrByDate(ctx, dailyKey); err != nil {
+		return tracing.Fail(span, fmt.Errorf("failed to increment ad count: %w", err))
+	}
+	ad.Score = as.ranking.Score(ad)
+	if err := as.adRepo.Create(ctx, ad); err != nil {
+		if rollbackErr := as.adRedisRepo.DecrByDate(context.WithoutCancel(ctx), dailyKey); rollbackErr != nil {
+			logging.FromContext(ctx).Error("failed to roll back daily ad count", "key", dailyKey, "error", rollbackErr)
+			return tracing.Fail(span, errors.Join(err, rollbackErr))
+		}
+		return tracing.Fail(span, err)
+	}
+
 	return nil
 }
 
+// GetQuota returns the current usage and remaining headroom of the advertiser's quota, which is
+// the shared default quota unless the advertiser has an override.
+func (as *AdvertisementService) GetQuota(ctx context.Context, advertiserID string, now time.Time) (*models.QuotaUsage, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.GetQuota", trace.WithAttributes(attribute.String("ad.advertiser_id", advertiserID)))
+	defer span.End()
+
+	bucket := as.quota.bucket(advertiserID)
+	limits := as.quota.LimitsFor(bucket)
+
+	dailyAdCount, err := as.adRedisRepo.GetByDate(ctx, dailyQuotaKey(bucket, now.Format("2006-01-02")))
+	if err != nil {
+		return nil, tracing.Fail(span, fmt.Errorf("failed to get daily ad count: %w", err))
+	}
+
+	activeAdCount, err := as.countActiveInBucket(ctx, now, bucket)
+	if err != nil {
+		return nil, tracing.Fail(span, fmt.Errorf("failed to get active ad count: %w", err))
+	}
+
+	return &models.QuotaUsage{
+		AdvertiserID: advertiserID,
+		Daily:        models.NewQuotaStatus(limits.Daily, dailyAdCount),
+		Active:       models.NewQuotaStatus(limits.Active, activeAdCount),
+	}, nil
+}
+
+// countActiveInBucket counts the active ads of a quota bucket: those of the advertiser with an
+// override, or those of every other advertiser for the shared default quota.
+func (as *AdvertisementService) countActiveInBucket(ctx context.Context, now time.Time, bucket string) (int, error) {
+	if bucket != "" {
+		return as.adRepo.CountActiveByAdvertiser(ctx, now, bucket)
+	}
+	return as.adRepo.CountActiveExcludingAdvertisers(ctx, now, as.quota.overridden())
+}
+
+// acquireQuotaLock takes the quota lock at lockKey, retrying until quotaLockWait elapses, and
+// returns its token.
+func (as *AdvertisementService) acquireQuotaLock(ctx context.Context, lockKey string) (string, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.acquireQuotaLock")
+	defer span.End()
+
+	token, err := newLockToken()
+	if err != nil {
+		return "", tracing.Fail(span, err)
+	}
+
+	deadline := time.Now().Add(quotaLockWait)
+	for {
+		acquired, err := as.adRedisRepo.AcquireLock(ctx, lockKey, token, quotaLockTTL)
+		if err != nil {
+			return "", tracing.Fail(span, err)
+		}
+		if acquired {
+			return token, nil
+		}
+		if time.Now().After(deadline) {
+			logging.FromContext(ctx).Warn("timed out waiting for quota lock", "key", lockKey, "wait", quotaLockWait.String())
+			return "", tracing.Fail(span, ErrQuotaLockTimeout)
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", tracing.Fail(span, ctx.Err())
+		case <-time.After(quotaLockRetry):
+		}
+	}
+}
+
+// newLockToken returns a random token identifying the holder of a lock.
+func newLockToken() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", fmt.Errorf("failed to generate lock token: %w", err)
+	}
+	return hex.EncodeToString(buf), nil
+}
+
 func (as *AdvertisementService) CountActive(ctx context.Context, now time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.CountActive")
+	defer span.End()
+
 	count, err := as.adRepo.CountActive(ctx, now)
 	if err != nil {
-		return 0, err
+		return 0, tracing.Fail(span, err)
 	}
 	return count, nil
 }
 
-func (as *AdvertisementService) Fetch(ctx context.Context, filter primitive.M, limit, offset int) ([]*models.Advertisement, error) {
-	ads, err := as.adRepo.Fetch(ctx, filter, limit, offset)
+// CountCreatedSince returns the count of advertisements created at or after since.
+func (as *AdvertisementService) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.CountCreatedSince")
+	defer span.End()
+
+	count, err := as.adRepo.CountCreatedSince(ctx, since)
 	if err != nil {
-		return nil, err
+		return 0, tracing.Fail(span, err)
+	}
+	return count, nil
+}
+
+// Fetch returns the page of the ads matching query, ranked by priority and score, see
+// models.CompareRank.
+func (as *AdvertisementService) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.Fetch")
+	defer span.End()
+
+	ads, err := as.adRepo.Fetch(ctx, query, limit, offset)
+	if err != nil {
+		return nil, tracing.Fail(span, err)
 	}
 	return ads, nil
 }
 
+// GetByID retrieves a single advertisement by its id.
+func (as *AdvertisementService) GetByID(ctx context.Context, id string) (*models.Advertisement, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.GetByID", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	ad, err := as.adRepo.GetByID(ctx, id)
+	if err != nil {
+		return nil, tracing.Fail(span, err)
+	}
+	return ad, nil
+}
+
+// Update replaces the advertisement with the given id, rescoring it.
+func (as *AdvertisementService) Update(ctx context.Context, id string, ad *models.Advertisement) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.Update", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	ad.Score = as.ranking.Score(ad)
+	err := as.adRepo.Update(ctx, id, ad)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
+// UpdateWithQuota updates the advertisement like Update, checking the active ad limit of its quota
+// when the update makes it active, such as by moving endAt past now on an expired ad, startAt
+// before now on a future one, or opening a schedule window now. An update leaving the ad active runs under the quota's lock, like in
+// CreateWithQuota, where the stored version is read to tell whether the ad already counts, since a
+// version read before taking the lock may have been ended since. The daily limit only counts creates.
+func (as *AdvertisementService) UpdateWithQuota(ctx context.Context, id string, ad *models.Advertisement, now time.Time) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.UpdateWithQuota", trace.WithAttributes(attribute.String("ad.id", id), attribute.String("ad.advertiser_id", ad.AdvertiserID)))
+	defer span.End()
+
+	if !countsAsActive(ad, now) {
+		// The update cannot add an active ad to the quota
+		if err := as.Update(ctx, id, ad); err != nil {
+			return tracing.Fail(span, err)
+		}
+		return nil
+	}
+
+	bucket := as.quota.bucket(ad.AdvertiserID)
+	lockKey := quotaLockKey(bucket)
+	token, err := as.acquireQuotaLock(ctx, lockKey)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	defer as.adRedisRepo.ReleaseLock(context.WithoutCancel(ctx), lockKey, token)
+
+	ctx, cancel := context.WithTimeout(ctx, quotaCheckTimeout)
+	defer cancel()
+
+	stored, err := as.adRepo.GetByID(ctx, id)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	if !countsAsActive(stored, now) || as.quota.bucket(stored.AdvertiserID) != bucket {
+		limits := as.quota.LimitsFor(bucket)
+		activeAdCount, err := as.countActiveInBucket(ctx, now, bucket)
+		if err != nil {
+			return tracing.Fail(span, fmt.Errorf("failed to get active ad count: %w", err))
+		}
+		if activeAdCount >= limits.Active {
+			logging.FromContext(ctx).Info("active ad limit reached", "advertiser_id", ad.AdvertiserID, "limit", limits.Active)
+			return ErrActiveLimitReached
+		}
+	}
+
+	if err := as.Update(ctx, id, ad); err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
+// countsAsActive reports whether the ad counts against the active ad limit at now, like
+// CountActiveByAdvertiser: between its startAt and endAt, in one of its schedule's windows.
+func countsAsActive(ad *models.Advertisement, now time.Time) bool {
+	return !ad.StartAt.After(now) && !ad.EndAt.Before(now) && (ad.Schedule == nil || ad.Schedule.ActiveAt(now))
+}
+
+// Delete removes the advertisement with the given id.
+func (as *AdvertisementService) Delete(ctx context.Context, id string) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.Delete", trace.WithAttributes(attribute.String("ad.id", id)))
+	defer span.End()
+
+	err := as.adRepo.Delete(ctx, id)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
 func (as *AdvertisementService) GetByDate(ctx context.Context, today string) (int, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.GetByDate")
+	defer span.End()
+
 	count, err := as.adRedisRepo.GetByDate(ctx, today)
 	if err != nil {
-		return 0, err
+		return 0, tracing.Fail(span, err)
 	}
 	return count, nil
 }
 
 func (as *AdvertisementService) IncrByDate(ctx context.Context, key string) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.IncrByDate")
+	defer span.End()
+
 	err := as.adRedisRepo.IncrByDate(ctx, key)
 	if err != nil {
-		return err
+		return tracing.Fail(span, err)
 	}
 	return nil
 }
 
-func (as *AdvertisementService) GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error) {
-	ads, err := as.adRedisRepo.GetAdsByKey(ctx, key)
+func (as *AdvertisementService) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.GetAdsByKey", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	cached, err := as.adRedisRepo.GetAdsByKey(ctx, key)
+	if err != nil {
+		return nil, tracing.Fail(span, err)
+	}
+	return cached, nil
+}
+
+// SetAdsByKey caches the ad list at the specified key in Redis.
+func (as *AdvertisementService) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.SetAdsByKey", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	err := as.adRedisRepo.SetAdsByKey(ctx, key, cached, expiration)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
+// RefreshAds fetches the ad list for query and caches it at key, after a cache miss. Concurrent
+// refreshes of the same key share a single MongoDB query: within the process they wait for the
+// first one, and across replicas the first one holds a Redis lock while the others wait for it to
+// fill the cache. The list is fresh for at most maxTTL and kept staleTTL longer, to be served while
+// MongoDB is unavailable. The MongoDB queries fail after fetchTimeout, so that a hanging database
+// does not hold the requests until the driver gives up. Failing to cache the list does not fail
+// the refresh.
+func (as *AdvertisementService) RefreshAds(ctx context.Context, key string, query models.AdQuery, limit, offset int, maxTTL, staleTTL, fetchTimeout time.Duration) ([]*models.Advertisement, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.RefreshAds", trace.WithAttributes(attribute.String("cache.key", key)))
+	defer span.End()
+
+	leader := false
+	result, err, _ := as.refreshes.Do(key, func() (interface{}, error) {
+		leader = true
+		// The requests sharing the refresh must not fail when the one running it is cancelled
+		return as.refreshAds(context.WithoutCancel(ctx), key, query, limit, offset, maxTTL, staleTTL, fetchTimeout)
+	})
+	if !leader {
+		metrics.CacheCoalescedTotal.WithLabelValues(metrics.CoalescedProcess).Inc()
+		span.SetAttributes(attribute.String("cache.coalesced", metrics.CoalescedProcess))
+	}
+	if err != nil {
+		return nil, tracing.Fail(span, err)
+	}
+	return result.([]*models.Advertisement), nil
+}
+
+// refreshAds runs a single refresh of the list at key, unless another replica refreshes it first.
+func (as *AdvertisementService) refreshAds(ctx context.Context, key string, query models.AdQuery, limit, offset int, maxTTL, staleTTL, fetchTimeout time.Duration) ([]*models.Advertisement, error) {
+	lockKey := refreshLockPrefix + key
+	token, ads, err := as.awaitRefresh(ctx, lockKey, key)
+	switch {
+	case err != nil:
+		// Refreshing without the lock only costs a duplicate query
+		logging.FromContext(ctx).Warn("failed to take refresh lock", "key", key, "error", err)
+	case ads != nil:
+		metrics.CacheCoalescedTotal.WithLabelValues(metrics.CoalescedReplica).Inc()
+		trace.SpanFromContext(ctx).SetAttributes(attribute.String("cache.coalesced", metrics.CoalescedReplica))
+		return ads, nil
+	case token == "":
+		logging.FromContext(ctx).Warn("timed out waiting for refresh", "key", key, "wait", refreshLockWait.String())
+	default:
+		defer as.adRedisRepo.ReleaseLock(ctx, lockKey, token)
+	}
+
+	// An ad saved while fetching may be missing from the result, so the result is only cached if no
+	// invalidation happened since before the fetch
+	generation, generationErr := as.adRedisRepo.CacheGeneration(ctx)
+
+	fetchCtx, cancel := context.WithTimeout(ctx, fetchTimeout)
+	defer cancel()
+	ads, err = as.adRepo.Fetch(fetchCtx, query, limit, offset)
 	if err != nil {
 		return nil, err
 	}
+	if generationErr != nil {
+		logging.FromContext(ctx).Warn("failed to cache advertisements", "key", key, "error", generationErr)
+		return ads, nil
+	}
+
+	// Store the result until the next matching ad starts or ends
+	ttl, err := as.ListingTTL(fetchCtx, query, maxTTL)
+	if err != nil {
+		logging.FromContext(ctx).Warn("failed to cache advertisements", "key", key, "error", err)
+		return ads, nil
+	}
+	if ttl > 0 {
+		cached := &models.CachedAds{Ads: ads, FreshUntil: time.Now().Add(ttl)}
+		set, err := as.adRedisRepo.SetAdsByKeyIfGeneration(ctx, key, cached, ttl+staleTTL, generation)
+		if err != nil {
+			logging.FromContext(ctx).Warn("failed to cache advertisements", "key", key, "error", err)
+		} else if !set {
+			logging.FromContext(ctx).Debug("not caching advertisements invalidated while fetching", "key", key)
+		}
+	}
+
 	return ads, nil
 }
 
-// SetAdsByKey sets the advertisements associated with the specified key in Redis.
-func (as *AdvertisementService) SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error {
-	err := as.adRedisRepo.SetAdsByKey(ctx, key, ads, expiration)
+// awaitRefresh takes the refresh lock of the list at key and returns its token, or returns the
+// fresh list another replica cached while holding it. It returns neither after refreshLockWait.
+func (as *AdvertisementService) awaitRefresh(ctx context.Context, lockKey string, key string) (string, []*models.Advertisement, error) {
+	token, err := newLockToken()
 	if err != nil {
-		return err
+		return "", nil, err
 	}
-	return nil
+
+	deadline := time.Now().Add(refreshLockWait)
+	for {
+		// A failed read is a miss, like in ListAdHandler
+		cached, _ := as.adRedisRepo.GetAdsByKey(ctx, key)
+		if now := time.Now(); cached != nil && cached.Fresh(now) && !as.IsAdExpired(cached.Ads, now) {
+			return "", cached.Ads, nil
+		}
+
+		acquired, err := as.adRedisRepo.AcquireLock(ctx, lockKey, token, refreshLockTTL)
+		if err != nil {
+			return "", nil, err
+		}
+		if acquired {
+			return token, nil, nil
+		}
+		if time.Now().After(deadline) {
+			return "", nil, nil
+		}
+
+		select {
+		case <-ctx.Done():
+			return "", nil, ctx.Err()
+		case <-time.After(refreshLockRetry):
+		}
+	}
+}
+
+// ListingTTL returns how long the listing fetched for query stays correct, that is until the next
+// matching ad starts or ends, capped at maxTTL. It returns 0 if that already happened since
+// query.Now, in which case the listing must not be cached.
+func (as *AdvertisementService) ListingTTL(ctx context.Context, query models.AdQuery, maxTTL time.Duration) (time.Duration, error) {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.ListingTTL")
+	defer span.End()
+
+	next, err := as.adRepo.NextChange(ctx, query)
+	if err != nil {
+		return 0, tracing.Fail(span, err)
+	}
+	if next.IsZero() {
+		return maxTTL, nil
+	}
+
+	// Count from the current time, since the entry is written after the listing was fetched
+	ttl := time.Until(next)
+	if ttl > maxTTL {
+		ttl = maxTTL
+	}
+	if ttl < time.Millisecond {
+		// Redis expirations have millisecond precision, and an expiration of 0 never expires
+		return 0, nil
+	}
+	span.SetAttributes(attribute.String("cache.next_change", next.Format(time.RFC3339Nano)))
+	return ttl, nil
 }
 
 // DeleteAdsByPattern deletes the advertisements associated with the specified pattern in Redis.
 func (as *AdvertisementService) DeleteAdsByPattern(ctx context.Context, pattern string) error {
-	err := as.adRedisRepo.DeleteAdsByPattern(ctx, pattern)
+	ctx, span := tracer.Start(ctx, "AdvertisementService.DeleteAdsByPattern", trace.WithAttributes(attribute.String("cache.pattern", pattern)))
+	defer span.End()
+
+	err := as.adRedisRepo.DeleteAdsByPattern(ctx, pattern, nil)
+	if err != nil {
+		return tracing.Fail(span, err)
+	}
+	return nil
+}
+
+// InvalidateAds deletes the cached listings the given ads could appear in, that is those whose
+// query matches the targeting conditions of any of them. Pass both versions of an updated ad.
+// Listings whose key cannot be parsed, such as those cached by an older version, are deleted too.
+func (as *AdvertisementService) InvalidateAds(ctx context.Context, ads ...*models.Advertisement) error {
+	ctx, span := tracer.Start(ctx, "AdvertisementService.InvalidateAds")
+	defer span.End()
+
+	err := as.adRedisRepo.DeleteAdsByPattern(ctx, redis.KeyPrefix+":*", func(key string) bool {
+		params, ok := redis.ParseRedisKey(key)
+		if !ok {
+			// Keeping a listing the ads might appear in could serve it without them
+			return true
+		}
+		// The schedule is ignored, since an ad that has not started yet can start while the listing is cached
+		query := models.NewAdQuery(params, time.Time{})
+		for _, ad := range ads {
+			if query.MatchesConditions(ad.Conditions) {
+				return true
+			}
+		}
+		return false
+	})
 	if err != nil {
-		return err
+		return tracing.Fail(span, err)
 	}
 	return nil
 }
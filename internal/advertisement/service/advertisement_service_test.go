@@ -2,14 +2,21 @@ package service_test
 
 import (
 	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
 	"testing"
 	"time"
 
+	"github.com/prometheus/client_golang/prometheus/testutil"
 	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/mock"
 	"github.com/stretchr/testify/suite"
 	"go.mongodb.org/mongo-driver/bson/primitive"
 
+	"ad-service-api/internal/advertisement/repository"
 	"ad-service-api/internal/advertisement/service"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/models"
 	"ad-service-api/mocks"
 )
@@ -20,19 +27,26 @@ type AdvertisementServiceSuite struct {
 	mockAdRedisRepo *mocks.MockAdRedisRepository
 	s               service.IAdvertisementService
 	ctx             context.Context
+	// reqCtx matches the contexts derived from ctx, which the service wraps in its spans
+	reqCtx interface{}
 }
 
+type suiteCtxKey struct{}
+
 func (suite *AdvertisementServiceSuite) SetupTest() {
 	suite.mockAdRepo = new(mocks.MockAdvertisementRepository)
 	suite.mockAdRedisRepo = new(mocks.MockAdRedisRepository)
-	suite.s = service.NewAdvertisementService(suite.mockAdRepo, suite.mockAdRedisRepo)
-	suite.ctx = context.TODO()
+	suite.s = service.NewAdvertisementService(suite.mockAdRepo, suite.mockAdRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	suite.ctx = context.WithValue(context.TODO(), suiteCtxKey{}, "request")
+	suite.reqCtx = mock.MatchedBy(func(ctx context.Context) bool {
+		return ctx.Value(suiteCtxKey{}) == "request"
+	})
 }
 
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_Create() {
 	ad := &models.Advertisement{}
 
-	suite.mockAdRepo.On("Create", suite.ctx, ad).Return(nil)
+	suite.mockAdRepo.On("Create", suite.reqCtx, ad).Return(nil)
 
 	err := suite.s.Create(suite.ctx, ad)
 
@@ -40,10 +54,111 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_Create() {
 	suite.mockAdRepo.AssertExpectations(suite.T())
 }
 
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_CreateWithQuota() {
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	ad := &models.Advertisement{}
+
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "quota:lock", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).Return(true, nil)
+	suite.mockAdRedisRepo.On("ReleaseLock", mock.Anything, "quota:lock", mock.AnythingOfType("string")).Return(nil)
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, today).Return(1, nil)
+	suite.mockAdRepo.On("CountActiveExcludingAdvertisers", suite.reqCtx, now, []string{}).Return(1, nil)
+	suite.mockAdRedisRepo.On("IncrByDate", suite.reqCtx, today).Return(nil)
+	suite.mockAdRepo.On("Create", suite.reqCtx, ad).Return(nil)
+
+	err := suite.s.CreateWithQuota(suite.ctx, ad, now)
+
+	assert.NoError(suite.T(), err)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_CreateWithQuota_AdvertiserLock() {
+	now := time.Now()
+	dailyKey := now.Format("2006-01-02") + ":acme"
+	ad := &models.Advertisement{AdvertiserID: "acme"}
+	quota := service.DefaultQuotaConfig()
+	quota.Advertisers = map[string]service.QuotaLimits{"acme": {Daily: 10, Active: 10}}
+	suite.s = service.NewAdvertisementService(suite.mockAdRepo, suite.mockAdRedisRepo, quota, models.DefaultRanking())
+	// The insert must give up before the lock expires and lets another replica in
+	insertCtx := mock.MatchedBy(func(ctx context.Context) bool {
+		deadline, ok := ctx.Deadline()
+		return ok && time.Until(deadline) < 10*time.Second
+	})
+
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "quota:lock:acme", mock.AnythingOfType("string"), 10*time.Second).Return(true, nil)
+	suite.mockAdRedisRepo.On("ReleaseLock", mock.Anything, "quota:lock:acme", mock.AnythingOfType("string")).Return(nil)
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, dailyKey).Return(1, nil)
+	suite.mockAdRepo.On("CountActiveByAdvertiser", suite.reqCtx, now, "acme").Return(1, nil)
+	suite.mockAdRedisRepo.On("IncrByDate", suite.reqCtx, dailyKey).Return(nil)
+	suite.mockAdRepo.On("Create", insertCtx, ad).Return(nil)
+
+	err := suite.s.CreateWithQuota(suite.ctx, ad, now)
+
+	assert.NoError(suite.T(), err)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_CreateWithQuota_DailyLimitReached() {
+	now := time.Now()
+	today := now.Format("2006-01-02")
+
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "quota:lock", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).Return(true, nil)
+	suite.mockAdRedisRepo.On("ReleaseLock", mock.Anything, "quota:lock", mock.AnythingOfType("string")).Return(nil)
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, today).Return(service.DefaultDailyAdLimit, nil)
+
+	err := suite.s.CreateWithQuota(suite.ctx, &models.Advertisement{}, now)
+
+	assert.ErrorIs(suite.T(), err, service.ErrDailyLimitReached)
+	suite.mockAdRepo.AssertNotCalled(suite.T(), "Create", mock.Anything, mock.Anything)
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_CreateWithQuota_RollbackOnInsertFailure() {
+	now := time.Now()
+	today := now.Format("2006-01-02")
+	ad := &models.Advertisement{}
+	insertErr := errors.New("insert failed")
+
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "quota:lock", mock.AnythingOfType("string"), mock.AnythingOfType("time.Duration")).Return(true, nil)
+	suite.mockAdRedisRepo.On("ReleaseLock", mock.Anything, "quota:lock", mock.AnythingOfType("string")).Return(nil)
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, today).Return(1, nil)
+	suite.mockAdRepo.On("CountActiveExcludingAdvertisers", suite.reqCtx, now, []string{}).Return(1, nil)
+	suite.mockAdRedisRepo.On("IncrByDate", suite.reqCtx, today).Return(nil)
+	suite.mockAdRepo.On("Create", suite.reqCtx, ad).Return(insertErr)
+	suite.mockAdRedisRepo.On("DecrByDate", mock.Anything, today).Return(nil)
+
+	err := suite.s.CreateWithQuota(suite.ctx, ad, now)
+
+	assert.ErrorIs(suite.T(), err, insertErr)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetQuota() {
+	now := time.Now()
+
+	// acme has no override, so it reports the shared default quota
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, now.Format("2006-01-02")).Return(10, nil)
+	suite.mockAdRepo.On("CountActiveExcludingAdvertisers", suite.reqCtx, now, []string{}).Return(5, nil)
+
+	usage, err := suite.s.GetQuota(suite.ctx, "acme", now)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), &models.QuotaUsage{
+		AdvertiserID: "acme",
+		Daily:        models.QuotaStatus{Limit: service.DefaultDailyAdLimit, Used: 10, Remaining: service.DefaultDailyAdLimit - 10},
+		Active:       models.QuotaStatus{Limit: service.DefaultActiveAdLimit, Used: 5, Remaining: service.DefaultActiveAdLimit - 5},
+	}, usage)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_CountActive() {
 	now := time.Now()
 
-	suite.mockAdRepo.On("CountActive", suite.ctx, now).Return(1, nil)
+	suite.mockAdRepo.On("CountActive", suite.reqCtx, now).Return(1, nil)
 
 	count, err := suite.s.CountActive(suite.ctx, now)
 
@@ -53,23 +168,59 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_CountActive() {
 }
 
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_Fetch() {
-	filter := primitive.M{}
+	query := models.AdQuery{Now: time.Now()}
 	limit := 10
 	offset := 0
 
-	suite.mockAdRepo.On("Fetch", suite.ctx, filter, limit, offset).Return([]*models.Advertisement{}, nil)
+	suite.mockAdRepo.On("Fetch", suite.reqCtx, query, limit, offset).Return([]*models.Advertisement{}, nil)
 
-	ads, err := suite.s.Fetch(suite.ctx, filter, limit, offset)
+	ads, err := suite.s.Fetch(suite.ctx, query, limit, offset)
 
 	assert.NoError(suite.T(), err)
 	assert.NotNil(suite.T(), ads)
 	suite.mockAdRepo.AssertExpectations(suite.T())
 }
 
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetByID() {
+	id := primitive.NewObjectID()
+	ad := &models.Advertisement{ID: id}
+
+	suite.mockAdRepo.On("GetByID", suite.reqCtx, id.Hex()).Return(ad, nil)
+
+	result, err := suite.s.GetByID(suite.ctx, id.Hex())
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ad, result)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_Update() {
+	id := primitive.NewObjectID().Hex()
+	ad := &models.Advertisement{}
+
+	suite.mockAdRepo.On("Update", suite.reqCtx, id, ad).Return(nil)
+
+	err := suite.s.Update(suite.ctx, id, ad)
+
+	assert.NoError(suite.T(), err)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_Delete() {
+	id := primitive.NewObjectID().Hex()
+
+	suite.mockAdRepo.On("Delete", suite.reqCtx, id).Return(nil)
+
+	err := suite.s.Delete(suite.ctx, id)
+
+	assert.NoError(suite.T(), err)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+}
+
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetByDate() {
 	today := "2022-01-01"
 
-	suite.mockAdRedisRepo.On("GetByDate", suite.ctx, today).Return(1, nil)
+	suite.mockAdRedisRepo.On("GetByDate", suite.reqCtx, today).Return(1, nil)
 
 	count, err := suite.s.GetByDate(suite.ctx, today)
 
@@ -81,7 +232,7 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetByDate() {
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_IncrByDate() {
 	key := "2022-01-01"
 
-	suite.mockAdRedisRepo.On("IncrByDate", suite.ctx, key).Return(nil)
+	suite.mockAdRedisRepo.On("IncrByDate", suite.reqCtx, key).Return(nil)
 
 	err := suite.s.IncrByDate(suite.ctx, key)
 
@@ -92,7 +243,7 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_IncrByDate() {
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetAdsByKey() {
 	key := "test"
 
-	suite.mockAdRedisRepo.On("GetAdsByKey", suite.ctx, key).Return([]*models.Advertisement{}, nil)
+	suite.mockAdRedisRepo.On("GetAdsByKey", suite.reqCtx, key).Return(&models.CachedAds{}, nil)
 
 	ads, err := suite.s.GetAdsByKey(suite.ctx, key)
 
@@ -103,21 +254,86 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_GetAdsByKey() {
 
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_SetAdsByKey() {
 	key := "test"
-	ads := []*models.Advertisement{}
+	cached := &models.CachedAds{Ads: []*models.Advertisement{}}
 	expiration := time.Second
 
-	suite.mockAdRedisRepo.On("SetAdsByKey", suite.ctx, key, ads, expiration).Return(nil)
+	suite.mockAdRedisRepo.On("SetAdsByKey", suite.reqCtx, key, cached, expiration).Return(nil)
 
-	err := suite.s.SetAdsByKey(suite.ctx, key, ads, expiration)
+	err := suite.s.SetAdsByKey(suite.ctx, key, cached, expiration)
 
 	assert.NoError(suite.T(), err)
 	suite.mockAdRedisRepo.AssertExpectations(suite.T())
 }
 
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_RefreshAds() {
+	key := "ads:limit:5"
+	query := models.AdQuery{Now: time.Now()}
+	ads := []*models.Advertisement{{Title: "Test Ad"}}
+
+	suite.mockAdRedisRepo.On("GetAdsByKey", suite.reqCtx, key).Return(nil, nil)
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "refresh:"+key, mock.Anything, mock.Anything).Return(true, nil)
+	suite.mockAdRepo.On("Fetch", suite.reqCtx, query, 5, 0).Return(ads, nil)
+	suite.mockAdRepo.On("NextChange", suite.reqCtx, query).Return(time.Time{}, nil)
+	suite.mockAdRedisRepo.On("CacheGeneration", suite.reqCtx).Return(int64(7), nil)
+	// The list is fresh for the maximum TTL, and kept for the stale TTL after it
+	suite.mockAdRedisRepo.On("SetAdsByKeyIfGeneration", suite.reqCtx, key, mock.MatchedBy(func(cached *models.CachedAds) bool {
+		return cached.Fresh(query.Now.Add(59*time.Minute)) && !cached.Fresh(query.Now.Add(61*time.Minute))
+	}), 75*time.Minute, int64(7)).Return(true, nil)
+	suite.mockAdRedisRepo.On("ReleaseLock", suite.reqCtx, "refresh:"+key, mock.Anything).Return(nil)
+
+	result, err := suite.s.RefreshAds(suite.ctx, key, query, 5, 0, time.Hour, 15*time.Minute, time.Second)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ads, result)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_RefreshAds_CacheWriteFailure() {
+	key := "ads:limit:5"
+	query := models.AdQuery{Now: time.Now()}
+	ads := []*models.Advertisement{{Title: "Test Ad"}}
+
+	suite.mockAdRedisRepo.On("GetAdsByKey", suite.reqCtx, key).Return(nil, errors.New("connection refused"))
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "refresh:"+key, mock.Anything, mock.Anything).Return(false, errors.New("connection refused"))
+	suite.mockAdRepo.On("Fetch", suite.reqCtx, query, 5, 0).Return(ads, nil)
+	suite.mockAdRedisRepo.On("CacheGeneration", suite.reqCtx).Return(int64(0), errors.New("connection refused"))
+
+	// Redis being down must not fail a successful MongoDB read
+	result, err := suite.s.RefreshAds(suite.ctx, key, query, 5, 0, time.Hour, 15*time.Minute, time.Second)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ads, result)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertNotCalled(suite.T(), "SetAdsByKeyIfGeneration", mock.Anything, mock.Anything, mock.Anything, mock.Anything, mock.Anything)
+}
+
+func (suite *AdvertisementServiceSuite) TestAdvertisementService_RefreshAds_CacheWriteFailure_AfterGeneration() {
+	key := "ads:limit:5"
+	query := models.AdQuery{Now: time.Now()}
+	ads := []*models.Advertisement{{Title: "Test Ad"}}
+
+	suite.mockAdRedisRepo.On("GetAdsByKey", suite.reqCtx, key).Return(nil, nil)
+	suite.mockAdRedisRepo.On("AcquireLock", suite.reqCtx, "refresh:"+key, mock.Anything, mock.Anything).Return(true, nil)
+	suite.mockAdRedisRepo.On("CacheGeneration", suite.reqCtx).Return(int64(0), nil)
+	suite.mockAdRepo.On("Fetch", suite.reqCtx, query, 5, 0).Return(ads, nil)
+	suite.mockAdRepo.On("NextChange", suite.reqCtx, query).Return(time.Time{}, nil)
+	suite.mockAdRedisRepo.On("SetAdsByKeyIfGeneration", suite.reqCtx, key, mock.Anything, mock.Anything, int64(0)).Return(false, errors.New("connection refused"))
+	suite.mockAdRedisRepo.On("ReleaseLock", suite.reqCtx, "refresh:"+key, mock.Anything).Return(nil)
+
+	result, err := suite.s.RefreshAds(suite.ctx, key, query, 5, 0, time.Hour, 15*time.Minute, time.Second)
+
+	assert.NoError(suite.T(), err)
+	assert.Equal(suite.T(), ads, result)
+	suite.mockAdRepo.AssertExpectations(suite.T())
+	suite.mockAdRedisRepo.AssertExpectations(suite.T())
+}
+
 func (suite *AdvertisementServiceSuite) TestAdvertisementService_DeleteAdsByPattern() {
 	pattern := "test"
 
-	suite.mockAdRedisRepo.On("DeleteAdsByPattern", suite.ctx, pattern).Return(nil)
+	suite.mockAdRedisRepo.On("DeleteAdsByPattern", suite.reqCtx, pattern, mock.Anything).Return(nil)
 
 	err := suite.s.DeleteAdsByPattern(suite.ctx, pattern)
 
@@ -139,3 +355,566 @@ func (suite *AdvertisementServiceSuite) TestAdvertisementService_IsAdExpired() {
 func TestAdvertisementServiceSuite(t *testing.T) {
 	suite.Run(t, new(AdvertisementServiceSuite))
 }
+
+// createConcurrently runs CreateWithQuota for n ads in parallel and returns how many succeeded.
+func createConcurrently(t *testing.T, s service.IAdvertisementService, n int, newAd func() *models.Advertisement, now time.Time) int {
+	var (
+		wg      sync.WaitGroup
+		mu      sync.Mutex
+		created int
+	)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := s.CreateWithQuota(context.Background(), newAd(), now)
+			if err == nil {
+				mu.Lock()
+				created++
+				mu.Unlock()
+				return
+			}
+			if !errors.Is(err, service.ErrDailyLimitReached) && !errors.Is(err, service.ErrActiveLimitReached) {
+				t.Errorf("unexpected error: %v", err)
+			}
+		}()
+	}
+	wg.Wait()
+	return created
+}
+
+func TestAdvertisementService_CreateWithQuota_ActiveLimitUnderConcurrency(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	s := service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	now := time.Now()
+
+	created := createConcurrently(t, s, service.DefaultActiveAdLimit+100, func() *models.Advertisement {
+		return &models.Advertisement{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	}, now)
+
+	activeAdCount, err := adRepo.CountActive(context.Background(), now)
+	assert.NoError(t, err)
+	assert.Equal(t, service.DefaultActiveAdLimit, created)
+	assert.Equal(t, service.DefaultActiveAdLimit, activeAdCount)
+}
+
+func TestAdvertisementService_CreateWithQuota_DailyLimitUnderConcurrency(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	s := service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	now := time.Now()
+
+	// Ads starting tomorrow do not count as active, so only the daily limit applies
+	created := createConcurrently(t, s, service.DefaultDailyAdLimit+100, func() *models.Advertisement {
+		return &models.Advertisement{StartAt: now.Add(24 * time.Hour), EndAt: now.Add(48 * time.Hour)}
+	}, now)
+
+	dailyAdCount, err := adRedisRepo.GetByDate(context.Background(), now.Format("2006-01-02"))
+	assert.NoError(t, err)
+	assert.Equal(t, service.DefaultDailyAdLimit, created)
+	assert.Equal(t, service.DefaultDailyAdLimit, dailyAdCount)
+}
+
+func TestAdvertisementService_CreateWithQuota_AdvertiserOverride(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	quota := service.DefaultQuotaConfig()
+	quota.Advertisers = map[string]service.QuotaLimits{"acme": {Daily: 2, Active: 2}}
+	s := service.NewAdvertisementService(adRepo, adRedisRepo, quota, models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	newAd := func(advertiserID string) *models.Advertisement {
+		return &models.Advertisement{AdvertiserID: advertiserID, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	}
+
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("acme"), now))
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("acme"), now))
+	assert.ErrorIs(t, s.CreateWithQuota(ctx, newAd("acme"), now), service.ErrDailyLimitReached)
+
+	// Other advertisers share the default limits
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("other"), now))
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd(""), now))
+
+	usage, err := s.GetQuota(ctx, "acme", now)
+	assert.NoError(t, err)
+	assert.Equal(t, models.QuotaStatus{Limit: 2, Used: 2, Remaining: 0}, usage.Daily)
+	assert.Equal(t, models.QuotaStatus{Limit: 2, Used: 2, Remaining: 0}, usage.Active)
+
+	usage, err = s.GetQuota(ctx, "another", now)
+	assert.NoError(t, err)
+	assert.Equal(t, models.QuotaStatus{Limit: service.DefaultDailyAdLimit, Used: 2, Remaining: service.DefaultDailyAdLimit - 2}, usage.Daily)
+	assert.Equal(t, models.QuotaStatus{Limit: service.DefaultActiveAdLimit, Used: 2, Remaining: service.DefaultActiveAdLimit - 2}, usage.Active)
+}
+
+func TestAdvertisementService_CreateWithQuota_RotatingAdvertisers(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	quota := service.QuotaConfig{
+		Default:     service.QuotaLimits{Daily: 100, Active: 3},
+		Advertisers: map[string]service.QuotaLimits{"acme": {Daily: 100, Active: 1}},
+	}
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), quota, models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	newAd := func(advertiserID string) *models.Advertisement {
+		return &models.Advertisement{AdvertiserID: advertiserID, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	}
+
+	// A new advertiserId does not come with a fresh quota
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("a"), now))
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("b"), now))
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd(""), now))
+	assert.ErrorIs(t, s.CreateWithQuota(ctx, newAd("c"), now), service.ErrActiveLimitReached)
+
+	// The ads of an advertiser with an override do not use up the shared quota
+	assert.NoError(t, s.CreateWithQuota(ctx, newAd("acme"), now))
+	assert.ErrorIs(t, s.CreateWithQuota(ctx, newAd("acme"), now), service.ErrActiveLimitReached)
+}
+
+func TestAdvertisementService_CreateWithQuota_AdvertiserLocksAreIndependent(t *testing.T) {
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	quota := service.DefaultQuotaConfig()
+	quota.Advertisers = map[string]service.QuotaLimits{"acme": {Daily: 10, Active: 10}, "beta": {Daily: 10, Active: 10}}
+	s := service.NewAdvertisementService(repository.NewMemoryAdvertisementRepository(), adRedisRepo, quota, models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	// Another replica is creating an ad for acme
+	acquired, err := adRedisRepo.AcquireLock(ctx, "quota:lock:acme", "other-replica", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	start := time.Now()
+	assert.NoError(t, s.CreateWithQuota(ctx, &models.Advertisement{AdvertiserID: "beta", StartAt: now, EndAt: now.Add(time.Hour)}, now))
+	assert.Less(t, time.Since(start), time.Second, "expected beta not to wait for acme's lock")
+}
+
+func TestAdvertisementService_InvalidateAds(t *testing.T) {
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	s := service.NewAdvertisementService(repository.NewMemoryAdvertisementRepository(), adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+
+	cachedKeys := []string{
+		"ads:limit:5:offset:0",
+		"ads:country:TW:limit:5:offset:0",
+		"ads:country:JP:limit:5:offset:0",
+		"ads:age:25:limit:5:offset:0",
+		"ads:age:50:limit:5:offset:0",
+		"ads:gender:M:platform:ios",
+		"ads:country:JP,TW:limit:5:offset:0",
+		"ads:country!:TW:limit:5:offset:0",
+		"ads:country!:JP:limit:5:offset:0",
+		// Keys in an older format cannot be parsed, so they are always invalidated
+		"ads:TW",
+	}
+	for _, key := range cachedKeys {
+		assert.NoError(t, adRedisRepo.SetAdsByKey(ctx, key, &models.CachedAds{}, time.Hour))
+	}
+	assert.NoError(t, adRedisRepo.IncrByDate(ctx, "2024-01-01"))
+
+	err := s.InvalidateAds(ctx, &models.Advertisement{Conditions: models.Conditions{
+		AgeStart: 20, AgeEnd: 30, Country: []string{"TW"}, Gender: []string{"F"},
+	}})
+	assert.NoError(t, err)
+
+	remaining := []string{}
+	for _, key := range cachedKeys {
+		ads, err := adRedisRepo.GetAdsByKey(ctx, key)
+		assert.NoError(t, err)
+		if ads != nil {
+			remaining = append(remaining, key)
+		}
+	}
+	assert.Equal(t, []string{
+		"ads:country:JP:limit:5:offset:0",
+		"ads:age:50:limit:5:offset:0",
+		"ads:gender:M:platform:ios",
+		"ads:country!:TW:limit:5:offset:0",
+	}, remaining, "expected only the lists the ad matches to be invalidated")
+
+	count, err := adRedisRepo.GetByDate(ctx, "2024-01-01")
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "expected the quota counters to be kept")
+}
+
+func TestAdvertisementService_ListingTTL(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	ttl, err := s.ListingTTL(ctx, models.AdQuery{Now: now}, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Hour, ttl, "expected the maximum TTL without upcoming ads")
+
+	assert.NoError(t, adRepo.Create(ctx, &models.Advertisement{StartAt: now.Add(10 * time.Minute), EndAt: now.Add(2 * time.Hour)}))
+
+	ttl, err = s.ListingTTL(ctx, models.AdQuery{Now: now}, time.Hour)
+	assert.NoError(t, err)
+	assert.InDelta(t, 10*time.Minute, ttl, float64(time.Second), "expected the listing to expire when the ad starts")
+
+	ttl, err = s.ListingTTL(ctx, models.AdQuery{Now: now}, time.Minute)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Minute, ttl, "expected the TTL to be capped")
+
+	// An ad started after the listing was fetched
+	assert.NoError(t, adRepo.Create(ctx, &models.Advertisement{StartAt: now.Add(-time.Minute), EndAt: now.Add(2 * time.Hour)}))
+
+	ttl, err = s.ListingTTL(ctx, models.AdQuery{Now: now.Add(-30 * time.Minute)}, time.Hour)
+	assert.NoError(t, err)
+	assert.Equal(t, time.Duration(0), ttl, "expected a stale listing not to be cached")
+}
+
+func TestAdvertisementService_Ranking(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	ads := []*models.Advertisement{
+		{Title: "no bid", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)},
+		{Title: "low bid", Bid: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(3 * time.Hour)},
+		{Title: "high bid", Bid: 5, StartAt: now.Add(-time.Hour), EndAt: now.Add(4 * time.Hour)},
+		{Title: "house ad", Priority: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(5 * time.Hour)},
+		// Tied with the low bid, and ending sooner
+		{Title: "low bid ending sooner", Bid: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(2 * time.Hour)},
+	}
+	for _, ad := range ads {
+		assert.NoError(t, s.Create(ctx, ad))
+	}
+	want := []string{"house ad", "high bid", "low bid ending sooner", "low bid", "no bid"}
+
+	titles := func(ads []*models.Advertisement) []string {
+		titles := make([]string, 0, len(ads))
+		for _, ad := range ads {
+			titles = append(titles, ad.Title)
+		}
+		return titles
+	}
+
+	ranked, err := s.Fetch(ctx, models.AdQuery{Now: now}, 10, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, want, titles(ranked))
+	assert.Equal(t, 5.0, ranked[1].Score)
+
+	page, err := s.Fetch(ctx, models.AdQuery{Now: now}, 2, 2)
+	assert.NoError(t, err)
+	assert.Equal(t, want[2:4], titles(page), "expected the offset to page through the ranking")
+
+	// Paging with cursors returns every ad once, in the same order
+	var paged []string
+	query := models.AdQuery{Now: now}
+	for {
+		page, err := s.Fetch(ctx, query, 2, 0)
+		assert.NoError(t, err)
+		paged = append(paged, titles(page)...)
+		if len(page) < 2 {
+			break
+		}
+		cursor, err := models.DecodeCursor(models.NewAdPage(page, 2).NextCursor)
+		assert.NoError(t, err)
+		query.After = &cursor
+	}
+	assert.Equal(t, want, paged)
+}
+
+func TestAdvertisementService_ListingTTL_Cursor(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	// The house ad ranks first although it ends last, and the high bid starts in ten minutes. Sorted
+	// by end alone, the high bid would come before the house ad's cursor.
+	for _, ad := range []*models.Advertisement{
+		{Title: "house ad", Priority: 1, StartAt: now.Add(-time.Hour), EndAt: now.Add(10 * time.Hour)},
+		{Title: "no bid", StartAt: now.Add(-time.Hour), EndAt: now.Add(9 * time.Hour)},
+		{Title: "high bid", Bid: 100, StartAt: now.Add(10 * time.Minute), EndAt: now.Add(8 * time.Hour)},
+	} {
+		assert.NoError(t, s.Create(ctx, ad))
+	}
+
+	query := models.AdQuery{Now: now}
+	page, err := s.Fetch(ctx, query, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "house ad", page[0].Title)
+
+	ttl, err := s.ListingTTL(ctx, query, time.Hour)
+	assert.NoError(t, err)
+	assert.InDelta(t, 10*time.Minute, ttl, float64(time.Second))
+
+	// The high bid will rank after the house ad and before the ad listed now, so the second page
+	// changes when it starts
+	cursor := models.CursorAfter(page[0])
+	query.After = &cursor
+	page, err = s.Fetch(ctx, query, 1, 0)
+	assert.NoError(t, err)
+	assert.Equal(t, "no bid", page[0].Title)
+
+	ttl, err = s.ListingTTL(ctx, query, time.Hour)
+	assert.NoError(t, err)
+	assert.InDelta(t, 10*time.Minute, ttl, float64(time.Second))
+}
+
+// countingAdRepository counts the Fetch queries per query and makes them slow enough for
+// concurrent requests to pile up behind them.
+type countingAdRepository struct {
+	repository.IAdvertisementRepository
+	mu      sync.Mutex
+	fetches map[string]int
+}
+
+func (r *countingAdRepository) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	r.mu.Lock()
+	r.fetches[query.Country[0]]++
+	r.mu.Unlock()
+
+	time.Sleep(50 * time.Millisecond)
+	return r.IAdvertisementRepository.Fetch(ctx, query, limit, offset)
+}
+
+func coalescedRequests() float64 {
+	return testutil.ToFloat64(metrics.CacheCoalescedTotal.WithLabelValues(metrics.CoalescedProcess)) +
+		testutil.ToFloat64(metrics.CacheCoalescedTotal.WithLabelValues(metrics.CoalescedReplica))
+}
+
+// TestAdvertisementService_RefreshAds_UnderLoad sends concurrent cache misses for a few keys to two
+// replicas sharing the same storage and cache, and checks MongoDB sees one query per key per refresh.
+func TestAdvertisementService_RefreshAds_UnderLoad(t *testing.T) {
+	const requestsPerKey = 100
+	countries := []string{"TW", "JP", "US"}
+
+	adRepo := &countingAdRepository{IAdvertisementRepository: repository.NewMemoryAdvertisementRepository(), fetches: map[string]int{}}
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	replicas := []service.IAdvertisementService{
+		service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking()),
+		service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking()),
+	}
+	ctx := context.Background()
+	now := time.Now()
+	for _, country := range countries {
+		assert.NoError(t, adRepo.Create(ctx, &models.Advertisement{
+			Title: country, StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour), Conditions: models.Conditions{Country: []string{country}},
+		}))
+	}
+
+	refreshAll := func() {
+		var (
+			wg     sync.WaitGroup
+			failed atomic.Int32
+		)
+		for _, s := range replicas {
+			for _, country := range countries {
+				for i := 0; i < requestsPerKey; i++ {
+					wg.Add(1)
+					go func(s service.IAdvertisementService, country string) {
+						defer wg.Done()
+						key := "ads:country:" + country
+						query := models.AdQuery{Country: []string{country}, Now: time.Now()}
+						ads, err := s.RefreshAds(ctx, key, query, 5, 0, time.Hour, time.Minute, time.Second)
+						if err != nil || len(ads) != 1 || ads[0].Title != country {
+							failed.Add(1)
+						}
+					}(s, country)
+				}
+			}
+		}
+		wg.Wait()
+		assert.Zero(t, failed.Load(), "expected every request to get its list")
+	}
+
+	coalesced := coalescedRequests()
+	refreshAll()
+	for _, country := range countries {
+		assert.Equal(t, 1, adRepo.fetches[country], "expected one query for %s", country)
+	}
+	total := len(replicas) * len(countries) * requestsPerKey
+	assert.Equal(t, float64(total-len(countries)), coalescedRequests()-coalesced, "expected every other request to be coalesced")
+
+	// Refresh again once the cached lists are gone
+	assert.NoError(t, replicas[0].DeleteAdsByPattern(ctx, "ads:*"))
+	refreshAll()
+	for _, country := range countries {
+		assert.Equal(t, 2, adRepo.fetches[country], "expected one query for %s per refresh", country)
+	}
+}
+
+func TestAdvertisementService_RefreshAds_ChangedWhileFetching(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	s := service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	// The ad starts after the listing is fetched, so the listing must not be cached
+	assert.NoError(t, adRepo.Create(ctx, &models.Advertisement{StartAt: now.Add(-time.Minute), EndAt: now.Add(time.Hour)}))
+
+	ads, err := s.RefreshAds(ctx, "ads:limit:5", models.AdQuery{Now: now.Add(-time.Hour)}, 5, 0, time.Hour, time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, ads)
+
+	cached, err := adRedisRepo.GetAdsByKey(ctx, "ads:limit:5")
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+}
+
+// hangingAdRepository answers Fetch only when its context is done, like MongoDB when it hangs.
+type hangingAdRepository struct {
+	repository.IAdvertisementRepository
+}
+
+func (r hangingAdRepository) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	<-ctx.Done()
+	return nil, ctx.Err()
+}
+
+func TestAdvertisementService_RefreshAds_FetchTimeout(t *testing.T) {
+	adRepo := hangingAdRepository{repository.NewMemoryAdvertisementRepository()}
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), service.DefaultQuotaConfig(), models.DefaultRanking())
+
+	// The request is never cancelled, only the refresh times out
+	start := time.Now()
+	ads, err := s.RefreshAds(context.Background(), "ads:limit:5", models.AdQuery{Now: start}, 5, 0, time.Hour, time.Minute, 50*time.Millisecond)
+	assert.ErrorIs(t, err, context.DeadlineExceeded)
+	assert.Nil(t, ads)
+	assert.Less(t, time.Since(start), time.Second, "expected the refresh to give up after the fetch timeout")
+}
+
+// interleavingAdRepository runs afterFetch once its Fetch has read the ads, like a request saving an
+// ad while the listing is being fetched.
+type interleavingAdRepository struct {
+	repository.IAdvertisementRepository
+	afterFetch func()
+}
+
+func (r interleavingAdRepository) Fetch(ctx context.Context, query models.AdQuery, limit, offset int) ([]*models.Advertisement, error) {
+	ads, err := r.IAdvertisementRepository.Fetch(ctx, query, limit, offset)
+	r.afterFetch()
+	return ads, err
+}
+
+func TestAdvertisementService_RefreshAds_InvalidatedWhileFetching(t *testing.T) {
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	memoryRepo := repository.NewMemoryAdvertisementRepository()
+	ctx := context.Background()
+	now := time.Now()
+
+	var s service.IAdvertisementService
+	adRepo := interleavingAdRepository{IAdvertisementRepository: memoryRepo, afterFetch: func() {
+		ad := &models.Advertisement{Title: "New Ad", StartAt: now.Add(-time.Minute), EndAt: now.Add(time.Hour)}
+		assert.NoError(t, s.Create(ctx, ad))
+		assert.NoError(t, s.InvalidateAds(ctx, ad))
+	}}
+	s = service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+
+	ads, err := s.RefreshAds(ctx, "ads:limit:5", models.AdQuery{Now: now}, 5, 0, time.Hour, time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.Empty(t, ads)
+
+	// The listing fetched before the invalidation misses the new ad, so it must not be cached
+	cached, err := adRedisRepo.GetAdsByKey(ctx, "ads:limit:5")
+	assert.NoError(t, err)
+	assert.Nil(t, cached)
+
+	// The next refresh sees the new ad and caches it
+	adRepo.afterFetch = func() {}
+	s = service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	ads, err = s.RefreshAds(ctx, "ads:limit:5", models.AdQuery{Now: now}, 5, 0, time.Hour, time.Minute, time.Second)
+	assert.NoError(t, err)
+	assert.Len(t, ads, 1)
+
+	cached, err = adRedisRepo.GetAdsByKey(ctx, "ads:limit:5")
+	assert.NoError(t, err)
+	assert.NotNil(t, cached)
+}
+
+func TestAdvertisementService_UpdateWithQuota(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	quota := service.QuotaConfig{Default: service.QuotaLimits{Daily: 100, Active: 1}}
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), quota, models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	active := &models.Advertisement{Title: "Active Ad", StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}
+	expired := &models.Advertisement{Title: "Expired Ad", StartAt: now.Add(-2 * time.Hour), EndAt: now.Add(-time.Hour)}
+	future := &models.Advertisement{Title: "Future Ad", StartAt: now.Add(time.Hour), EndAt: now.Add(2 * time.Hour)}
+	for _, ad := range []*models.Advertisement{active, expired, future} {
+		assert.NoError(t, adRepo.Create(ctx, ad))
+	}
+
+	// The active ad limit is reached, so neither ad can be made active
+	reactivated := *expired
+	reactivated.EndAt = now.Add(time.Hour)
+	assert.ErrorIs(t, s.UpdateWithQuota(ctx, expired.ID.Hex(), &reactivated, now), service.ErrActiveLimitReached)
+
+	started := *future
+	started.StartAt = now.Add(-time.Minute)
+	assert.ErrorIs(t, s.UpdateWithQuota(ctx, future.ID.Hex(), &started, now), service.ErrActiveLimitReached)
+
+	count, err := adRepo.CountActive(ctx, now)
+	assert.NoError(t, err)
+	assert.Equal(t, 1, count, "expected the rejected updates not to be stored")
+
+	// Updating an ad that already counts as active does not need headroom
+	renamed := *active
+	renamed.Title = "Renamed Ad"
+	renamed.EndAt = now.Add(2 * time.Hour)
+	assert.NoError(t, s.UpdateWithQuota(ctx, active.ID.Hex(), &renamed, now))
+
+	// Once the active ad ends, the future ad can start now
+	ended := renamed
+	ended.EndAt = now.Add(-time.Minute)
+	assert.NoError(t, s.UpdateWithQuota(ctx, active.ID.Hex(), &ended, now))
+	assert.NoError(t, s.UpdateWithQuota(ctx, future.ID.Hex(), &started, now))
+
+	stored, err := adRepo.GetByID(ctx, future.ID.Hex())
+	assert.NoError(t, err)
+	assert.Equal(t, started.StartAt.UnixMilli(), stored.StartAt.UnixMilli())
+
+	// An ad read while active, but ended by another request since, is checked again
+	assert.ErrorIs(t, s.UpdateWithQuota(ctx, active.ID.Hex(), &renamed, now), service.ErrActiveLimitReached)
+}
+
+func TestAdvertisementService_UpdateWithQuota_Inactive(t *testing.T) {
+	adRedisRepo := repository.NewMemoryAdRedisRepository()
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	s := service.NewAdvertisementService(adRepo, adRedisRepo, service.DefaultQuotaConfig(), models.DefaultRanking())
+	ctx := context.Background()
+	now := time.Now()
+
+	ad := &models.Advertisement{StartAt: now.Add(time.Hour), EndAt: now.Add(2 * time.Hour)}
+	assert.NoError(t, adRepo.Create(ctx, ad))
+
+	// Another replica is creating an ad
+	acquired, err := adRedisRepo.AcquireLock(ctx, "quota:lock", "other-replica", time.Minute)
+	assert.NoError(t, err)
+	assert.True(t, acquired)
+
+	start := time.Now()
+	updated := *ad
+	updated.Title = "Updated Ad"
+	assert.NoError(t, s.UpdateWithQuota(ctx, ad.ID.Hex(), &updated, now))
+	assert.Less(t, time.Since(start), time.Second, "expected an update leaving the ad inactive not to wait for the quota lock")
+}
+
+func TestAdvertisementService_UpdateWithQuota_Schedule(t *testing.T) {
+	adRepo := repository.NewMemoryAdvertisementRepository()
+	quota := service.QuotaConfig{Default: service.QuotaLimits{Daily: 100, Active: 1}}
+	s := service.NewAdvertisementService(adRepo, repository.NewMemoryAdRedisRepository(), quota, models.DefaultRanking())
+	ctx := context.Background()
+	// Friday 10:00 in Taipei
+	now := time.Date(2026, time.October, 16, 2, 0, 0, 0, time.UTC)
+	weekends := &models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"sat", "sun"}, Start: "00:00", End: "24:00"}}}
+
+	// An ad outside its windows does not count, so another one can be created
+	scheduled := &models.Advertisement{StartAt: now.Add(-time.Hour), EndAt: now.Add(30 * 24 * time.Hour), Schedule: weekends}
+	assert.NoError(t, s.CreateWithQuota(ctx, scheduled, now))
+	assert.NoError(t, s.CreateWithQuota(ctx, &models.Advertisement{StartAt: now.Add(-time.Hour), EndAt: now.Add(time.Hour)}, now))
+
+	// Dropping the schedule runs the ad now, past the limit
+	unscheduled := *scheduled
+	unscheduled.Schedule = nil
+	assert.ErrorIs(t, s.UpdateWithQuota(ctx, scheduled.ID.Hex(), &unscheduled, now), service.ErrActiveLimitReached)
+}
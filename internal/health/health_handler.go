@@ -0,0 +1,113 @@
+package health
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Checker reports whether a dependency is reachable.
+type Checker func(ctx context.Context) error
+
+// DependencyStatus is the readiness of a single dependency.
+type DependencyStatus struct {
+	Status string `json:"status"`
+	Error  string `json:"error,omitempty"`
+}
+
+// ReadinessResponse is the body returned by the readiness endpoint.
+type ReadinessResponse struct {
+	Status       string                      `json:"status"`
+	Dependencies map[string]DependencyStatus `json:"dependencies"`
+}
+
+// HealthHandler serves the liveness and readiness endpoints.
+type HealthHandler struct {
+	checks map[string]Checker
+	// optional names the checks whose failure degrades the service without making it unready
+	optional map[string]bool
+	timeout  time.Duration
+}
+
+// NewHealthHandler creates a HealthHandler whose checks each time out after timeout.
+func NewHealthHandler(timeout time.Duration) *HealthHandler {
+	return &HealthHandler{
+		checks:   make(map[string]Checker),
+		optional: make(map[string]bool),
+		timeout:  timeout,
+	}
+}
+
+// AddCheck registers a dependency that must be reachable for the service to be ready.
+func (h *HealthHandler) AddCheck(name string, check Checker) {
+	h.checks[name] = check
+}
+
+// AddOptionalCheck registers a dependency the service can do without for a while, such as the
+// database behind a cache that keeps serving stale entries. While it is unreachable the service
+// stays ready and is reported as degraded.
+func (h *HealthHandler) AddOptionalCheck(name string, check Checker) {
+	h.AddCheck(name, check)
+	h.optional[name] = true
+}
+
+// LivenessHandler reports that the process is alive
+// @Summary Liveness probe
+// @Description Always returns 200 while the process can serve requests
+// @ID healthz
+// @Produce  json
+// @Success 200 {object} map[string]string
+// @Router /healthz [get]
+func (h *HealthHandler) LivenessHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, gin.H{"status": "ok"})
+}
+
+// ReadinessHandler checks every dependency concurrently
+// @Summary Readiness probe
+// @Description Pings MongoDB and Redis and reports the status of each dependency. An unreachable MongoDB only degrades the service, since the listing is served from the cache.
+// @ID readyz
+// @Produce  json
+// @Success 200 {object} health.ReadinessResponse
+// @Failure 503 {object} health.ReadinessResponse
+// @Router /readyz [get]
+func (h *HealthHandler) ReadinessHandler(c *gin.Context) {
+	ctx, cancel := context.WithTimeout(c.Request.Context(), h.timeout)
+	defer cancel()
+
+	var (
+		wg sync.WaitGroup
+		mu sync.Mutex
+	)
+	response := ReadinessResponse{Status: "ok", Dependencies: make(map[string]DependencyStatus, len(h.checks))}
+	for name, check := range h.checks {
+		wg.Add(1)
+		go func(name string, check Checker) {
+			defer wg.Done()
+			status := DependencyStatus{Status: "ok"}
+			if err := check(ctx); err != nil {
+				status = DependencyStatus{Status: "unavailable", Error: err.Error()}
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			response.Dependencies[name] = status
+			switch {
+			case status.Status == "ok":
+			case !h.optional[name]:
+				response.Status = "unavailable"
+			case response.Status == "ok":
+				response.Status = "degraded"
+			}
+		}(name, check)
+	}
+	wg.Wait()
+
+	if response.Status == "unavailable" {
+		c.JSON(http.StatusServiceUnavailable, response)
+		return
+	}
+	c.JSON(http.StatusOK, response)
+}
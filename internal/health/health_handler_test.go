@@ -0,0 +1,102 @@
+package health_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/health"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+)
+
+func serveReadiness(h *health.HealthHandler) (*httptest.ResponseRecorder, health.ReadinessResponse) {
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/readyz", nil)
+
+	h.ReadinessHandler(c)
+
+	var response health.ReadinessResponse
+	json.NewDecoder(w.Body).Decode(&response)
+	return w, response
+}
+
+func TestHealthHandler_LivenessHandler(t *testing.T) {
+	h := health.NewHealthHandler(time.Second)
+
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request, _ = http.NewRequest(http.MethodGet, "/healthz", nil)
+
+	h.LivenessHandler(c)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+}
+
+func TestHealthHandler_ReadinessHandler(t *testing.T) {
+	h := health.NewHealthHandler(time.Second)
+	h.AddCheck("mongo", func(ctx context.Context) error { return nil })
+	h.AddCheck("redis", func(ctx context.Context) error { return nil })
+
+	w, response := serveReadiness(h)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, health.ReadinessResponse{
+		Status: "ok",
+		Dependencies: map[string]health.DependencyStatus{
+			"mongo": {Status: "ok"},
+			"redis": {Status: "ok"},
+		},
+	}, response)
+}
+
+func TestHealthHandler_ReadinessHandler_Unavailable(t *testing.T) {
+	h := health.NewHealthHandler(50 * time.Millisecond)
+	h.AddCheck("mongo", func(ctx context.Context) error { return errors.New("connection refused") })
+	h.AddCheck("redis", func(ctx context.Context) error {
+		// A hanging dependency is cut off by the check timeout
+		<-ctx.Done()
+		return ctx.Err()
+	})
+
+	w, response := serveReadiness(h)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "unavailable", response.Status)
+	assert.Equal(t, health.DependencyStatus{Status: "unavailable", Error: "connection refused"}, response.Dependencies["mongo"])
+	assert.Equal(t, health.DependencyStatus{Status: "unavailable", Error: context.DeadlineExceeded.Error()}, response.Dependencies["redis"])
+}
+
+func TestHealthHandler_ReadinessHandler_Degraded(t *testing.T) {
+	h := health.NewHealthHandler(time.Second)
+	h.AddOptionalCheck("mongo", func(ctx context.Context) error { return errors.New("connection refused") })
+	h.AddCheck("redis", func(ctx context.Context) error { return nil })
+
+	w, response := serveReadiness(h)
+
+	assert.Equal(t, http.StatusOK, w.Code)
+	assert.Equal(t, health.ReadinessResponse{
+		Status: "degraded",
+		Dependencies: map[string]health.DependencyStatus{
+			"mongo": {Status: "unavailable", Error: "connection refused"},
+			"redis": {Status: "ok"},
+		},
+	}, response)
+}
+
+func TestHealthHandler_ReadinessHandler_DegradedAndUnavailable(t *testing.T) {
+	h := health.NewHealthHandler(time.Second)
+	h.AddOptionalCheck("mongo", func(ctx context.Context) error { return errors.New("connection refused") })
+	h.AddCheck("redis", func(ctx context.Context) error { return errors.New("connection refused") })
+
+	w, response := serveReadiness(h)
+
+	assert.Equal(t, http.StatusServiceUnavailable, w.Code)
+	assert.Equal(t, "unavailable", response.Status)
+}
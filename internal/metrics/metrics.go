@@ -0,0 +1,136 @@
+package metrics
+
+import (
+	"context"
+	"log/slog"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promauto"
+)
+
+const namespace = "adservice"
+
+// Cache lookup results recorded by ListAdHandler.
+const (
+	CacheHit   = "hit"
+	CacheMiss  = "miss"
+	CacheStale = "stale"
+)
+
+// Ways a request for an ad list was served by a refresh it did not run, recorded by
+// AdvertisementService.RefreshAds.
+const (
+	// CoalescedProcess waited for the refresh of the same list running in the same process.
+	CoalescedProcess = "process"
+	// CoalescedReplica read the list another replica cached while holding its refresh lock.
+	CoalescedReplica = "replica"
+)
+
+var (
+	// HTTPRequestsTotal counts the handled requests per route and status code.
+	HTTPRequestsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "http_requests_total",
+		Help:      "Number of HTTP requests handled, by method, route and status code.",
+	}, []string{"method", "route", "status"})
+
+	// HTTPRequestDuration observes the request latency per route.
+	HTTPRequestDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "http_request_duration_seconds",
+		Help:      "Latency of HTTP requests, by method and route.",
+		Buckets:   prometheus.DefBuckets,
+	}, []string{"method", "route"})
+
+	// CacheLookupsTotal counts the ad list cache lookups by result (hit, miss or stale).
+	CacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_lookups_total",
+		Help:      "Number of ad list cache lookups, by result (hit, miss, stale).",
+	}, []string{"result"})
+
+	// LocalCacheLookupsTotal counts the lookups of the in-process ad list cache by result (hit or miss).
+	LocalCacheLookupsTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "local_cache_lookups_total",
+		Help:      "Number of ad list lookups in the in-process cache in front of Redis, by result (hit, miss).",
+	}, []string{"result"})
+
+	// CacheCoalescedTotal counts the ad list requests that shared a refresh instead of querying MongoDB.
+	CacheCoalescedTotal = promauto.NewCounterVec(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_coalesced_requests_total",
+		Help:      "Number of ad list cache misses served by a refresh run by another request, by scope (process, replica).",
+	}, []string{"scope"})
+
+	// StaleResponsesTotal counts the ad lists served stale because they could not be refreshed.
+	StaleResponsesTotal = promauto.NewCounter(prometheus.CounterOpts{
+		Namespace: namespace,
+		Name:      "cache_stale_responses_total",
+		Help:      "Number of ad lists served stale from the cache because MongoDB could not be queried.",
+	})
+
+	// MongoQueryDuration observes the latency of MongoDB queries per operation.
+	MongoQueryDuration = promauto.NewHistogramVec(prometheus.HistogramOpts{
+		Namespace: namespace,
+		Name:      "mongo_query_duration_seconds",
+		Help:      "Latency of MongoDB queries, by operation.",
+		Buckets:   []float64{.001, .0025, .005, .01, .025, .05, .1, .25, .5, 1, 2.5},
+	}, []string{"operation"})
+)
+
+// scrapeTimeout bounds the queries an AdCountCollector runs on each scrape.
+const scrapeTimeout = 5 * time.Second
+
+// AdCounter counts the advertisements reported by AdCountCollector.
+type AdCounter interface {
+	CountActive(ctx context.Context, now time.Time) (int, error)
+	CountCreatedSince(ctx context.Context, since time.Time) (int, error)
+}
+
+// AdCountCollector reports the number of ads created today and the number of active ads.
+// The counts are read from the storage on every scrape, so all replicas report the same values.
+type AdCountCollector struct {
+	counter     AdCounter
+	createdDesc *prometheus.Desc
+	activeDesc  *prometheus.Desc
+}
+
+// NewAdCountCollector creates an AdCountCollector reading the counts from counter.
+func NewAdCountCollector(counter AdCounter) *AdCountCollector {
+	return &AdCountCollector{
+		counter:     counter,
+		createdDesc: prometheus.NewDesc(namespace+"_ads_created_today", "Number of ads created since midnight.", nil, nil),
+		activeDesc:  prometheus.NewDesc(namespace+"_ads_active", "Number of ads currently active.", nil, nil),
+	}
+}
+
+// Describe implements prometheus.Collector.
+func (c *AdCountCollector) Describe(ch chan<- *prometheus.Desc) {
+	ch <- c.createdDesc
+	ch <- c.activeDesc
+}
+
+// Collect implements prometheus.Collector. A count that cannot be read is left out of the scrape.
+func (c *AdCountCollector) Collect(ch chan<- prometheus.Metric) {
+	ctx, cancel := context.WithTimeout(context.Background(), scrapeTimeout)
+	defer cancel()
+
+	now := time.Now()
+	midnight := time.Date(now.Year(), now.Month(), now.Day(), 0, 0, 0, 0, now.Location())
+
+	created, err := c.counter.CountCreatedSince(ctx, midnight)
+	if err != nil {
+		slog.Error("failed to collect created ads count", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.createdDesc, prometheus.GaugeValue, float64(created))
+	}
+
+	active, err := c.counter.CountActive(ctx, now)
+	if err != nil {
+		slog.Error("failed to collect active ads count", "error", err)
+	} else {
+		ch <- prometheus.MustNewConstMetric(c.activeDesc, prometheus.GaugeValue, float64(active))
+	}
+}
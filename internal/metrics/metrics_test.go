@@ -0,0 +1,47 @@
+package metrics_test
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/metrics"
+
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeAdCounter struct {
+	created, active int
+	err             error
+}
+
+func (f fakeAdCounter) CountActive(ctx context.Context, now time.Time) (int, error) {
+	return f.active, nil
+}
+
+func (f fakeAdCounter) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	return f.created, f.err
+}
+
+func TestAdCountCollector(t *testing.T) {
+	collector := metrics.NewAdCountCollector(fakeAdCounter{created: 12, active: 7})
+
+	err := testutil.CollectAndCompare(collector, strings.NewReader(`
+# HELP adservice_ads_active Number of ads currently active.
+# TYPE adservice_ads_active gauge
+adservice_ads_active 7
+# HELP adservice_ads_created_today Number of ads created since midnight.
+# TYPE adservice_ads_created_today gauge
+adservice_ads_created_today 12
+`))
+	assert.Nil(t, err)
+}
+
+func TestAdCountCollector_SkipsFailedCount(t *testing.T) {
+	collector := metrics.NewAdCountCollector(fakeAdCounter{active: 7, err: errors.New("connection refused")})
+
+	assert.Equal(t, 1, testutil.CollectAndCount(collector))
+}
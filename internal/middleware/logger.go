@@ -1,23 +1,43 @@
 package middleware
 
 import (
-	"log"
+	"ad-service-api/internal/logging"
+	"log/slog"
+	"net/http"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
+// Logger logs one line per request once it is served. It runs after RequestID, so the line
+// carries the request id.
 func Logger() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		t := time.Now()
 
-		// Before request
-		log.Printf("Started %s %s", c.Request.Method, c.Request.RequestURI)
+		c.Next()
 
-		c.Next() // 处理请求
-
-		// After request
-		latency := time.Since(t)
-		log.Printf("Completed in %v", latency)
+		status := c.Writer.Status()
+		level := slog.LevelInfo
+		if status >= 500 {
+			level = slog.LevelError
+		}
+		logging.FromContext(c.Request.Context()).LogAttrs(c.Request.Context(), level, "request completed",
+			slog.String("method", c.Request.Method),
+			slog.String("path", c.Request.URL.Path),
+			slog.String("route", c.FullPath()),
+			slog.Int("status", status),
+			slog.Float64("latency_ms", float64(time.Since(t).Microseconds())/1000),
+			slog.String("client_ip", c.ClientIP()),
+			slog.Int("bytes", c.Writer.Size()),
+		)
 	}
 }
+
+// Recovery turns a panic into a 500 response and logs it with the request id.
+func Recovery() gin.HandlerFunc {
+	return gin.CustomRecoveryWithWriter(nil, func(c *gin.Context, err any) {
+		logging.FromContext(c.Request.Context()).Error("panic recovered", "error", err)
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+}
@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"ad-service-api/internal/logging"
+	"crypto/rand"
+	"encoding/hex"
+	"log/slog"
+	"regexp"
+
+	"github.com/gin-gonic/gin"
+	"go.opentelemetry.io/otel/trace"
+)
+
+// RequestIDHeader carries the id that correlates the logs of a request.
+const RequestIDHeader = "X-Request-ID"
+
+// validRequestID limits the client supplied ids that are trusted, so that logs cannot be forged.
+var validRequestID = regexp.MustCompile(`^[A-Za-z0-9._-]{1,128}$`)
+
+// RequestID takes the request id from the X-Request-ID header, or generates one, and echoes it
+// in the response. The request context then carries logger with the id, and the trace id when the
+// request is traced, for logging.FromContext to return to handlers, services and repositories.
+func RequestID(logger *slog.Logger) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		id := c.GetHeader(RequestIDHeader)
+		if !validRequestID.MatchString(id) {
+			id = newRequestID()
+		}
+		c.Header(RequestIDHeader, id)
+
+		requestLogger := logger.With("request_id", id)
+		if span := trace.SpanContextFromContext(c.Request.Context()); span.IsValid() {
+			requestLogger = requestLogger.With("trace_id", span.TraceID().String())
+		}
+		ctx := logging.WithLogger(c.Request.Context(), requestLogger)
+		c.Request = c.Request.WithContext(ctx)
+
+		c.Next()
+	}
+}
+
+// newRequestID returns a random 128-bit id in hex.
+func newRequestID() string {
+	buf := make([]byte, 16)
+	rand.Read(buf)
+	return hex.EncodeToString(buf)
+}
@@ -0,0 +1,85 @@
+package middleware_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"log/slog"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"ad-service-api/internal/logging"
+	"ad-service-api/internal/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+// newTestRouter serves /ad with a handler that logs through the request's logger.
+func newTestRouter(buf *bytes.Buffer) *gin.Engine {
+	r := gin.New()
+	r.ContextWithFallback = true
+	r.Use(middleware.RequestID(logging.New(buf, slog.LevelInfo)), middleware.Logger())
+	r.GET("/ad", func(c *gin.Context) {
+		logging.FromContext(c).Info("handler called")
+		c.Status(http.StatusOK)
+	})
+	return r
+}
+
+// decodeLines returns the JSON log lines written to buf.
+func decodeLines(t *testing.T, buf *bytes.Buffer) []map[string]any {
+	var lines []map[string]any
+	dec := json.NewDecoder(buf)
+	for dec.More() {
+		var line map[string]any
+		require.NoError(t, dec.Decode(&line))
+		lines = append(lines, line)
+	}
+	return lines
+}
+
+func TestRequestID(t *testing.T) {
+	tests := []struct {
+		name     string
+		header   string
+		wantEcho bool
+	}{
+		{"accepts the client id", "req-123", true},
+		{"generates a missing id", "", false},
+		{"replaces an invalid id", "bad id\n{}", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var buf bytes.Buffer
+			r := newTestRouter(&buf)
+
+			w := httptest.NewRecorder()
+			req, _ := http.NewRequest(http.MethodGet, "/ad", nil)
+			if tt.header != "" {
+				req.Header.Set(middleware.RequestIDHeader, tt.header)
+			}
+			r.ServeHTTP(w, req)
+
+			id := w.Header().Get(middleware.RequestIDHeader)
+			if tt.wantEcho {
+				assert.Equal(t, tt.header, id)
+			} else {
+				assert.Len(t, id, 32)
+			}
+
+			// Both the handler's line and the access log line carry the id
+			lines := decodeLines(t, &buf)
+			require.Len(t, lines, 2)
+			assert.Equal(t, "handler called", lines[0]["msg"])
+			assert.Equal(t, "request completed", lines[1]["msg"])
+			for _, line := range lines {
+				assert.Equal(t, id, line["request_id"])
+			}
+			assert.Equal(t, float64(http.StatusOK), lines[1]["status"])
+			assert.Equal(t, "/ad", lines[1]["route"])
+		})
+	}
+}
@@ -1,14 +1,33 @@
 package models
 
 import (
+	"cmp"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
 )
 
 type Advertisement struct {
-	Title      string     `json:"title" bson:"title"`
-	StartAt    time.Time  `json:"startAt" bson:"startAt"`
-	EndAt      time.Time  `json:"endAt" bson:"endAt"`
-	Conditions Conditions `json:"conditions,omitempty" bson:"conditions,omitempty"`
+	ID           primitive.ObjectID `json:"id,omitempty" bson:"_id,omitempty"`
+	AdvertiserID string             `json:"advertiserId,omitempty" bson:"advertiserId,omitempty"`
+	Title        string             `json:"title" bson:"title"`
+	StartAt      time.Time          `json:"startAt" bson:"startAt"`
+	EndAt        time.Time          `json:"endAt" bson:"endAt"`
+	Conditions   Conditions         `json:"conditions,omitempty" bson:"conditions,omitempty"`
+	// Schedule, when set, only runs the ad in its weekly windows between StartAt and EndAt
+	Schedule *Schedule `json:"schedule,omitempty" bson:"schedule,omitempty"`
+	// Priority ranks the ad above every ad of a lower priority, whatever their scores
+	Priority int `json:"priority,omitempty" bson:"priority"`
+	// Bid is what the advertiser pays per thousand impressions, and Budget what remains of its spend
+	Bid    float64 `json:"bid,omitempty" bson:"bid,omitempty"`
+	Budget float64 `json:"budget,omitempty" bson:"budget,omitempty"`
+	// Score ranks the ad within its priority; the service computes it from the bid, the budget and the start when the ad is saved
+	Score float64 `json:"score,omitempty" bson:"score"`
 }
 
 type Conditions struct {
@@ -17,4 +36,278 @@ type Conditions struct {
 	Gender   []string `json:"gender,omitempty" bson:"gender,omitempty"`
 	Country  []string `json:"country,omitempty" bson:"country,omitempty"`
 	Platform []string `json:"platform,omitempty" bson:"platform,omitempty"`
+	// ExcludeCountry and ExcludePlatform are never shown the ad, even when Country or Platform is empty
+	ExcludeCountry  []string `json:"excludeCountry,omitempty" bson:"excludeCountry,omitempty"`
+	ExcludePlatform []string `json:"excludePlatform,omitempty" bson:"excludePlatform,omitempty"`
+	// Language holds BCP 47 tags, each also matching the more specific tags under it, e.g. zh matches zh-TW
+	Language []string `json:"language,omitempty" bson:"language,omitempty"`
+	// Region holds ISO 3166-2 subdivision codes, such as TW-TPE
+	Region     []string      `json:"region,omitempty" bson:"region,omitempty"`
+	OSVersion  *VersionRange `json:"osVersion,omitempty" bson:"osVersion,omitempty"`
+	AppVersion *VersionRange `json:"appVersion,omitempty" bson:"appVersion,omitempty"`
+}
+
+// AdvertisementPatch holds the fields of a partial update, with the semantics of a JSON merge patch
+// (RFC 7396): absent fields are left unchanged, and the optional fields set to null are cleared.
+type AdvertisementPatch struct {
+	Title      *string     `json:"title,omitempty"`
+	StartAt    *time.Time  `json:"startAt,omitempty"`
+	EndAt      *time.Time  `json:"endAt,omitempty"`
+	Conditions *Conditions `json:"conditions,omitempty"`
+	Schedule   *Schedule   `json:"schedule,omitempty"`
+	Priority   *int        `json:"priority,omitempty"`
+	Bid        *float64    `json:"bid,omitempty"`
+	Budget     *float64    `json:"budget,omitempty"`
+	// cleared holds the JSON names of the fields set to null, which decode to nil like absent ones
+	cleared map[string]bool
+}
+
+// requiredPatchFields are the fields every advertisement has, which a patch cannot clear.
+var requiredPatchFields = map[string]bool{"title": true, "startAt": true, "endAt": true}
+
+// UnmarshalJSON decodes the patch, recording which fields are set to null.
+func (p *AdvertisementPatch) UnmarshalJSON(data []byte) error {
+	// patchFields has the fields of AdvertisementPatch without its UnmarshalJSON method
+	type patchFields AdvertisementPatch
+	var fields patchFields
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return err
+	}
+	var raw map[string]json.RawMessage
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+
+	*p = AdvertisementPatch(fields)
+	p.cleared = nil
+	for name, value := range raw {
+		if string(value) == "null" {
+			if requiredPatchFields[name] {
+				return fmt.Errorf("%s cannot be null", name)
+			}
+			if p.cleared == nil {
+				p.cleared = make(map[string]bool)
+			}
+			p.cleared[name] = true
+		}
+	}
+	return nil
+}
+
+// Apply copies the non-nil fields of the patch onto the advertisement, and resets the fields set to
+// null to their zero value.
+func (p AdvertisementPatch) Apply(ad *Advertisement) {
+	if p.Title != nil {
+		ad.Title = *p.Title
+	}
+	if p.StartAt != nil {
+		ad.StartAt = *p.StartAt
+	}
+	if p.EndAt != nil {
+		ad.EndAt = *p.EndAt
+	}
+	if p.Conditions != nil || p.cleared["conditions"] {
+		ad.Conditions = deref(p.Conditions)
+	}
+	if p.Schedule != nil || p.cleared["schedule"] {
+		ad.Schedule = p.Schedule
+	}
+	if p.Priority != nil || p.cleared["priority"] {
+		ad.Priority = deref(p.Priority)
+	}
+	if p.Bid != nil || p.cleared["bid"] {
+		ad.Bid = deref(p.Bid)
+	}
+	if p.Budget != nil || p.cleared["budget"] {
+		ad.Budget = deref(p.Budget)
+	}
+}
+
+// deref returns the value v points to, or the zero value when v is nil.
+func deref[T any](v *T) T {
+	if v == nil {
+		var zero T
+		return zero
+	}
+	return *v
+}
+
+// CompareRank orders ads as the listing returns them: by Priority and then Score, highest first,
+// then soonest ending first, the id breaking the remaining ties so that every ad has a unique rank.
+// It is the order of database.ListingSort.
+func CompareRank(a, b *Advertisement) int {
+	if c := cmp.Compare(b.Priority, a.Priority); c != 0 {
+		return c
+	}
+	if c := cmp.Compare(b.Score, a.Score); c != 0 {
+		return c
+	}
+	if c := a.EndAt.Compare(b.EndAt); c != 0 {
+		return c
+	}
+	return strings.Compare(a.ID.Hex(), b.ID.Hex())
+}
+
+// AdPage is a page of the ad listing. NextCursor fetches the following page, and is left out
+// when the page is not full, since the listing has no more ads.
+type AdPage struct {
+	Ads        []*Advertisement `json:"ads"`
+	NextCursor string           `json:"nextCursor,omitempty"`
+}
+
+// NewAdPage returns the page holding ads, fetched limit at a time.
+func NewAdPage(ads []*Advertisement, limit int) AdPage {
+	page := AdPage{Ads: ads}
+	if len(ads) > 0 && len(ads) == limit {
+		page.NextCursor = CursorAfter(ads[len(ads)-1]).Encode()
+	}
+	return page
+}
+
+// Listing query parameters hold several values joined by ValueSeparator, and a parameter named
+// after a dimension followed by NotSuffix, such as country!, excludes its values.
+const (
+	ValueSeparator = ","
+	NotSuffix      = "!"
+)
+
+// AdQuery describes which ads a listing should return, independent of the storage backend.
+// Empty fields do not restrict the result.
+type AdQuery struct {
+	Now time.Time
+	Age int
+	// Gender, Country and Platform match the ads shown to any of their values, and NotGender,
+	// NotCountry and NotPlatform those shown to any value but theirs
+	Gender      []string
+	Country     []string
+	Platform    []string
+	NotGender   []string
+	NotCountry  []string
+	NotPlatform []string
+	// Language matches the ads for any of its tags or their parents, Region and NotRegion work like Country
+	Language   []string
+	Region     []string
+	NotRegion  []string
+	OSVersion  *Version
+	AppVersion *Version
+	// After, when set, skips the ads sorted up to and including this position
+	After *Cursor
+}
+
+// NewAdQuery builds an AdQuery from the validated listing query parameters.
+func NewAdQuery(validQueryParams map[string]string, now time.Time) AdQuery {
+	values := func(param string) []string {
+		if v, ok := validQueryParams[param]; ok {
+			return strings.Split(v, ValueSeparator)
+		}
+		return nil
+	}
+
+	query := AdQuery{
+		Now:         now,
+		Gender:      values("gender"),
+		Country:     values("country"),
+		Platform:    values("platform"),
+		NotGender:   values("gender" + NotSuffix),
+		NotCountry:  values("country" + NotSuffix),
+		NotPlatform: values("platform" + NotSuffix),
+		Language:    values("language"),
+		Region:      values("region"),
+		NotRegion:   values("region" + NotSuffix),
+	}
+	if age, ok := validQueryParams["age"]; ok {
+		query.Age, _ = strconv.Atoi(age)
+	}
+	if osVersion, ok := validQueryParams["osVersion"]; ok {
+		if v, err := ParseVersion(osVersion); err == nil {
+			query.OSVersion = &v
+		}
+	}
+	if appVersion, ok := validQueryParams["appVersion"]; ok {
+		if v, err := ParseVersion(appVersion); err == nil {
+			query.AppVersion = &v
+		}
+	}
+	if cursor, ok := validQueryParams["cursor"]; ok {
+		if after, err := DecodeCursor(cursor); err == nil {
+			query.After = &after
+		}
+	}
+	return query
+}
+
+// MatchesConditions reports whether an ad with the given targeting conditions matches the query,
+// regardless of its schedule. It mirrors database.CreateFilter: a condition left empty on the
+// ad matches every value, and an empty query value matches every ad.
+func (q AdQuery) MatchesConditions(c Conditions) bool {
+	if q.Age != 0 {
+		if c.AgeStart != 0 && c.AgeStart > q.Age {
+			return false
+		}
+		if c.AgeEnd != 0 && c.AgeEnd < q.Age {
+			return false
+		}
+	}
+
+	if q.OSVersion != nil && c.OSVersion != nil && !c.OSVersion.Contains(*q.OSVersion) {
+		return false
+	}
+	if q.AppVersion != nil && c.AppVersion != nil && !c.AppVersion.Contains(*q.AppVersion) {
+		return false
+	}
+
+	return matchesOrUnset(c.Gender, nil, q.Gender) && matchesOtherOrUnset(c.Gender, q.NotGender) &&
+		matchesOrUnset(c.Country, c.ExcludeCountry, q.Country) && matchesOtherOrUnset(c.Country, q.NotCountry) &&
+		matchesOrUnset(c.Platform, c.ExcludePlatform, q.Platform) && matchesOtherOrUnset(c.Platform, q.NotPlatform) &&
+		matchesOrUnset(c.Language, nil, LanguageMatches(q.Language)) &&
+		matchesOrUnset(c.Region, nil, q.Region) && matchesOtherOrUnset(c.Region, q.NotRegion)
+}
+
+// LanguageMatches returns the tags an ad may target to be shown to the speakers of tags: the tags
+// themselves and their parents, found by dropping their last subtag, e.g. zh-Hant-TW, zh-Hant and
+// zh for zh-Hant-TW.
+func LanguageMatches(tags []string) []string {
+	var matches []string
+	for _, tag := range tags {
+		for {
+			if !slices.Contains(matches, tag) {
+				matches = append(matches, tag)
+			}
+			i := strings.LastIndex(tag, "-")
+			if i < 0 {
+				break
+			}
+			tag = tag[:i]
+		}
+	}
+	return matches
+}
+
+// matchesOrUnset reports whether any of wanted is in values and not in excluded, treating an empty
+// wanted or values as a wildcard.
+func matchesOrUnset(values, excluded []string, wanted []string) bool {
+	if len(wanted) == 0 {
+		return true
+	}
+	for _, w := range wanted {
+		if (len(values) == 0 || slices.Contains(values, w)) && !slices.Contains(excluded, w) {
+			return true
+		}
+	}
+	return false
+}
+
+// matchesOtherOrUnset reports whether values contains a value that is not excluded, treating an
+// empty excluded or values as a wildcard. The exclusions of the ad are not checked: an ad without
+// values is assumed to leave some value other than excluded to its viewers.
+func matchesOtherOrUnset(values []string, excluded []string) bool {
+	if len(excluded) == 0 || len(values) == 0 {
+		return true
+	}
+	for _, v := range values {
+		if !slices.Contains(excluded, v) {
+			return true
+		}
+	}
+	return false
 }
@@ -0,0 +1,79 @@
+package models_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+)
+
+func TestAdvertisementPatch_Apply(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newAd := func() *models.Advertisement {
+		return &models.Advertisement{
+			Title:      "Test Ad",
+			StartAt:    now,
+			EndAt:      now.Add(24 * time.Hour),
+			Conditions: models.Conditions{Country: []string{"TW"}},
+			Schedule:   &models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{{Start: "18:00", End: "23:00"}}},
+			Priority:   2,
+			Bid:        1.5,
+		}
+	}
+
+	tests := []struct {
+		name  string
+		patch string
+		want  func(ad *models.Advertisement)
+	}{
+		{"absent fields are unchanged", `{}`, func(ad *models.Advertisement) {}},
+		{"set fields", `{"title": "Patched Ad", "priority": 0}`, func(ad *models.Advertisement) {
+			ad.Title = "Patched Ad"
+			ad.Priority = 0
+		}},
+		{"null clears the schedule", `{"schedule": null}`, func(ad *models.Advertisement) {
+			ad.Schedule = nil
+		}},
+		{"null clears optional fields", `{"conditions": null, "priority": null, "bid": null}`, func(ad *models.Advertisement) {
+			ad.Conditions = models.Conditions{}
+			ad.Priority = 0
+			ad.Bid = 0
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var patch models.AdvertisementPatch
+			require.NoError(t, json.Unmarshal([]byte(tt.patch), &patch))
+
+			ad := newAd()
+			patch.Apply(ad)
+
+			want := newAd()
+			tt.want(want)
+			assert.Equal(t, want, ad)
+		})
+	}
+}
+
+func TestAdvertisementPatch_UnmarshalJSON_RequiredField(t *testing.T) {
+	for _, field := range []string{"title", "startAt", "endAt"} {
+		var patch models.AdvertisementPatch
+		assert.EqualError(t, json.Unmarshal([]byte(`{"`+field+`": null}`), &patch), field+" cannot be null")
+	}
+}
+
+func TestAdvertisementPatch_UnmarshalJSON_Reused(t *testing.T) {
+	var patch models.AdvertisementPatch
+	require.NoError(t, json.Unmarshal([]byte(`{"schedule": null}`), &patch))
+	require.NoError(t, json.Unmarshal([]byte(`{"title": "Patched Ad"}`), &patch))
+
+	// A null from an earlier decode does not leak into the next one
+	schedule := &models.Schedule{Timezone: "UTC"}
+	ad := &models.Advertisement{Schedule: schedule}
+	patch.Apply(ad)
+	assert.Same(t, schedule, ad.Schedule)
+}
@@ -0,0 +1,15 @@
+package models
+
+import "time"
+
+// CachedAds is an ad list cached at a GenerateRedisKey key. It is served as is until FreshUntil,
+// and may be kept longer to be served, stale, while the database is unavailable.
+type CachedAds struct {
+	Ads        []*Advertisement `json:"ads"`
+	FreshUntil time.Time        `json:"freshUntil"`
+}
+
+// Fresh reports whether the list can still be served without refreshing it.
+func (c *CachedAds) Fresh(now time.Time) bool {
+	return now.Before(c.FreshUntil)
+}
@@ -0,0 +1,80 @@
+package models
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// Cursor is a position in the listing, which sorts the ads by priority, score, endAt and then id,
+// see CompareRank. A page fetched after a cursor starts with the first ad sorted after it, so ads
+// created or removed meanwhile never shift the pages that follow.
+type Cursor struct {
+	Priority int
+	Score    float64
+	EndAt    time.Time
+	ID       primitive.ObjectID
+}
+
+// CursorAfter returns the cursor positioned on ad, from which the next page is fetched.
+func CursorAfter(ad *Advertisement) Cursor {
+	return Cursor{Priority: ad.Priority, Score: ad.Score, EndAt: ad.EndAt, ID: ad.ID}
+}
+
+// Encode returns the cursor as an opaque URL safe string. The score is written as the bits of the
+// float, so that it decodes to the exact value it is compared with.
+func (c Cursor) Encode() string {
+	raw := strings.Join([]string{
+		strconv.FormatInt(c.EndAt.UnixNano(), 10),
+		c.ID.Hex(),
+		strconv.Itoa(c.Priority),
+		strconv.FormatUint(math.Float64bits(c.Score), 16),
+	}, ".")
+	return base64.RawURLEncoding.EncodeToString([]byte(raw))
+}
+
+// DecodeCursor parses a cursor returned by Encode. Cursors holding only the end and the id, from
+// before the listing was ranked, are rejected: they would place the next page after every ranked ad.
+func DecodeCursor(s string) (Cursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	parts := strings.Split(string(raw), ".")
+	if len(parts) != 4 {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	objectID, err := primitive.ObjectIDFromHex(parts[1])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	priority, err := strconv.Atoi(parts[2])
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	bits, err := strconv.ParseUint(parts[3], 16, 64)
+	if err != nil {
+		return Cursor{}, fmt.Errorf("invalid cursor: %w", err)
+	}
+	score := math.Float64frombits(bits)
+	if math.IsNaN(score) {
+		return Cursor{}, errors.New("invalid cursor")
+	}
+	return Cursor{Priority: priority, Score: score, EndAt: time.Unix(0, nanos).UTC(), ID: objectID}, nil
+}
+
+// Precedes reports whether ad is sorted after the cursor, see CompareRank.
+func (c Cursor) Precedes(ad *Advertisement) bool {
+	position := Advertisement{ID: c.ID, EndAt: c.EndAt, Priority: c.Priority, Score: c.Score}
+	return CompareRank(&position, ad) < 0
+}
@@ -0,0 +1,47 @@
+package models_test
+
+import (
+	"encoding/base64"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestCursor_Encode(t *testing.T) {
+	c := models.Cursor{Priority: 2, Score: 0.1 + 0.2, EndAt: time.Date(2026, time.October, 18, 12, 0, 0, 1, time.UTC), ID: primitive.NewObjectID()}
+
+	decoded, err := models.DecodeCursor(c.Encode())
+	require.NoError(t, err)
+	assert.Equal(t, c, decoded, "expected the score to decode to the exact same float")
+
+	// Cursors from before the listing was ranked hold the end and the id only
+	legacy := base64.RawURLEncoding.EncodeToString([]byte("1760788800000000000." + c.ID.Hex()))
+	for _, invalid := range []string{"not base64!", base64.RawURLEncoding.EncodeToString([]byte("1.2.3")), legacy} {
+		_, err := models.DecodeCursor(invalid)
+		assert.Error(t, err, invalid)
+	}
+}
+
+func TestCompareRank(t *testing.T) {
+	now := time.Now()
+	first, second := primitive.NewObjectID(), primitive.NewObjectID()
+
+	ranked := []*models.Advertisement{
+		{Title: "priority", Priority: 1, Score: 0, EndAt: now.Add(time.Hour), ID: first},
+		{Title: "score", Score: 2, EndAt: now.Add(time.Hour), ID: first},
+		{Title: "ends sooner", Score: 1, EndAt: now.Add(time.Minute), ID: second},
+		{Title: "lower id", Score: 1, EndAt: now.Add(time.Hour), ID: first},
+		{Title: "higher id", Score: 1, EndAt: now.Add(time.Hour), ID: second},
+	}
+	for i := 1; i < len(ranked); i++ {
+		assert.Negative(t, models.CompareRank(ranked[i-1], ranked[i]), "%s before %s", ranked[i-1].Title, ranked[i].Title)
+		assert.True(t, models.CursorAfter(ranked[i-1]).Precedes(ranked[i]))
+		assert.False(t, models.CursorAfter(ranked[i]).Precedes(ranked[i-1]))
+	}
+	assert.False(t, models.CursorAfter(ranked[0]).Precedes(ranked[0]), "expected an ad not to follow itself")
+}
@@ -0,0 +1,24 @@
+package models
+
+// QuotaUsage reports the current usage of an advertiser's ad quotas.
+type QuotaUsage struct {
+	AdvertiserID string      `json:"advertiserId,omitempty"`
+	Daily        QuotaStatus `json:"daily"`
+	Active       QuotaStatus `json:"active"`
+}
+
+// QuotaStatus is the usage of a single quota.
+type QuotaStatus struct {
+	Limit     int `json:"limit"`
+	Used      int `json:"used"`
+	Remaining int `json:"remaining"`
+}
+
+// NewQuotaStatus builds a QuotaStatus, clamping the remaining headroom at zero.
+func NewQuotaStatus(limit, used int) QuotaStatus {
+	remaining := limit - used
+	if remaining < 0 {
+		remaining = 0
+	}
+	return QuotaStatus{Limit: limit, Used: used, Remaining: remaining}
+}
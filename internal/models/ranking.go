@@ -0,0 +1,27 @@
+package models
+
+// MillisPerDay converts the milliseconds of a BSON date into days.
+const MillisPerDay = 24 * 60 * 60 * 1000
+
+// Ranking weighs the terms of the score that ranks the listed ads within their priority. The
+// score is stored with the ad when it is saved, so that the listing is sorted by the database.
+type Ranking struct {
+	BidWeight    float64
+	BudgetWeight float64
+	// RecencyWeight raises the score of an ad by this much for each day it starts later
+	RecencyWeight float64
+}
+
+// DefaultRanking returns a Ranking that ranks the ads by bid.
+func DefaultRanking() Ranking {
+	return Ranking{BidWeight: 1}
+}
+
+// Score returns the ranking score of the advertisement. Recency counts the days from the Unix
+// epoch to StartAt rather than the age of the ad, so that stored scores do not change as time
+// passes. It matches database.ScoreExpression: StartAt is taken to the millisecond like a BSON
+// date, and the conversions keep each product rounded rather than fused into the sums.
+func (r Ranking) Score(ad *Advertisement) float64 {
+	recency := float64(ad.StartAt.UnixMilli()) / MillisPerDay
+	return float64(r.BidWeight*ad.Bid) + float64(r.BudgetWeight*ad.Budget) + float64(r.RecencyWeight*recency)
+}
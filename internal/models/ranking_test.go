@@ -0,0 +1,23 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRanking_Score(t *testing.T) {
+	ranking := models.Ranking{BidWeight: 2, BudgetWeight: 0.5, RecencyWeight: 1}
+	start := time.Date(2026, time.October, 1, 0, 0, 0, 0, time.UTC)
+
+	older := &models.Advertisement{Bid: 3, Budget: 10, StartAt: start}
+	newer := &models.Advertisement{Bid: 3, Budget: 10, StartAt: start.Add(36 * time.Hour)}
+	// 2*3 + 0.5*10, plus the days from the Unix epoch to the start
+	assert.Equal(t, 11+float64(start.Unix())/(24*60*60), ranking.Score(older))
+	assert.InDelta(t, 1.5, ranking.Score(newer)-ranking.Score(older), 1e-6, "expected recency to weigh by the day")
+
+	assert.Equal(t, 3.0, models.DefaultRanking().Score(older), "expected the default ranking to score the bid alone")
+}
@@ -0,0 +1,168 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"time"
+	// Embed the IANA timezone database, which the runtime image does not ship
+	_ "time/tzdata"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+const (
+	minutesPerDay  = 24 * 60
+	minutesPerWeek = 7 * minutesPerDay
+)
+
+// weekdays are the values of Window.Days, in the order of the ISO week, which starts on Monday.
+var weekdays = []string{"mon", "tue", "wed", "thu", "fri", "sat", "sun"}
+
+// Schedule restricts an ad to weekly windows of local time, dayparting it within StartAt and EndAt.
+type Schedule struct {
+	// Timezone is the IANA name of the timezone the windows are in, such as Asia/Taipei
+	Timezone string   `json:"timezone" bson:"timezone"`
+	Windows  []Window `json:"windows" bson:"windows"`
+}
+
+// Window is a daily period of a Schedule, from Start up to but excluding End, both given as HH:MM.
+// An End not after Start runs past midnight into the next day, and 24:00 ends at midnight.
+type Window struct {
+	// Days holds the days the window starts on, mon to sun, every day when empty
+	Days  []string `json:"days,omitempty" bson:"days,omitempty"`
+	Start string   `json:"start" bson:"start"`
+	End   string   `json:"end" bson:"end"`
+}
+
+// minuteRange is a period of the week in minutes since Monday 00:00, from Start up to but excluding End.
+type minuteRange struct {
+	Start int `bson:"start"`
+	End   int `bson:"end"`
+}
+
+// Validate reports whether the timezone exists and the windows are well formed.
+func (s Schedule) Validate() error {
+	_, _, err := s.parse()
+	return err
+}
+
+// ActiveAt reports whether t falls in one of the windows. An invalid schedule is never active.
+func (s Schedule) ActiveAt(t time.Time) bool {
+	loc, ranges, err := s.parse()
+	if err != nil {
+		return false
+	}
+	minute := minuteOfWeek(t.In(loc))
+	for _, r := range ranges {
+		if r.Start <= minute && minute < r.End {
+			return true
+		}
+	}
+	return false
+}
+
+// NextChange returns the first instant after t at which a window opens or closes, or the zero time
+// if the schedule is invalid or has no windows.
+func (s Schedule) NextChange(t time.Time) time.Time {
+	loc, ranges, err := s.parse()
+	if err != nil {
+		return time.Time{}
+	}
+
+	local := t.In(loc)
+	day := local.Day() - minuteOfWeek(local)/minutesPerDay
+	var next time.Time
+	for _, r := range ranges {
+		for _, minute := range []int{r.Start, r.End, r.Start + minutesPerWeek, r.End + minutesPerWeek} {
+			// time.Date normalizes the overflowing days and follows the DST transitions
+			boundary := time.Date(local.Year(), local.Month(), day+minute/minutesPerDay, minute%minutesPerDay/60, minute%60, 0, 0, loc)
+			if boundary.After(t) && (next.IsZero() || boundary.Before(next)) {
+				next = boundary
+			}
+		}
+	}
+	return next
+}
+
+// MarshalBSON stores the windows along with their ranges of minutes of the week, which the listing
+// filter compares with the local time of the query in the schedule's timezone.
+func (s Schedule) MarshalBSON() ([]byte, error) {
+	_, ranges, err := s.parse()
+	if err != nil {
+		return nil, err
+	}
+
+	type stored struct {
+		Timezone string        `bson:"timezone"`
+		Windows  []Window      `bson:"windows"`
+		Ranges   []minuteRange `bson:"ranges"`
+	}
+	return bson.Marshal(stored{Timezone: s.Timezone, Windows: s.Windows, Ranges: ranges})
+}
+
+// parse loads the timezone and converts the windows into ranges of minutes of the week, splitting
+// those that wrap past the end of the week.
+func (s Schedule) parse() (*time.Location, []minuteRange, error) {
+	if s.Timezone == "" {
+		return nil, nil, errors.New("schedule timezone is required")
+	}
+	loc, err := time.LoadLocation(s.Timezone)
+	if err != nil {
+		return nil, nil, fmt.Errorf("invalid schedule timezone %q", s.Timezone)
+	}
+	if len(s.Windows) == 0 {
+		return nil, nil, errors.New("schedule needs at least one window")
+	}
+
+	var ranges []minuteRange
+	for _, w := range s.Windows {
+		start, err := parseClock(w.Start)
+		if err != nil || start == minutesPerDay {
+			return nil, nil, fmt.Errorf("invalid window start %q, expected HH:MM", w.Start)
+		}
+		end, err := parseClock(w.End)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid window end %q, expected HH:MM", w.End)
+		}
+		if end <= start {
+			end += minutesPerDay
+		}
+
+		days := w.Days
+		if len(days) == 0 {
+			days = weekdays
+		}
+		for _, day := range days {
+			i := slices.Index(weekdays, day)
+			if i < 0 {
+				return nil, nil, fmt.Errorf("invalid window day %q, expected one of %v", day, weekdays)
+			}
+			r := minuteRange{Start: i*minutesPerDay + start, End: i*minutesPerDay + end}
+			if r.End > minutesPerWeek {
+				ranges = append(ranges, minuteRange{Start: 0, End: r.End - minutesPerWeek})
+				r.End = minutesPerWeek
+			}
+			ranges = append(ranges, r)
+		}
+	}
+	return loc, ranges, nil
+}
+
+// parseClock parses HH:MM into minutes since midnight, accepting 24:00 as the end of the day.
+func parseClock(s string) (int, error) {
+	if s == "24:00" {
+		return minutesPerDay, nil
+	}
+	t, err := time.Parse("15:04", s)
+	if err != nil {
+		return 0, err
+	}
+	return t.Hour()*60 + t.Minute(), nil
+}
+
+// minuteOfWeek returns the minutes elapsed since Monday 00:00 of the week of t, in t's location.
+func minuteOfWeek(t time.Time) int {
+	day := (int(t.Weekday()) + 6) % 7
+	return day*minutesPerDay + t.Hour()*60 + t.Minute()
+}
@@ -0,0 +1,123 @@
+package models_test
+
+import (
+	"testing"
+	"time"
+
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"github.com/stretchr/testify/require"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestSchedule_Validate(t *testing.T) {
+	tests := []struct {
+		name     string
+		schedule models.Schedule
+		wantErr  bool
+	}{
+		{"valid", models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{{Days: []string{"mon"}, Start: "09:00", End: "17:00"}}}, false},
+		{"ends at midnight", models.Schedule{Timezone: "UTC", Windows: []models.Window{{Start: "18:00", End: "24:00"}}}, false},
+		{"missing timezone", models.Schedule{Windows: []models.Window{{Start: "09:00", End: "17:00"}}}, true},
+		{"unknown timezone", models.Schedule{Timezone: "Mars/Olympus", Windows: []models.Window{{Start: "09:00", End: "17:00"}}}, true},
+		{"no windows", models.Schedule{Timezone: "UTC"}, true},
+		{"invalid start", models.Schedule{Timezone: "UTC", Windows: []models.Window{{Start: "9am", End: "17:00"}}}, true},
+		{"starts at midnight", models.Schedule{Timezone: "UTC", Windows: []models.Window{{Start: "24:00", End: "02:00"}}}, true},
+		{"invalid end", models.Schedule{Timezone: "UTC", Windows: []models.Window{{Start: "09:00", End: "25:00"}}}, true},
+		{"invalid day", models.Schedule{Timezone: "UTC", Windows: []models.Window{{Days: []string{"monday"}, Start: "09:00", End: "17:00"}}}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.schedule.Validate()
+			if tt.wantErr {
+				assert.Error(t, err)
+			} else {
+				assert.NoError(t, err)
+			}
+		})
+	}
+}
+
+func TestSchedule_ActiveAt(t *testing.T) {
+	// Weekday business hours in Taipei, UTC+8, and late nights on Sunday wrapping into Monday
+	schedule := models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{
+		{Days: []string{"mon", "tue", "wed", "thu", "fri"}, Start: "09:00", End: "17:00"},
+		{Days: []string{"sun"}, Start: "22:00", End: "02:00"},
+	}}
+
+	tests := []struct {
+		at   string
+		want bool
+	}{
+		{"2026-10-19T01:00:00Z", true},  // Monday 09:00 in Taipei
+		{"2026-10-18T17:59:00Z", true},  // Monday 01:59, still in Sunday's late night
+		{"2026-10-19T09:00:00Z", false}, // Monday 17:00
+		{"2026-10-17T02:00:00Z", false}, // Saturday 10:00
+		{"2026-10-18T14:00:00Z", true},  // Sunday 22:00
+		{"2026-10-18T18:00:00Z", false}, // Monday 02:00
+	}
+
+	for _, tt := range tests {
+		at, err := time.Parse(time.RFC3339, tt.at)
+		require.NoError(t, err)
+		assert.Equal(t, tt.want, schedule.ActiveAt(at), tt.at)
+	}
+}
+
+func TestSchedule_NextChange(t *testing.T) {
+	schedule := models.Schedule{Timezone: "Asia/Taipei", Windows: []models.Window{
+		{Days: []string{"fri"}, Start: "09:00", End: "17:00"},
+	}}
+
+	tests := []struct {
+		name, at, want string
+	}{
+		{"window opens", "2026-10-16T00:00:00Z", "2026-10-16T01:00:00Z"},
+		{"window closes", "2026-10-16T01:00:00Z", "2026-10-16T09:00:00Z"},
+		{"opens next week", "2026-10-16T09:00:00Z", "2026-10-23T01:00:00Z"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			at, _ := time.Parse(time.RFC3339, tt.at)
+			want, _ := time.Parse(time.RFC3339, tt.want)
+			next := schedule.NextChange(at)
+			assert.True(t, want.Equal(next), "expected %s, got %s", want, next)
+		})
+	}
+
+	assert.True(t, models.Schedule{}.NextChange(time.Now()).IsZero(), "expected no change for an invalid schedule")
+}
+
+func TestSchedule_NextChange_DST(t *testing.T) {
+	// New York falls back on Sunday 2026-11-01, so 09:00 moves from 13:00 to 14:00 UTC
+	schedule := models.Schedule{Timezone: "America/New_York", Windows: []models.Window{{Start: "09:00", End: "10:00"}}}
+
+	at, _ := time.Parse(time.RFC3339, "2026-10-31T14:00:00Z")
+	want, _ := time.Parse(time.RFC3339, "2026-11-01T14:00:00Z")
+	next := schedule.NextChange(at)
+	assert.True(t, want.Equal(next), "expected %s, got %s", want, next)
+}
+
+func TestSchedule_MarshalBSON(t *testing.T) {
+	schedule := models.Schedule{Timezone: "UTC", Windows: []models.Window{{Days: []string{"sun"}, Start: "22:00", End: "02:00"}}}
+
+	data, err := bson.Marshal(schedule)
+	require.NoError(t, err)
+
+	var doc struct {
+		Ranges []struct{ Start, End int } `bson:"ranges"`
+	}
+	require.NoError(t, bson.Unmarshal(data, &doc))
+	// Sunday's window wraps past the end of the week into Monday
+	assert.Equal(t, []struct{ Start, End int }{{0, 120}, {6*24*60 + 22*60, 7 * 24 * 60}}, doc.Ranges)
+
+	var decoded models.Schedule
+	require.NoError(t, bson.Unmarshal(data, &decoded))
+	assert.Equal(t, schedule, decoded)
+
+	_, err = bson.Marshal(models.Schedule{Timezone: "UTC"})
+	assert.Error(t, err, "expected an invalid schedule not to be stored")
+}
@@ -0,0 +1,110 @@
+package models
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+// maxVersionPart bounds each part of a Version, so that Key fits an int64 and keeps their order.
+const maxVersionPart = 99999
+
+// Version is a MAJOR.MINOR.PATCH version of an OS or app, compared as in semver. Pre-release and
+// build suffixes are not supported.
+type Version struct {
+	Major, Minor, Patch int
+}
+
+// ParseVersion parses a version such as 17, 17.2 or v17.2.1; the missing parts are zero.
+func ParseVersion(s string) (Version, error) {
+	parts := strings.Split(strings.TrimPrefix(s, "v"), ".")
+	if len(parts) > 3 {
+		return Version{}, fmt.Errorf("invalid version %q: expected MAJOR.MINOR.PATCH", s)
+	}
+
+	var numbers [3]int
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || n > maxVersionPart || part != strconv.Itoa(n) {
+			return Version{}, fmt.Errorf("invalid version %q: parts must be numbers between 0 and %d", s, maxVersionPart)
+		}
+		numbers[i] = n
+	}
+	return Version{Major: numbers[0], Minor: numbers[1], Patch: numbers[2]}, nil
+}
+
+// String returns the canonical MAJOR.MINOR.PATCH form of the version.
+func (v Version) String() string {
+	return fmt.Sprintf("%d.%d.%d", v.Major, v.Minor, v.Patch)
+}
+
+// Key returns a number that orders versions like semver, for MongoDB to compare them.
+func (v Version) Key() int64 {
+	const base = maxVersionPart + 1
+	return (int64(v.Major)*base+int64(v.Minor))*base + int64(v.Patch)
+}
+
+// VersionRange restricts an ad to the OS or app versions between Min and Max, both included.
+// An empty bound leaves the range open on that side.
+type VersionRange struct {
+	Min string `json:"min,omitempty" bson:"min,omitempty"`
+	Max string `json:"max,omitempty" bson:"max,omitempty"`
+}
+
+// Validate reports whether the bounds are versions and Min is not above Max.
+func (r VersionRange) Validate() error {
+	lower, upper, err := r.bounds()
+	if err != nil {
+		return err
+	}
+	if lower != nil && upper != nil && lower.Key() > upper.Key() {
+		return errors.New("min version must be less than or equal to max version")
+	}
+	return nil
+}
+
+// Contains reports whether v lies in the range. Invalid bounds do not restrict it.
+func (r VersionRange) Contains(v Version) bool {
+	lower, upper, _ := r.bounds()
+	return (lower == nil || lower.Key() <= v.Key()) && (upper == nil || upper.Key() >= v.Key())
+}
+
+// MarshalBSON stores the bounds along with their Key, minKey and maxKey, which the listing filter
+// compares instead of the strings, since 10.0 sorts before 9.0.
+func (r VersionRange) MarshalBSON() ([]byte, error) {
+	lower, upper, err := r.bounds()
+	if err != nil {
+		return nil, err
+	}
+
+	doc := bson.D{}
+	if lower != nil {
+		doc = append(doc, bson.E{Key: "min", Value: r.Min}, bson.E{Key: "minKey", Value: lower.Key()})
+	}
+	if upper != nil {
+		doc = append(doc, bson.E{Key: "max", Value: r.Max}, bson.E{Key: "maxKey", Value: upper.Key()})
+	}
+	return bson.Marshal(doc)
+}
+
+// bounds parses the bounds of the range, nil when they are empty.
+func (r VersionRange) bounds() (lower, upper *Version, err error) {
+	if r.Min != "" {
+		v, err := ParseVersion(r.Min)
+		if err != nil {
+			return nil, nil, err
+		}
+		lower = &v
+	}
+	if r.Max != "" {
+		v, err := ParseVersion(r.Max)
+		if err != nil {
+			return nil, nil, err
+		}
+		upper = &v
+	}
+	return lower, upper, nil
+}
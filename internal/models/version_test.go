@@ -0,0 +1,85 @@
+package models_test
+
+import (
+	"testing"
+
+	"ad-service-api/internal/models"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson"
+)
+
+func TestParseVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		want    string
+		wantErr bool
+	}{
+		{"17", "17.0.0", false},
+		{"17.2", "17.2.0", false},
+		{"v3.10.1", "3.10.1", false},
+		{"1.2.3.4", "", true},
+		{"1.-2", "", true},
+		{"1.02", "", true},
+		{"1.2.3-beta", "", true},
+		{"", "", true},
+	}
+
+	for _, tt := range tests {
+		v, err := models.ParseVersion(tt.version)
+		if tt.wantErr {
+			assert.Error(t, err, tt.version)
+			continue
+		}
+		assert.NoError(t, err, tt.version)
+		assert.Equal(t, tt.want, v.String())
+	}
+}
+
+func TestVersion_Key(t *testing.T) {
+	// Ordered as in semver, unlike the strings
+	ordered := []string{"9.9.9", "10.0.0", "10.0.1", "10.1.0", "99999.99999.99999"}
+	for i := 1; i < len(ordered); i++ {
+		lower, _ := models.ParseVersion(ordered[i-1])
+		upper, _ := models.ParseVersion(ordered[i])
+		assert.Less(t, lower.Key(), upper.Key(), "%s < %s", lower, upper)
+	}
+}
+
+func TestVersionRange(t *testing.T) {
+	r := models.VersionRange{Min: "9.1", Max: "10"}
+	assert.NoError(t, r.Validate())
+	assert.Error(t, models.VersionRange{Min: "10", Max: "9.1"}.Validate())
+	assert.Error(t, models.VersionRange{Max: "latest"}.Validate())
+
+	for version, want := range map[string]bool{"9.0.9": false, "9.1": true, "9.10": true, "10.0.0": true, "10.0.1": false} {
+		v, _ := models.ParseVersion(version)
+		assert.Equal(t, want, r.Contains(v), version)
+	}
+	v, _ := models.ParseVersion("1")
+	assert.True(t, models.VersionRange{Max: "2"}.Contains(v), "expected an open lower bound")
+
+	// The bounds are stored with their keys, and read back without them
+	data, err := bson.Marshal(r)
+	assert.NoError(t, err)
+	var doc bson.M
+	assert.NoError(t, bson.Unmarshal(data, &doc))
+	lower, _ := models.ParseVersion("9.1")
+	assert.Equal(t, lower.Key(), doc["minKey"])
+	var decoded models.VersionRange
+	assert.NoError(t, bson.Unmarshal(data, &decoded))
+	assert.Equal(t, r, decoded)
+
+	// Also as an optional condition
+	data, err = bson.Marshal(models.Conditions{OSVersion: &r})
+	assert.NoError(t, err)
+	var conditions models.Conditions
+	assert.NoError(t, bson.Unmarshal(data, &conditions))
+	assert.Equal(t, &r, conditions.OSVersion)
+	assert.Nil(t, conditions.AppVersion)
+}
+
+func TestLanguageMatches(t *testing.T) {
+	assert.Equal(t, []string{"zh-Hant-TW", "zh-Hant", "zh", "en"}, models.LanguageMatches([]string{"zh-Hant-TW", "zh", "en"}))
+	assert.Empty(t, models.LanguageMatches(nil))
+}
@@ -0,0 +1,46 @@
+package retry
+
+import (
+	"context"
+	"fmt"
+	"log/slog"
+	"time"
+)
+
+const (
+	// initialBackoff is the delay before the first retry.
+	initialBackoff = 100 * time.Millisecond
+	// maxBackoff caps the delay between two attempts.
+	maxBackoff = 5 * time.Second
+)
+
+// Do calls fn until it succeeds, doubling the delay between attempts, and gives up with the
+// last error once window has elapsed or ctx is done.
+func Do(ctx context.Context, window time.Duration, name string, fn func(ctx context.Context) error) error {
+	ctx, cancel := context.WithTimeout(ctx, window)
+	defer cancel()
+
+	backoff := initialBackoff
+	for attempt := 1; ; attempt++ {
+		err := fn(ctx)
+		if err == nil {
+			return nil
+		}
+
+		if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < backoff {
+			return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+		}
+		slog.WarnContext(ctx, name+" failed, retrying", "attempt", attempt, "backoff", backoff.String(), "error", err)
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%s failed after %d attempts: %w", name, attempt, err)
+		case <-time.After(backoff):
+		}
+
+		backoff *= 2
+		if backoff > maxBackoff {
+			backoff = maxBackoff
+		}
+	}
+}
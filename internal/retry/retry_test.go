@@ -0,0 +1,38 @@
+package retry_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"ad-service-api/internal/retry"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDo_SucceedsAfterFailures(t *testing.T) {
+	attempts := 0
+	err := retry.Do(context.Background(), 5*time.Second, "test", func(ctx context.Context) error {
+		attempts++
+		if attempts < 3 {
+			return errors.New("not ready")
+		}
+		return nil
+	})
+
+	assert.NoError(t, err)
+	assert.Equal(t, 3, attempts)
+}
+
+func TestDo_GivesUpAfterWindow(t *testing.T) {
+	errNotReady := errors.New("not ready")
+	start := time.Now()
+
+	err := retry.Do(context.Background(), 500*time.Millisecond, "test", func(ctx context.Context) error {
+		return errNotReady
+	})
+
+	assert.ErrorIs(t, err, errNotReady)
+	assert.Less(t, time.Since(start), time.Second, "expected Do to stop retrying once the window elapsed")
+}
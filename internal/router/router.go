@@ -1,48 +1,166 @@
 package router
 
 import (
-	"os"
+	"context"
+	"errors"
+	"log/slog"
+	"time"
 
+	"ad-service-api/config"
 	"ad-service-api/database"
 	"ad-service-api/internal/advertisement/handler"
 	"ad-service-api/internal/advertisement/repository"
 	"ad-service-api/internal/advertisement/service"
+	"ad-service-api/internal/health"
+	"ad-service-api/internal/metrics"
 	"ad-service-api/internal/middleware"
+	"ad-service-api/internal/retry"
+	"ad-service-api/internal/tracing"
 	"ad-service-api/redis"
 
 	"github.com/gin-gonic/gin"
+	goredis "github.com/go-redis/redis/v8"
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
 	swaggerFiles "github.com/swaggo/files"
 	ginSwagger "github.com/swaggo/gin-swagger"
+	mongodriver "go.mongodb.org/mongo-driver/mongo"
+	"go.opentelemetry.io/contrib/instrumentation/github.com/gin-gonic/gin/otelgin"
 )
 
-// NewRouter creates a new router
-func NewRouter() *gin.Engine {
-	mongoUsername := os.Getenv("MONGO_USERNAME")
-	mongoPassword := os.Getenv("MONGO_PASSWORD")
-	mongoHost := os.Getenv("MONGO_HOST")
-	mongoDb := os.Getenv("MONGO_DB")
-	mongoCollection := os.Getenv("MONGO_COLLECTION")
-	redisHost := os.Getenv("REDIS_HOST")
-	redisPassword := os.Getenv("REDIS_PASSWORD")
-	redisDb := 0
-	col, _ := database.ConnectMongoDB(mongoUsername, mongoPassword, mongoHost, mongoDb, mongoCollection)
-	rdb, _ := redis.ConnectRedis(redisHost, redisPassword, redisDb)
-
-	adRepo := repository.NewAdvertisementRepository(col)
-	adRedisRepo := repository.NewAdRedisRepository(rdb)
-	adService := service.NewAdvertisementService(adRepo, adRedisRepo)
-	adHandler := handler.NewAdvertisementHandler(adService)
-
-	r := gin.Default()
+// readinessTimeout bounds how long /readyz waits for each dependency.
+const readinessTimeout = 2 * time.Second
+
+// Closer releases the connections opened by NewRouter.
+type Closer func(ctx context.Context) error
+
+// NewRouter creates a new router, connecting to the configured backends. Connections are
+// retried with backoff for cfg.Server.ConnectTimeout before an error is returned, the
+// MongoDB indexes are created if missing. The returned Closer disconnects from the backends once
+// the server has stopped. Requests log through logger, tagged with their request id.
+func NewRouter(cfg *config.Config, logger *slog.Logger) (*gin.Engine, Closer, error) {
+	ctx := context.Background()
+	var closers []Closer
+	closeAll := func(ctx context.Context) error {
+		var errs []error
+		for _, c := range closers {
+			errs = append(errs, c(ctx))
+		}
+		return errors.Join(errs...)
+	}
+	healthHandler := health.NewHealthHandler(readinessTimeout)
+
+	var adRepo repository.IAdvertisementRepository
+	if cfg.Storage.Backend == config.BackendMemory {
+		adRepo = repository.NewMemoryAdvertisementRepository()
+	} else {
+		mongo := cfg.Storage.Mongo
+		var col *mongodriver.Collection
+		err := retry.Do(ctx, cfg.Server.ConnectTimeout, "connecting to mongodb", func(ctx context.Context) error {
+			var err error
+			col, err = database.ConnectMongoDB(ctx, mongo.Username, mongo.Password, mongo.Host, mongo.Database, mongo.Collection)
+			return err
+		})
+		if err != nil {
+			return nil, nil, err
+		}
+		closers = append(closers, col.Database().Client().Disconnect)
+		if err := database.EnsureIndexes(ctx, col); err != nil {
+			closeAll(ctx)
+			return nil, nil, err
+		}
+		// The listing keeps being served stale from the cache while MongoDB is down, so taking the
+		// replicas out of the Service would only turn a degraded listing into an outage
+		healthHandler.AddOptionalCheck("mongo", func(ctx context.Context) error {
+			return col.Database().Client().Ping(ctx, nil)
+		})
+		adRepo = repository.NewAdvertisementRepository(col)
+	}
+
+	var adRedisRepo repository.IAdRedisRepository
+	if cfg.Cache.Backend == config.BackendMemory {
+		adRedisRepo = repository.NewMemoryAdRedisRepository()
+	} else {
+		var rdb *goredis.Client
+		err := retry.Do(ctx, cfg.Server.ConnectTimeout, "connecting to redis", func(ctx context.Context) error {
+			var err error
+			rdb, err = redis.ConnectRedis(ctx, cfg.Cache.Redis.Host, cfg.Cache.Redis.Password, cfg.Cache.Redis.DB)
+			return err
+		})
+		if err != nil {
+			closeAll(ctx)
+			return nil, nil, err
+		}
+		closers = append(closers, func(context.Context) error { return rdb.Close() })
+		healthHandler.AddCheck("redis", func(ctx context.Context) error {
+			return rdb.Ping(ctx).Err()
+		})
+		adRedisRepo = repository.NewAdRedisRepository(rdb)
+
+		if cfg.Cache.Local.Size > 0 {
+			lru := repository.NewLRUAdRedisRepository(adRedisRepo, repository.NewRedisInvalidationBus(rdb), cfg.Cache.Local.Size, cfg.Cache.Local.TTL)
+			listenCtx, stopListening := context.WithCancel(context.Background())
+			if err := lru.Listen(listenCtx); err != nil {
+				stopListening()
+				closeAll(ctx)
+				return nil, nil, err
+			}
+			closers = append(closers, func(context.Context) error {
+				stopListening()
+				return nil
+			})
+			adRedisRepo = lru
+		}
+	}
+
+	adService := service.NewAdvertisementService(adRepo, adRedisRepo, newQuotaConfig(cfg.Quota), cfg.Ranking.Ranking())
+	adHandler := handler.NewAdvertisementHandler(adService, cfg.Cache.TTL, cfg.Cache.StaleTTL, cfg.Cache.RefreshTimeout)
+
+	// Replace the collector of a previous router so that the gauges read the current storage
+	adCountCollector := metrics.NewAdCountCollector(adService)
+	prometheus.Unregister(adCountCollector)
+	if err := prometheus.Register(adCountCollector); err != nil {
+		closeAll(ctx)
+		return nil, nil, err
+	}
+
+	r := gin.New()
+	// Let handlers pass the gin context down to services and repositories with the request's logger
+	r.ContextWithFallback = true
+	// Tracing runs first so that the request id logger can carry the trace id
+	r.Use(otelgin.Middleware(tracing.ServiceName))
+	r.Use(middleware.RequestID(logger))
+	r.Use(middleware.Recovery())
 	r.Use(middleware.Logger())
+	r.Use(middleware.Metrics())
 
 	r.GET("/swagger/*any", ginSwagger.WrapHandler(swaggerFiles.Handler))
+	r.GET("/healthz", healthHandler.LivenessHandler)
+	r.GET("/readyz", healthHandler.ReadinessHandler)
+	r.GET("/metrics", gin.WrapH(promhttp.Handler()))
 
 	adRoutes := r.Group("/api/v1")
 	{
 		adRoutes.POST("/ad", adHandler.CreateAdHandler)
 		adRoutes.GET("/ad", adHandler.ListAdHandler)
+		adRoutes.GET("/ad/:id", adHandler.GetAdHandler)
+		adRoutes.PUT("/ad/:id", adHandler.UpdateAdHandler)
+		adRoutes.PATCH("/ad/:id", adHandler.PatchAdHandler)
+		adRoutes.DELETE("/ad/:id", adHandler.DeleteAdHandler)
+		adRoutes.GET("/quota", adHandler.GetQuotaHandler)
 	}
 
-	return r
+	return r, closeAll, nil
+}
+
+// newQuotaConfig converts the configured quota limits into the service's QuotaConfig.
+func newQuotaConfig(cfg config.QuotaConfig) service.QuotaConfig {
+	quota := service.QuotaConfig{
+		Default:     service.QuotaLimits{Daily: cfg.DailyLimit, Active: cfg.ActiveLimit},
+		Advertisers: make(map[string]service.QuotaLimits, len(cfg.Advertisers)),
+	}
+	for advertiserID, limits := range cfg.Advertisers {
+		quota.Advertisers[advertiserID] = service.QuotaLimits{Daily: limits.DailyLimit, Active: limits.ActiveLimit}
+	}
+	return quota
 }
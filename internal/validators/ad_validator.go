@@ -5,12 +5,33 @@ import (
 	"errors"
 	"fmt"
 	"net/url"
+	"regexp"
+	"slices"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/pariz/gountries"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"golang.org/x/text/language"
 )
 
+func ValidateAdID(id string) error {
+	if !primitive.IsValidObjectID(id) {
+		return fmt.Errorf("invalid id: %v", id)
+	}
+	return nil
+}
+
+var advertiserIDPattern = regexp.MustCompile(`^[A-Za-z0-9_-]{1,64}$`)
+
+func ValidateAdvertiserID(advertiserID string) error {
+	if !advertiserIDPattern.MatchString(advertiserID) {
+		return fmt.Errorf("invalid advertiserId: %v", advertiserID)
+	}
+	return nil
+}
+
 func ValidateAgeRange(ageStart, ageEnd int) error {
 	if ageStart < 1 || ageStart > 100 {
 		return errors.New("ageStart should be between 1 and 100")
@@ -59,6 +80,39 @@ func ValidatePlatform(platforms string) error {
 	return nil
 }
 
+func ValidateLanguage(tag string) error {
+	parsed, err := language.Parse(tag)
+	if err != nil {
+		return fmt.Errorf("invalid language: %v", tag)
+	}
+	if parsed.String() != tag {
+		return fmt.Errorf("invalid language: %v, expected %v", tag, parsed)
+	}
+	return nil
+}
+
+func ValidateRegion(region string) error {
+	alpha2, code, ok := strings.Cut(region, "-")
+	if !ok || alpha2 != strings.ToUpper(alpha2) {
+		return fmt.Errorf("invalid region: %v", region)
+	}
+	country, err := gountries.New().FindCountryByAlpha(alpha2)
+	if err != nil {
+		return fmt.Errorf("invalid region: %v", region)
+	}
+	for _, subdivision := range country.SubDivisions() {
+		if subdivision.Code == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("invalid region: %v", region)
+}
+
+func ValidateVersion(version string) error {
+	_, err := models.ParseVersion(version)
+	return err
+}
+
 func ValidateLimit(limit string) error {
 	limitInt, err := strconv.Atoi(limit)
 	if err != nil {
@@ -81,6 +135,11 @@ func ValidateOffset(offset string) error {
 	return nil
 }
 
+func ValidateCursor(cursor string) error {
+	_, err := models.DecodeCursor(cursor)
+	return err
+}
+
 func CreateAdValueValidation(ad models.Advertisement) error {
 	// Validate startAt and endAt
 	if ad.StartAt.After(ad.EndAt) {
@@ -91,6 +150,13 @@ func CreateAdValueValidation(ad models.Advertisement) error {
 		return errors.New("endAt must be after the current time")
 	}
 
+	// Validate advertiser
+	if ad.AdvertiserID != "" {
+		if err := ValidateAdvertiserID(ad.AdvertiserID); err != nil {
+			return err
+		}
+	}
+
 	// Validate age range
 	if err := ValidateAgeRange(ad.Conditions.AgeStart, ad.Conditions.AgeEnd); err != nil {
 		return err
@@ -117,12 +183,88 @@ func CreateAdValueValidation(ad models.Advertisement) error {
 		}
 	}
 
+	// Validate excluded countries and platforms, which must not also be targeted
+	for _, country := range ad.Conditions.ExcludeCountry {
+		if err := ValidateCountry(country); err != nil {
+			return err
+		}
+		if slices.Contains(ad.Conditions.Country, country) {
+			return fmt.Errorf("country %s cannot be both targeted and excluded", country)
+		}
+	}
+	for _, platform := range ad.Conditions.ExcludePlatform {
+		if err := ValidatePlatform(platform); err != nil {
+			return err
+		}
+		if slices.Contains(ad.Conditions.Platform, platform) {
+			return fmt.Errorf("platform %s cannot be both targeted and excluded", platform)
+		}
+	}
+
+	// Validate languages
+	for _, tag := range ad.Conditions.Language {
+		if err := ValidateLanguage(tag); err != nil {
+			return err
+		}
+	}
+
+	// Validate regions, which must lie in the targeted countries
+	for _, region := range ad.Conditions.Region {
+		if err := ValidateRegion(region); err != nil {
+			return err
+		}
+		country, _, _ := strings.Cut(region, "-")
+		if len(ad.Conditions.Country) > 0 && !slices.Contains(ad.Conditions.Country, country) {
+			return fmt.Errorf("region %s is outside the targeted countries", region)
+		}
+		if slices.Contains(ad.Conditions.ExcludeCountry, country) {
+			return fmt.Errorf("region %s is in an excluded country", region)
+		}
+	}
+
+	// Validate OS and app version ranges
+	if ad.Conditions.OSVersion != nil {
+		if err := ad.Conditions.OSVersion.Validate(); err != nil {
+			return fmt.Errorf("invalid osVersion: %w", err)
+		}
+	}
+	if ad.Conditions.AppVersion != nil {
+		if err := ad.Conditions.AppVersion.Validate(); err != nil {
+			return fmt.Errorf("invalid appVersion: %w", err)
+		}
+	}
+
+	// Validate priority, bid and budget
+	if ad.Priority < 0 {
+		return errors.New("priority must not be negative")
+	}
+	if ad.Bid < 0 {
+		return errors.New("bid must not be negative")
+	}
+	if ad.Budget < 0 {
+		return errors.New("budget must not be negative")
+	}
+
+	// Validate schedule
+	if ad.Schedule != nil {
+		if err := ad.Schedule.Validate(); err != nil {
+			return fmt.Errorf("invalid schedule: %w", err)
+		}
+	}
+
 	return nil
 }
 
 func ListAdParamsValidation(query url.Values) (map[string]string, error) {
 	validQueryParams := make(map[string]string)
 
+	// Only the targeting dimensions take several values
+	for _, param := range []string{"age", "osVersion", "appVersion", "limit", "offset", "cursor"} {
+		if len(query[param]) > 1 {
+			return nil, fmt.Errorf("%s validation failed: only one value is allowed", param)
+		}
+	}
+
 	// AgeStart condition validation
 	if age := query.Get("age"); age != "" {
 		if err := ValidateAgeQueryParam(age); err != nil {
@@ -131,28 +273,36 @@ func ListAdParamsValidation(query url.Values) (map[string]string, error) {
 		validQueryParams["age"] = age
 	}
 
-	// Gender condition validation
-	if gender := query.Get("gender"); gender != "" {
-		if err := ValidateGender(gender); err != nil {
-			return nil, fmt.Errorf("gender validation failed: %w", err)
+	// Gender, country, platform, language and region condition validation
+	for _, dimension := range []struct {
+		param     string
+		validate  func(string) error
+		negatable bool
+	}{
+		{"gender", ValidateGender, true},
+		{"country", ValidateCountry, true},
+		{"platform", ValidatePlatform, true},
+		// A language also matches the ads for its parents, which an exclusion could not follow
+		{"language", ValidateLanguage, false},
+		{"region", ValidateRegion, true},
+	} {
+		if !dimension.negatable && len(nonEmpty(query[dimension.param+models.NotSuffix])) > 0 {
+			return nil, fmt.Errorf("%s validation failed: %s cannot be excluded", dimension.param, dimension.param)
 		}
-		validQueryParams["gender"] = gender
-	}
-
-	// Country condition validation
-	if country := query.Get("country"); country != "" {
-		if err := ValidateCountry(country); err != nil {
-			return nil, fmt.Errorf("country validation failed: %w", err)
+		if err := validateValueSet(query, dimension.param, dimension.validate, validQueryParams); err != nil {
+			return nil, fmt.Errorf("%s validation failed: %w", dimension.param, err)
 		}
-		validQueryParams["country"] = country
 	}
 
-	// Platform condition validation
-	if platform := query.Get("platform"); platform != "" {
-		if err := ValidatePlatform(platform); err != nil {
-			return nil, fmt.Errorf("platform validation failed: %w", err)
+	// OS and app version condition validation, stored in their canonical form
+	for _, param := range []string{"osVersion", "appVersion"} {
+		if version := query.Get(param); version != "" {
+			v, err := models.ParseVersion(version)
+			if err != nil {
+				return nil, fmt.Errorf("%s validation failed: %w", param, err)
+			}
+			validQueryParams[param] = v.String()
 		}
-		validQueryParams["platform"] = platform
 	}
 
 	// Limit condition validation
@@ -165,6 +315,18 @@ func ListAdParamsValidation(query url.Values) (map[string]string, error) {
 	}
 	validQueryParams["limit"] = limit
 
+	// Cursor condition validation, the cursor replaces the offset
+	if cursor := query.Get("cursor"); cursor != "" {
+		if query.Get("offset") != "" {
+			return nil, errors.New("cursor validation failed: cursor and offset cannot be used together")
+		}
+		if err := ValidateCursor(cursor); err != nil {
+			return nil, fmt.Errorf("cursor validation failed: %w", err)
+		}
+		validQueryParams["cursor"] = cursor
+		return validQueryParams, nil
+	}
+
 	// Offset condition validation
 	offset := query.Get("offset")
 	if offset == "" {
@@ -177,3 +339,40 @@ func ListAdParamsValidation(query url.Values) (map[string]string, error) {
 
 	return validQueryParams, nil
 }
+
+// validateValueSet validates the values of param, repeated to match any of them, or of param
+// followed by models.NotSuffix to match anything but them. The values are stored in
+// validQueryParams sorted and without duplicates, so that the same set always yields the same
+// cache key.
+func validateValueSet(query url.Values, param string, validate func(string) error, validQueryParams map[string]string) error {
+	notParam := param + models.NotSuffix
+	if len(nonEmpty(query[param])) > 0 && len(nonEmpty(query[notParam])) > 0 {
+		return fmt.Errorf("%s and %s cannot be used together", param, notParam)
+	}
+
+	for _, name := range []string{param, notParam} {
+		values := nonEmpty(query[name])
+		if len(values) == 0 {
+			continue
+		}
+		for _, value := range values {
+			if err := validate(value); err != nil {
+				return err
+			}
+		}
+		slices.Sort(values)
+		validQueryParams[name] = strings.Join(slices.Compact(values), models.ValueSeparator)
+	}
+	return nil
+}
+
+// nonEmpty returns the values that are not empty, in a new slice.
+func nonEmpty(values []string) []string {
+	var kept []string
+	for _, value := range values {
+		if value != "" {
+			kept = append(kept, value)
+		}
+	}
+	return kept
+}
@@ -1,11 +1,91 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"log/slog"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+
+	"ad-service-api/config"
 	_ "ad-service-api/docs"
+	"ad-service-api/internal/logging"
 	"ad-service-api/internal/router"
+	"ad-service-api/internal/tracing"
 )
 
 func main() {
-	router := router.NewRouter()
-	router.Run(":8080")
+	os.Exit(run())
+}
+
+// run starts the server and blocks until it fails or receives SIGINT/SIGTERM, returning the exit code.
+func run() int {
+	cfg, err := config.Load(os.Args[1:])
+	if err != nil {
+		slog.Error("failed to load configuration", "error", err)
+		return 1
+	}
+
+	// Also route the standard log package and slog's package-level functions to the JSON logger
+	logger := logging.New(os.Stdout, cfg.Log.Level)
+	slog.SetDefault(logger)
+
+	shutdownTracing, err := tracing.Setup(context.Background(), cfg.Tracing)
+	if err != nil {
+		logger.Error("failed to set up tracing", "error", err)
+		return 1
+	}
+
+	router, closeBackends, err := router.NewRouter(cfg, logger)
+	if err != nil {
+		logger.Error("failed to start", "error", err)
+		shutdownTracing(context.Background())
+		return 1
+	}
+
+	srv := &http.Server{
+		Addr:    cfg.Server.Addr,
+		Handler: router,
+	}
+
+	ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGINT, syscall.SIGTERM)
+	defer stop()
+
+	serveErr := make(chan error, 1)
+	go func() {
+		logger.Info("listening", "addr", cfg.Server.Addr)
+		serveErr <- srv.ListenAndServe()
+	}()
+
+	exitCode := 0
+	select {
+	case err := <-serveErr:
+		if !errors.Is(err, http.ErrServerClosed) {
+			logger.Error("server stopped", "error", err)
+			exitCode = 1
+		}
+	case <-ctx.Done():
+		logger.Info("shutting down, draining requests", "timeout", cfg.Server.ShutdownTimeout.String())
+	}
+
+	// Stop accepting requests and wait for in-flight ones, then close the backends they use
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), cfg.Server.ShutdownTimeout)
+	defer cancel()
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		logger.Error("failed to drain requests", "error", err)
+		exitCode = 1
+	}
+	if err := closeBackends(shutdownCtx); err != nil {
+		logger.Error("failed to close connections", "error", err)
+		exitCode = 1
+	}
+	if err := shutdownTracing(shutdownCtx); err != nil {
+		logger.Error("failed to flush spans", "error", err)
+		exitCode = 1
+	}
+
+	logger.Info("shutdown complete")
+	return exitCode
 }
@@ -16,17 +16,91 @@ type MockAdRedisRepository struct {
 	mock.Mock
 }
 
-// DeleteAdsByPattern provides a mock function with given fields: ctx, pattern
-func (_m *MockAdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string) error {
-	ret := _m.Called(ctx, pattern)
+// AcquireLock provides a mock function with given fields: ctx, key, token, ttl
+func (_m *MockAdRedisRepository) AcquireLock(ctx context.Context, key string, token string, ttl time.Duration) (bool, error) {
+	ret := _m.Called(ctx, key, token, ttl)
 
 	if len(ret) == 0 {
-		panic("no return value specified for DeleteAdsByPattern")
+		panic("no return value specified for AcquireLock")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) (bool, error)); ok {
+		return rf(ctx, key, token, ttl)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, string, time.Duration) bool); ok {
+		r0 = rf(ctx, key, token, ttl)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, string, time.Duration) error); ok {
+		r1 = rf(ctx, key, token, ttl)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CacheGeneration provides a mock function with given fields: ctx
+func (_m *MockAdRedisRepository) CacheGeneration(ctx context.Context) (int64, error) {
+	ret := _m.Called(ctx)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CacheGeneration")
+	}
+
+	var r0 int64
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context) (int64, error)); ok {
+		return rf(ctx)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context) int64); ok {
+		r0 = rf(ctx)
+	} else {
+		r0 = ret.Get(0).(int64)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context) error); ok {
+		r1 = rf(ctx)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// DecrByDate provides a mock function with given fields: ctx, key
+func (_m *MockAdRedisRepository) DecrByDate(ctx context.Context, key string) error {
+	ret := _m.Called(ctx, key)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DecrByDate")
 	}
 
 	var r0 error
 	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
-		r0 = rf(ctx, pattern)
+		r0 = rf(ctx, key)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// DeleteAdsByPattern provides a mock function with given fields: ctx, pattern, match
+func (_m *MockAdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern string, match func(string) bool) error {
+	ret := _m.Called(ctx, pattern, match)
+
+	if len(ret) == 0 {
+		panic("no return value specified for DeleteAdsByPattern")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, func(string) bool) error); ok {
+		r0 = rf(ctx, pattern, match)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -35,23 +109,23 @@ func (_m *MockAdRedisRepository) DeleteAdsByPattern(ctx context.Context, pattern
 }
 
 // GetAdsByKey provides a mock function with given fields: ctx, key
-func (_m *MockAdRedisRepository) GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error) {
+func (_m *MockAdRedisRepository) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
 	ret := _m.Called(ctx, key)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetAdsByKey")
 	}
 
-	var r0 []*models.Advertisement
+	var r0 *models.CachedAds
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) ([]*models.Advertisement, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.CachedAds, error)); ok {
 		return rf(ctx, key)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) []*models.Advertisement); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.CachedAds); ok {
 		r0 = rf(ctx, key)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]*models.Advertisement)
+			r0 = ret.Get(0).(*models.CachedAds)
 		}
 	}
 
@@ -110,17 +184,35 @@ func (_m *MockAdRedisRepository) IncrByDate(ctx context.Context, key string) err
 	return r0
 }
 
-// SetAdsByKey provides a mock function with given fields: ctx, key, ads, expiration
-func (_m *MockAdRedisRepository) SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error {
-	ret := _m.Called(ctx, key, ads, expiration)
+// ReleaseLock provides a mock function with given fields: ctx, key, token
+func (_m *MockAdRedisRepository) ReleaseLock(ctx context.Context, key string, token string) error {
+	ret := _m.Called(ctx, key, token)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ReleaseLock")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, string) error); ok {
+		r0 = rf(ctx, key, token)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// SetAdsByKey provides a mock function with given fields: ctx, key, cached, expiration
+func (_m *MockAdRedisRepository) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	ret := _m.Called(ctx, key, cached, expiration)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SetAdsByKey")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, []*models.Advertisement, time.Duration) error); ok {
-		r0 = rf(ctx, key, ads, expiration)
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.CachedAds, time.Duration) error); ok {
+		r0 = rf(ctx, key, cached, expiration)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -128,6 +220,34 @@ func (_m *MockAdRedisRepository) SetAdsByKey(ctx context.Context, key string, ad
 	return r0
 }
 
+// SetAdsByKeyIfGeneration provides a mock function with given fields: ctx, key, cached, expiration, generation
+func (_m *MockAdRedisRepository) SetAdsByKeyIfGeneration(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration, generation int64) (bool, error) {
+	ret := _m.Called(ctx, key, cached, expiration, generation)
+
+	if len(ret) == 0 {
+		panic("no return value specified for SetAdsByKeyIfGeneration")
+	}
+
+	var r0 bool
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.CachedAds, time.Duration, int64) (bool, error)); ok {
+		return rf(ctx, key, cached, expiration, generation)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.CachedAds, time.Duration, int64) bool); ok {
+		r0 = rf(ctx, key, cached, expiration, generation)
+	} else {
+		r0 = ret.Get(0).(bool)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, *models.CachedAds, time.Duration, int64) error); ok {
+		r1 = rf(ctx, key, cached, expiration, generation)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // NewMockAdRedisRepository creates a new instance of MockAdRedisRepository. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockAdRedisRepository(t interface {
@@ -8,8 +8,6 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
-	primitive "go.mongodb.org/mongo-driver/bson/primitive"
-
 	time "time"
 )
 
@@ -46,6 +44,90 @@ func (_m *MockAdvertisementRepository) CountActive(ctx context.Context, now time
 	return r0, r1
 }
 
+// CountActiveByAdvertiser provides a mock function with given fields: ctx, now, advertiserID
+func (_m *MockAdvertisementRepository) CountActiveByAdvertiser(ctx context.Context, now time.Time, advertiserID string) (int, error) {
+	ret := _m.Called(ctx, now, advertiserID)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountActiveByAdvertiser")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, string) (int, error)); ok {
+		return rf(ctx, now, advertiserID)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, string) int); ok {
+		r0 = rf(ctx, now, advertiserID)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, string) error); ok {
+		r1 = rf(ctx, now, advertiserID)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountActiveExcludingAdvertisers provides a mock function with given fields: ctx, now, advertiserIDs
+func (_m *MockAdvertisementRepository) CountActiveExcludingAdvertisers(ctx context.Context, now time.Time, advertiserIDs []string) (int, error) {
+	ret := _m.Called(ctx, now, advertiserIDs)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountActiveExcludingAdvertisers")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, []string) (int, error)); ok {
+		return rf(ctx, now, advertiserIDs)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time, []string) int); ok {
+		r0 = rf(ctx, now, advertiserIDs)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time, []string) error); ok {
+		r1 = rf(ctx, now, advertiserIDs)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// CountCreatedSince provides a mock function with given fields: ctx, since
+func (_m *MockAdvertisementRepository) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	ret := _m.Called(ctx, since)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountCreatedSince")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int, error)); ok {
+		return rf(ctx, since)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, since)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Create provides a mock function with given fields: ctx, ad
 func (_m *MockAdvertisementRepository) Create(ctx context.Context, ad *models.Advertisement) error {
 	ret := _m.Called(ctx, ad)
@@ -64,9 +146,27 @@ func (_m *MockAdvertisementRepository) Create(ctx context.Context, ad *models.Ad
 	return r0
 }
 
-// Fetch provides a mock function with given fields: ctx, filter, limit, offset
-func (_m *MockAdvertisementRepository) Fetch(ctx context.Context, filter primitive.M, limit int, offset int) ([]*models.Advertisement, error) {
-	ret := _m.Called(ctx, filter, limit, offset)
+// Delete provides a mock function with given fields: ctx, id
+func (_m *MockAdvertisementRepository) Delete(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Delete")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Fetch provides a mock function with given fields: ctx, query, limit, offset
+func (_m *MockAdvertisementRepository) Fetch(ctx context.Context, query models.AdQuery, limit int, offset int) ([]*models.Advertisement, error) {
+	ret := _m.Called(ctx, query, limit, offset)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Fetch")
@@ -74,19 +174,49 @@ func (_m *MockAdvertisementRepository) Fetch(ctx context.Context, filter primiti
 
 	var r0 []*models.Advertisement
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, primitive.M, int, int) ([]*models.Advertisement, error)); ok {
-		return rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, int, int) ([]*models.Advertisement, error)); ok {
+		return rf(ctx, query, limit, offset)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, primitive.M, int, int) []*models.Advertisement); ok {
-		r0 = rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, int, int) []*models.Advertisement); ok {
+		r0 = rf(ctx, query, limit, offset)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*models.Advertisement)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, primitive.M, int, int) error); ok {
-		r1 = rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(1).(func(context.Context, models.AdQuery, int, int) error); ok {
+		r1 = rf(ctx, query, limit, offset)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetByID provides a mock function with given fields: ctx, id
+func (_m *MockAdvertisementRepository) GetByID(ctx context.Context, id string) (*models.Advertisement, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetByID")
+	}
+
+	var r0 *models.Advertisement
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.Advertisement, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.Advertisement); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Advertisement)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -94,6 +224,52 @@ func (_m *MockAdvertisementRepository) Fetch(ctx context.Context, filter primiti
 	return r0, r1
 }
 
+// NextChange provides a mock function with given fields: ctx, query
+func (_m *MockAdvertisementRepository) NextChange(ctx context.Context, query models.AdQuery) (time.Time, error) {
+	ret := _m.Called(ctx, query)
+
+	if len(ret) == 0 {
+		panic("no return value specified for NextChange")
+	}
+
+	var r0 time.Time
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery) (time.Time, error)); ok {
+		return rf(ctx, query)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery) time.Time); ok {
+		r0 = rf(ctx, query)
+	} else {
+		r0 = ret.Get(0).(time.Time)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.AdQuery) error); ok {
+		r1 = rf(ctx, query)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// Update provides a mock function with given fields: ctx, id, ad
+func (_m *MockAdvertisementRepository) Update(ctx context.Context, id string, ad *models.Advertisement) error {
+	ret := _m.Called(ctx, id, ad)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Update")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.Advertisement) error); ok {
+		r0 = rf(ctx, id, ad)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // NewMockAdvertisementRepository creates a new instance of MockAdvertisementRepository. It also registers a testing interface on the mock and a cleanup function to assert the mocks expectations.
 // The first argument is typically a *testing.T value.
 func NewMockAdvertisementRepository(t interface {
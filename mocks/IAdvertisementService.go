@@ -8,8 +8,6 @@ import (
 
 	mock "github.com/stretchr/testify/mock"
 
-	primitive "go.mongodb.org/mongo-driver/bson/primitive"
-
 	time "time"
 )
 
@@ -46,6 +44,34 @@ func (_m *MockAdvertisementService) CountActive(ctx context.Context, now time.Ti
 	return r0, r1
 }
 
+// CountCreatedSince provides a mock function with given fields: ctx, since
+func (_m *MockAdvertisementService) CountCreatedSince(ctx context.Context, since time.Time) (int, error) {
+	ret := _m.Called(ctx, since)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CountCreatedSince")
+	}
+
+	var r0 int
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) (int, error)); ok {
+		return rf(ctx, since)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, time.Time) int); ok {
+		r0 = rf(ctx, since)
+	} else {
+		r0 = ret.Get(0).(int)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, time.Time) error); ok {
+		r1 = rf(ctx, since)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // Create provides a mock function with given fields: ctx, ad
 func (_m *MockAdvertisementService) Create(ctx context.Context, ad *models.Advertisement) error {
 	ret := _m.Called(ctx, ad)
@@ -64,6 +90,42 @@ func (_m *MockAdvertisementService) Create(ctx context.Context, ad *models.Adver
 	return r0
 }
 
+// CreateWithQuota provides a mock function with given fields: ctx, ad, now
+func (_m *MockAdvertisementService) CreateWithQuota(ctx context.Context, ad *models.Advertisement, now time.Time) error {
+	ret := _m.Called(ctx, ad, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for CreateWithQuota")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, *models.Advertisement, time.Time) error); ok {
+		r0 = rf(ctx, ad, now)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Delete provides a mock function with given fields: ctx, id
+func (_m *MockAdvertisementService) Delete(ctx context.Context, id string) error {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Delete")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) error); ok {
+		r0 = rf(ctx, id)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // DeleteAdsByPattern provides a mock function with given fields: ctx, pattern
 func (_m *MockAdvertisementService) DeleteAdsByPattern(ctx context.Context, pattern string) error {
 	ret := _m.Called(ctx, pattern)
@@ -82,9 +144,9 @@ func (_m *MockAdvertisementService) DeleteAdsByPattern(ctx context.Context, patt
 	return r0
 }
 
-// Fetch provides a mock function with given fields: ctx, filter, limit, offset
-func (_m *MockAdvertisementService) Fetch(ctx context.Context, filter primitive.M, limit int, offset int) ([]*models.Advertisement, error) {
-	ret := _m.Called(ctx, filter, limit, offset)
+// Fetch provides a mock function with given fields: ctx, query, limit, offset
+func (_m *MockAdvertisementService) Fetch(ctx context.Context, query models.AdQuery, limit int, offset int) ([]*models.Advertisement, error) {
+	ret := _m.Called(ctx, query, limit, offset)
 
 	if len(ret) == 0 {
 		panic("no return value specified for Fetch")
@@ -92,19 +154,19 @@ func (_m *MockAdvertisementService) Fetch(ctx context.Context, filter primitive.
 
 	var r0 []*models.Advertisement
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, primitive.M, int, int) ([]*models.Advertisement, error)); ok {
-		return rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, int, int) ([]*models.Advertisement, error)); ok {
+		return rf(ctx, query, limit, offset)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, primitive.M, int, int) []*models.Advertisement); ok {
-		r0 = rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, int, int) []*models.Advertisement); ok {
+		r0 = rf(ctx, query, limit, offset)
 	} else {
 		if ret.Get(0) != nil {
 			r0 = ret.Get(0).([]*models.Advertisement)
 		}
 	}
 
-	if rf, ok := ret.Get(1).(func(context.Context, primitive.M, int, int) error); ok {
-		r1 = rf(ctx, filter, limit, offset)
+	if rf, ok := ret.Get(1).(func(context.Context, models.AdQuery, int, int) error); ok {
+		r1 = rf(ctx, query, limit, offset)
 	} else {
 		r1 = ret.Error(1)
 	}
@@ -113,23 +175,23 @@ func (_m *MockAdvertisementService) Fetch(ctx context.Context, filter primitive.
 }
 
 // GetAdsByKey provides a mock function with given fields: ctx, key
-func (_m *MockAdvertisementService) GetAdsByKey(ctx context.Context, key string) ([]*models.Advertisement, error) {
+func (_m *MockAdvertisementService) GetAdsByKey(ctx context.Context, key string) (*models.CachedAds, error) {
 	ret := _m.Called(ctx, key)
 
 	if len(ret) == 0 {
 		panic("no return value specified for GetAdsByKey")
 	}
 
-	var r0 []*models.Advertisement
+	var r0 *models.CachedAds
 	var r1 error
-	if rf, ok := ret.Get(0).(func(context.Context, string) ([]*models.Advertisement, error)); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.CachedAds, error)); ok {
 		return rf(ctx, key)
 	}
-	if rf, ok := ret.Get(0).(func(context.Context, string) []*models.Advertisement); ok {
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.CachedAds); ok {
 		r0 = rf(ctx, key)
 	} else {
 		if ret.Get(0) != nil {
-			r0 = ret.Get(0).([]*models.Advertisement)
+			r0 = ret.Get(0).(*models.CachedAds)
 		}
 	}
 
@@ -170,6 +232,66 @@ func (_m *MockAdvertisementService) GetByDate(ctx context.Context, today string)
 	return r0, r1
 }
 
+// GetByID provides a mock function with given fields: ctx, id
+func (_m *MockAdvertisementService) GetByID(ctx context.Context, id string) (*models.Advertisement, error) {
+	ret := _m.Called(ctx, id)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetByID")
+	}
+
+	var r0 *models.Advertisement
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string) (*models.Advertisement, error)); ok {
+		return rf(ctx, id)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string) *models.Advertisement); ok {
+		r0 = rf(ctx, id)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.Advertisement)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string) error); ok {
+		r1 = rf(ctx, id)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// GetQuota provides a mock function with given fields: ctx, advertiserID, now
+func (_m *MockAdvertisementService) GetQuota(ctx context.Context, advertiserID string, now time.Time) (*models.QuotaUsage, error) {
+	ret := _m.Called(ctx, advertiserID, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for GetQuota")
+	}
+
+	var r0 *models.QuotaUsage
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) (*models.QuotaUsage, error)); ok {
+		return rf(ctx, advertiserID, now)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, time.Time) *models.QuotaUsage); ok {
+		r0 = rf(ctx, advertiserID, now)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).(*models.QuotaUsage)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, time.Time) error); ok {
+		r1 = rf(ctx, advertiserID, now)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
 // IncrByDate provides a mock function with given fields: ctx, key
 func (_m *MockAdvertisementService) IncrByDate(ctx context.Context, key string) error {
 	ret := _m.Called(ctx, key)
@@ -188,6 +310,31 @@ func (_m *MockAdvertisementService) IncrByDate(ctx context.Context, key string)
 	return r0
 }
 
+// InvalidateAds provides a mock function with given fields: ctx, ads
+func (_m *MockAdvertisementService) InvalidateAds(ctx context.Context, ads ...*models.Advertisement) error {
+	_va := make([]interface{}, len(ads))
+	for _i := range ads {
+		_va[_i] = ads[_i]
+	}
+	var _ca []interface{}
+	_ca = append(_ca, ctx)
+	_ca = append(_ca, _va...)
+	ret := _m.Called(_ca...)
+
+	if len(ret) == 0 {
+		panic("no return value specified for InvalidateAds")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, ...*models.Advertisement) error); ok {
+		r0 = rf(ctx, ads...)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
 // IsAdExpired provides a mock function with given fields: ad, now
 func (_m *MockAdvertisementService) IsAdExpired(ad []*models.Advertisement, now time.Time) bool {
 	ret := _m.Called(ad, now)
@@ -206,17 +353,111 @@ func (_m *MockAdvertisementService) IsAdExpired(ad []*models.Advertisement, now
 	return r0
 }
 
-// SetAdsByKey provides a mock function with given fields: ctx, key, ads, expiration
-func (_m *MockAdvertisementService) SetAdsByKey(ctx context.Context, key string, ads []*models.Advertisement, expiration time.Duration) error {
-	ret := _m.Called(ctx, key, ads, expiration)
+// ListingTTL provides a mock function with given fields: ctx, query, maxTTL
+func (_m *MockAdvertisementService) ListingTTL(ctx context.Context, query models.AdQuery, maxTTL time.Duration) (time.Duration, error) {
+	ret := _m.Called(ctx, query, maxTTL)
+
+	if len(ret) == 0 {
+		panic("no return value specified for ListingTTL")
+	}
+
+	var r0 time.Duration
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, time.Duration) (time.Duration, error)); ok {
+		return rf(ctx, query, maxTTL)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, models.AdQuery, time.Duration) time.Duration); ok {
+		r0 = rf(ctx, query, maxTTL)
+	} else {
+		r0 = ret.Get(0).(time.Duration)
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, models.AdQuery, time.Duration) error); ok {
+		r1 = rf(ctx, query, maxTTL)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// RefreshAds provides a mock function with given fields: ctx, key, query, limit, offset, maxTTL, staleTTL, fetchTimeout
+func (_m *MockAdvertisementService) RefreshAds(ctx context.Context, key string, query models.AdQuery, limit int, offset int, maxTTL time.Duration, staleTTL time.Duration, fetchTimeout time.Duration) ([]*models.Advertisement, error) {
+	ret := _m.Called(ctx, key, query, limit, offset, maxTTL, staleTTL, fetchTimeout)
+
+	if len(ret) == 0 {
+		panic("no return value specified for RefreshAds")
+	}
+
+	var r0 []*models.Advertisement
+	var r1 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.AdQuery, int, int, time.Duration, time.Duration, time.Duration) ([]*models.Advertisement, error)); ok {
+		return rf(ctx, key, query, limit, offset, maxTTL, staleTTL, fetchTimeout)
+	}
+	if rf, ok := ret.Get(0).(func(context.Context, string, models.AdQuery, int, int, time.Duration, time.Duration, time.Duration) []*models.Advertisement); ok {
+		r0 = rf(ctx, key, query, limit, offset, maxTTL, staleTTL, fetchTimeout)
+	} else {
+		if ret.Get(0) != nil {
+			r0 = ret.Get(0).([]*models.Advertisement)
+		}
+	}
+
+	if rf, ok := ret.Get(1).(func(context.Context, string, models.AdQuery, int, int, time.Duration, time.Duration, time.Duration) error); ok {
+		r1 = rf(ctx, key, query, limit, offset, maxTTL, staleTTL, fetchTimeout)
+	} else {
+		r1 = ret.Error(1)
+	}
+
+	return r0, r1
+}
+
+// SetAdsByKey provides a mock function with given fields: ctx, key, cached, expiration
+func (_m *MockAdvertisementService) SetAdsByKey(ctx context.Context, key string, cached *models.CachedAds, expiration time.Duration) error {
+	ret := _m.Called(ctx, key, cached, expiration)
 
 	if len(ret) == 0 {
 		panic("no return value specified for SetAdsByKey")
 	}
 
 	var r0 error
-	if rf, ok := ret.Get(0).(func(context.Context, string, []*models.Advertisement, time.Duration) error); ok {
-		r0 = rf(ctx, key, ads, expiration)
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.CachedAds, time.Duration) error); ok {
+		r0 = rf(ctx, key, cached, expiration)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// Update provides a mock function with given fields: ctx, id, ad
+func (_m *MockAdvertisementService) Update(ctx context.Context, id string, ad *models.Advertisement) error {
+	ret := _m.Called(ctx, id, ad)
+
+	if len(ret) == 0 {
+		panic("no return value specified for Update")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.Advertisement) error); ok {
+		r0 = rf(ctx, id, ad)
+	} else {
+		r0 = ret.Error(0)
+	}
+
+	return r0
+}
+
+// UpdateWithQuota provides a mock function with given fields: ctx, id, ad, now
+func (_m *MockAdvertisementService) UpdateWithQuota(ctx context.Context, id string, ad *models.Advertisement, now time.Time) error {
+	ret := _m.Called(ctx, id, ad, now)
+
+	if len(ret) == 0 {
+		panic("no return value specified for UpdateWithQuota")
+	}
+
+	var r0 error
+	if rf, ok := ret.Get(0).(func(context.Context, string, *models.Advertisement, time.Time) error); ok {
+		r0 = rf(ctx, id, ad, now)
 	} else {
 		r0 = ret.Error(0)
 	}
@@ -2,10 +2,14 @@ package redis
 
 import (
 	"sort"
+	"strings"
 )
 
+// KeyPrefix starts every key generated by GenerateRedisKey.
+const KeyPrefix = "ads"
+
 func GenerateRedisKey(key map[string]string) string {
-	redisKey := "ads"
+	redisKey := KeyPrefix
 
 	// Create a slice of keys and sort it
 	keys := make([]string, 0, len(key))
@@ -21,3 +25,18 @@ func GenerateRedisKey(key map[string]string) string {
 
 	return redisKey
 }
+
+// ParseRedisKey returns the query parameters a key generated by GenerateRedisKey was built from,
+// and false if key was not generated by it.
+func ParseRedisKey(redisKey string) (map[string]string, bool) {
+	parts := strings.Split(redisKey, ":")
+	if parts[0] != KeyPrefix || len(parts)%2 != 1 {
+		return nil, false
+	}
+
+	params := make(map[string]string, len(parts)/2)
+	for i := 1; i < len(parts); i += 2 {
+		params[parts[i]] = parts[i+1]
+	}
+	return params, true
+}
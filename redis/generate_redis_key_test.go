@@ -0,0 +1,60 @@
+package redis_test
+
+import (
+	"testing"
+	"time"
+
+	"ad-service-api/internal/models"
+	"ad-service-api/redis"
+
+	"github.com/stretchr/testify/assert"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+func TestGenerateRedisKey(t *testing.T) {
+	key := redis.GenerateRedisKey(map[string]string{"offset": "0", "limit": "5", "country": "JP,TW"})
+	assert.Equal(t, "ads:country:JP,TW:limit:5:offset:0", key, "expected the params in sorted order")
+	assert.Equal(t, "ads", redis.GenerateRedisKey(nil))
+}
+
+func TestParseRedisKey_RoundTrip(t *testing.T) {
+	cursor := models.Cursor{EndAt: time.Now(), ID: primitive.NewObjectID()}.Encode()
+
+	tests := []struct {
+		name   string
+		params map[string]string
+	}{
+		{"no params", map[string]string{}},
+		{"paging", map[string]string{"limit": "5", "offset": "0"}},
+		{"multiple values", map[string]string{"country": "JP,TW", "platform": "android,ios", "limit": "5", "offset": "0"}},
+		{"negated values", map[string]string{"country!": "TW", "platform!": "ios,web", "gender": "F", "limit": "5", "offset": "0"}},
+		{"cursor", map[string]string{"cursor": cursor, "limit": "5"}},
+		{"versions and languages", map[string]string{"osVersion": "17.2.0", "appVersion": "3.0.0", "language": "en,zh-TW", "region": "TW-TPE", "limit": "5", "offset": "0"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			params, ok := redis.ParseRedisKey(redis.GenerateRedisKey(tt.params))
+			assert.True(t, ok)
+			assert.Equal(t, tt.params, params)
+		})
+	}
+}
+
+func TestParseRedisKey_Query(t *testing.T) {
+	params, ok := redis.ParseRedisKey("ads:country:JP,TW:limit:5:offset:0:platform!:web")
+	assert.True(t, ok)
+
+	query := models.NewAdQuery(params, time.Time{})
+	assert.Equal(t, []string{"JP", "TW"}, query.Country)
+	assert.Equal(t, []string{"web"}, query.NotPlatform)
+	assert.True(t, query.MatchesConditions(models.Conditions{Country: []string{"TW"}, Platform: []string{"ios"}}))
+	assert.False(t, query.MatchesConditions(models.Conditions{Country: []string{"TW"}, Platform: []string{"web"}}))
+}
+
+func TestParseRedisKey_Invalid(t *testing.T) {
+	for _, key := range []string{"", "quota:lock", "2024-01-01", "ads:country", "refresh:ads:limit:5"} {
+		_, ok := redis.ParseRedisKey(key)
+		assert.False(t, ok, key)
+	}
+}
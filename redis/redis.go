@@ -2,13 +2,14 @@ package redis
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/go-redis/redis/v8"
 )
 
-func ConnectRedis(addr string, password string, db int) (*redis.Client, error) {
+// ConnectRedis creates a Redis client and pings the server.
+func ConnectRedis(ctx context.Context, addr string, password string, db int) (*redis.Client, error) {
 	var RDB *redis.Client
-	ctx := context.Background()
 	// Initialize Redis client
 	RDB = redis.NewClient(&redis.Options{
 		Addr:     addr,     // replace with your Redis server address
@@ -19,7 +20,8 @@ func ConnectRedis(addr string, password string, db int) (*redis.Client, error) {
 	// Ping Redis to check connection
 	_, err := RDB.Ping(ctx).Result()
 	if err != nil {
-		panic(err)
+		RDB.Close()
+		return nil, fmt.Errorf("failed to ping redis at %s: %w", addr, err)
 	}
 
 	return RDB, nil